@@ -21,8 +21,9 @@ func main() {
 	// Set Gin to release mode in production
 	gin.SetMode(cfg.GinMode)
 
-	// Initialize Gin router
-	r := api.Init(cfg)
+	// Initialize Gin router and background workers
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	r, waitWorkers := api.Init(workersCtx, cfg)
 
 	// Server configuration
 	server := &http.Server{
@@ -53,5 +54,9 @@ func main() {
 		slog.ErrorContext(ctx, "Server forced to shutdown", "error", err.Error())
 	}
 
+	// Stop background workers once no more requests are being served
+	stopWorkers()
+	waitWorkers()
+
 	slog.InfoContext(context.Background(), "Server exiting")
 }
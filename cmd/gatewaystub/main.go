@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/gatewaystub"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/repository"
+)
+
+// Stub payment gateway for running the API and integration tests offline.
+// Point the API at it with GATEWAY_PROVIDER=http. Faults are injected as
+// configured by the GATEWAY_MOCK_* variables.
+func main() {
+	cfg := config.LoadConfig().Gateway
+
+	port := os.Getenv("GATEWAY_STUB_PORT")
+	if port == "" {
+		port = ":9090"
+	}
+
+	server := &http.Server{
+		Addr:    port,
+		Handler: gatewaystub.NewHandler(repository.NewFaultyGatewayClient(cfg.Faults, cfg.Timeout), os.Getenv("GATEWAY_STUB_API_KEY")),
+	}
+
+	go func() {
+		slog.InfoContext(context.Background(), fmt.Sprintf("Stub gateway starting on port %s", port))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.ErrorContext(context.Background(), "Could not start stub gateway", "error", err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.ErrorContext(ctx, "Stub gateway forced to shutdown", "error", err.Error())
+	}
+}
@@ -10,7 +10,7 @@ done
 # Ejecutar migraciones si existen
 if [ -f "/app/migrations/local/init_db.sql" ]; then
   echo "Running database migrations..."
-  PGPASSWORD=postgres psql -h db -U postgres -d wallet_db -f /app/migrations/local/init_db.sql
+  PGPASSWORD=postgres psql -v ON_ERROR_STOP=1 -h db -U postgres -d wallet_db -f /app/migrations/local/init_db.sql
 fi
 
 # Ejecutar la aplicación
@@ -1,8 +1,13 @@
 package api
 
 import (
+	"context"
 	"log"
+	"maps"
+	"slices"
+	"sync"
 
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/handlers"
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/repository"
@@ -10,14 +15,43 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func Init(cfg config.Config) *gin.Engine {
+// Init wires the application and starts its background workers, which run
+// until ctx is cancelled. The returned function blocks until they have stopped.
+func Init(ctx context.Context, cfg config.Config) (*gin.Engine, func()) {
 	storage, err := repository.NewPostgresStorage(cfg.DBConnString)
 	if err != nil {
 		log.Fatal("failed to create database connection pool: ", err)
 	}
-	gatewayClient := repository.NewGatewayClient()
+	CardVaultService, err := services.NewCardVaultService(storage, cfg.CardVault)
+	if err != nil {
+		log.Fatal("failed to create card vault: ", err)
+	}
+	gatewayClient := services.NewRetryingGatewayClient(newGatewayClient(cfg.Gateway, CardVaultService), cfg.Gateway.Retry)
+	gateways := newGatewayClients(cfg.Gateway.Name, gatewayClient, cfg.GatewayRouting.Providers, CardVaultService)
+	paymentMethods := newPaymentMethodRegistry(cfg.PaymentMethods, cfg.Gateway.Name, gateways)
+	routingGatewayClient := services.NewRoutingGatewayClient(storage, paymentMethods, gateways, newGatewayRoutes(cfg.GatewayRouting.Routes, gateways), cfg.Gateway.Name)
 	WalletService := services.NewWalletService(storage)
-	PaymentService := services.NewPaymentService(storage, gatewayClient)
+	PaymentService := services.NewPaymentService(storage, routingGatewayClient)
+	TransferService := services.NewTransferService(storage)
+	FXService := services.NewFXService(storage, storage, cfg.FX)
+	HoldService := services.NewHoldService(storage, cfg.Holds)
+	IdempotencyService := services.NewIdempotencyService(storage, cfg.Idempotency)
+	ContingencyService := services.NewContingencyService(storage, cfg.Contingency)
+	ReconcilerService := services.NewReconcilerService(storage, routingGatewayClient, cfg.Reconciler)
+	AsyncPaymentService := services.NewAsyncPaymentService(PaymentService, cfg.Payments)
+	WebhookService := services.NewWebhookService(storage, cfg.Gateway)
+	WebhookSubscriptionService := services.NewWebhookSubscriptionService(storage)
+	WebhookDispatcher := services.NewWebhookDispatcher(storage, repository.NewWebhookSenderHTTP(cfg.Webhooks.Timeout), cfg.Webhooks)
+
+	// Background workers
+	var workers sync.WaitGroup
+	workers.Go(func() { ContingencyService.Run(ctx) })
+	workers.Go(func() { ReconcilerService.Run(ctx) })
+	workers.Go(func() { HoldService.Run(ctx) })
+	workers.Go(func() { WebhookDispatcher.Run(ctx) })
+	if cfg.Payments.Async {
+		workers.Go(func() { AsyncPaymentService.Run(ctx) })
+	}
 
 	// Initialize Gin with default middleware
 	r := gin.Default()
@@ -29,9 +63,161 @@ func Init(cfg config.Config) *gin.Engine {
 
 	// API v1 routes
 	apiV1 := r.Group("/api/v1")
-	apiV1.POST("/wallets/:user_id/payments", handlers.CreatePayment(PaymentService))
+	createPayment := handlers.CreatePayment(PaymentService, paymentMethods, CardVaultService)
+	if cfg.Payments.Async {
+		createPayment = handlers.CreatePayment(AsyncPaymentService, paymentMethods, CardVaultService)
+	}
+	apiV1.GET("/payment-methods", handlers.GetPaymentMethods(paymentMethods))
+	apiV1.POST("/wallets/:user_id/payments", handlers.Idempotency(IdempotencyService), createPayment)
+	apiV1.POST("/wallets/:user_id/payments/:transaction_id/refunds", handlers.Idempotency(IdempotencyService), handlers.CreateRefund(PaymentService))
+	apiV1.POST("/wallets/:user_id/holds", handlers.Idempotency(IdempotencyService), handlers.CreateHold(HoldService, paymentMethods, CardVaultService))
+	apiV1.POST("/wallets/:user_id/holds/:hold_id/capture", handlers.Idempotency(IdempotencyService), handlers.CaptureHold(PaymentService))
+	apiV1.POST("/wallets/:user_id/holds/:hold_id/void", handlers.VoidHold(HoldService))
+	apiV1.POST("/wallets/:user_id/deposits", handlers.Idempotency(IdempotencyService), handlers.CreateDeposit(PaymentService, paymentMethods, CardVaultService))
+	apiV1.POST("/wallets/:user_id/cards", handlers.CreateCardToken(CardVaultService))
+	apiV1.POST("/wallets/:user_id/transfers", handlers.Idempotency(IdempotencyService), handlers.CreateTransfer(TransferService))
+	apiV1.POST("/wallets/:user_id/fx/quotes", handlers.CreateFXQuote(FXService))
+	apiV1.POST("/wallets/:user_id/fx/exchanges", handlers.Idempotency(IdempotencyService), handlers.ExecuteFXQuote(FXService))
 	apiV1.GET("/wallets/:user_id/balance", handlers.GetBalance(WalletService))
 	apiV1.GET("/wallets/:user_id/transactions", handlers.GetTransactions(WalletService))
+	apiV1.GET("/wallets/:user_id/transactions/:transaction_id", handlers.GetTransaction(WalletService))
+	apiV1.POST("/gateway/webhooks", handlers.GatewayWebhook(WebhookService))
+
+	// Admin routes
+	// TODO: protect admin routes with authentication
+	admin := apiV1.Group("/admin")
+	admin.GET("/contingencies", handlers.GetStuckContingencies(ContingencyService))
+	admin.GET("/gateway", handlers.GetGatewayStatus(newGatewayDiagnostics(gateways)))
+	admin.POST("/webhooks/subscriptions", handlers.CreateWebhookSubscription(WebhookSubscriptionService))
+	admin.GET("/webhooks/subscriptions", handlers.GetWebhookSubscriptions(WebhookSubscriptionService))
+	admin.GET("/webhooks/subscriptions/:subscription_id", handlers.GetWebhookSubscription(WebhookSubscriptionService))
+	admin.PUT("/webhooks/subscriptions/:subscription_id", handlers.UpdateWebhookSubscription(WebhookSubscriptionService))
+	admin.DELETE("/webhooks/subscriptions/:subscription_id", handlers.DeleteWebhookSubscription(WebhookSubscriptionService))
+	admin.GET("/webhooks/dead-letters", handlers.GetWebhookDeadLetters(WebhookDispatcher))
+
+	return r, workers.Wait
+}
+
+// newGatewayClient returns the gateway client selected by the configuration,
+// which resolves card tokens with cards
+func newGatewayClient(cfg config.GatewayConfig, cards repository.CardResolver) services.GatewayClient {
+	switch cfg.Provider {
+	case config.GatewayProviderHTTP:
+		return repository.NewGatewayClientHTTP(cfg.Name, cfg.BaseURL, cfg.APIKey, cfg.Timeout, cards)
+	case config.GatewayProviderMock:
+		return repository.NewFaultyGatewayClient(cfg.Faults, cfg.Timeout)
+	default:
+		log.Fatalf("unknown gateway provider %q", cfg.Provider)
+		return nil
+	}
+}
+
+// newGatewayClients returns the main gateway client and one client for each
+// extra provider by name, every one with its own retries and circuit breaker
+func newGatewayClients(mainGateway string, mainClient services.GatewayClient, providers []config.GatewayConfig, cards repository.CardResolver) map[string]services.GatewayClient {
+	gateways := map[string]services.GatewayClient{mainGateway: mainClient}
+	for _, provider := range providers {
+		if _, ok := gateways[provider.Name]; ok {
+			log.Fatalf("gateway %q configured twice", provider.Name)
+		}
+		gateways[provider.Name] = services.NewRetryingGatewayClient(newGatewayClient(provider, cards), provider.Retry)
+	}
+
+	return gateways
+}
+
+// newGatewayDiagnostics returns the gateways that report their status, which
+// are all of them since each one has its own circuit breaker
+func newGatewayDiagnostics(gateways map[string]services.GatewayClient) map[string]handlers.GatewayDiagnostics {
+	diagnostics := make(map[string]handlers.GatewayDiagnostics, len(gateways))
+	for name, gateway := range gateways {
+		if gatewayDiagnostics, ok := gateway.(handlers.GatewayDiagnostics); ok {
+			diagnostics[name] = gatewayDiagnostics
+		}
+	}
+
+	return diagnostics
+}
+
+// newGatewayRoutes parses the configured routes, which may only send payments
+// to known gateways
+func newGatewayRoutes(cfg []config.GatewayRouteConfig, gateways map[string]services.GatewayClient) []services.GatewayRoute {
+	routes := make([]services.GatewayRoute, 0, len(cfg))
+	for i, routeCfg := range cfg {
+		route := services.GatewayRoute{
+			Method:   routeCfg.Method,
+			Currency: routeCfg.Currency,
+			Failover: routeCfg.Failover,
+		}
+		if route.Currency != "" {
+			currency, err := internal.ParseCurrency(route.Currency)
+			if err != nil {
+				log.Fatalf("gateway route %d: %v", i, err)
+			}
+			route.Currency = currency
+		}
+		if (routeCfg.MinAmount != "" || routeCfg.MaxAmount != "") && route.Currency == "" {
+			log.Fatalf("gateway route %d: an amount band requires a currency", i)
+		}
+		route.MinAmount = parseRouteAmount(i, routeCfg.MinAmount, route.Currency)
+		route.MaxAmount = parseRouteAmount(i, routeCfg.MaxAmount, route.Currency)
+
+		names := slices.Sorted(maps.Keys(routeCfg.Gateways))
+		if len(names) == 0 {
+			log.Fatalf("gateway route %d has no gateways", i)
+		}
+		for _, name := range names {
+			weight := routeCfg.Gateways[name]
+			if _, ok := gateways[name]; !ok || weight <= 0 {
+				log.Fatalf("gateway route %d: unknown gateway %q or weight %d not positive", i, name, weight)
+			}
+			route.Gateways = append(route.Gateways, services.WeightedGateway{Name: name, Weight: weight})
+		}
+		if route.Failover != "" {
+			if _, ok := gateways[route.Failover]; !ok {
+				log.Fatalf("gateway route %d: unknown failover gateway %q", i, route.Failover)
+			}
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// parseRouteAmount parses a bound of the amount band of a route, nil when it
+// is not set
+func parseRouteAmount(route int, amount string, currency string) *internal.Money {
+	if amount == "" {
+		return nil
+	}
+
+	money, err := internal.ParseMoney(amount, currency)
+	if err != nil {
+		log.Fatalf("gateway route %d: %v", route, err)
+	}
+	return &money
+}
+
+// newPaymentMethodRegistry enables the payment methods of the configuration,
+// each one processed by its configured gateway or by defaultGateway
+func newPaymentMethodRegistry(cfg config.PaymentMethodConfig, defaultGateway string, gateways map[string]services.GatewayClient) *internal.PaymentMethodRegistry {
+	methods := make([]internal.PaymentMethod, 0, len(cfg.Enabled))
+	for _, name := range cfg.Enabled {
+		method, ok := internal.PaymentMethodDefinitions[name]
+		if !ok {
+			log.Fatalf("unknown payment method %q", name)
+		}
+
+		method.Gateway = defaultGateway
+		if gateway, ok := cfg.Gateways[name]; ok {
+			method.Gateway = gateway
+		}
+		if _, ok := gateways[method.Gateway]; !ok {
+			log.Fatalf("payment method %q uses unknown gateway %q", name, method.Gateway)
+		}
+		methods = append(methods, method)
+	}
 
-	return r
+	return internal.NewPaymentMethodRegistry(methods...)
 }
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidCard       = errors.New("invalid card")
+	ErrCardNotFound      = errors.New("card not found")
+	ErrCardVaultDisabled = errors.New("card vault not configured")
+	ErrCardUnresolved    = errors.New("card could not be resolved for the gateway")
+)
+
+// CardTokenField is the detail of card payments carrying the token of a
+// vaulted card
+const CardTokenField = "card_token"
+
+const maxCardHolderNameLength = 100
+
+// Card is the data of a payment card. Only the card vault and the gateway
+// client handle it. It formats itself masked so the number never reaches a
+// log line.
+type Card struct {
+	Number      string
+	ExpiryMonth int
+	ExpiryYear  int
+	HolderName  string
+}
+
+// NormalizeCardNumber removes the spaces and dashes a card number is often
+// written with
+func NormalizeCardNumber(number string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(number)
+}
+
+// Validate checks the number passes the Luhn check, the card has not expired
+// by now and it has a holder. Cards are valid until the end of their expiry
+// month. Errors never include the card number.
+func (c Card) Validate(now time.Time) error {
+	if len(c.Number) < 12 || len(c.Number) > 19 || !luhn(c.Number) {
+		return fmt.Errorf("%w: invalid card number", ErrInvalidCard)
+	}
+	if c.ExpiryMonth < 1 || c.ExpiryMonth > 12 || c.ExpiryYear < 2000 || c.ExpiryYear > 9999 {
+		return fmt.Errorf("%w: invalid expiry %02d/%d", ErrInvalidCard, c.ExpiryMonth, c.ExpiryYear)
+	}
+	expiresAt := time.Date(c.ExpiryYear, time.Month(c.ExpiryMonth)+1, 1, 0, 0, 0, 0, time.UTC)
+	if !now.Before(expiresAt) {
+		return fmt.Errorf("%w: card expired in %02d/%d", ErrInvalidCard, c.ExpiryMonth, c.ExpiryYear)
+	}
+	holderName := strings.TrimSpace(c.HolderName)
+	if holderName == "" || utf8.RuneCountInString(holderName) > maxCardHolderNameLength {
+		return fmt.Errorf("%w: holder name must have between 1 and %d characters", ErrInvalidCard, maxCardHolderNameLength)
+	}
+
+	return nil
+}
+
+// Last4 returns the last four digits of the number, the only part of it
+// that may be shown or stored in clear
+func (c Card) Last4() string {
+	if len(c.Number) < 4 {
+		return ""
+	}
+	return c.Number[len(c.Number)-4:]
+}
+
+func (c Card) String() string {
+	return "card ending in " + c.Last4()
+}
+
+func (c Card) GoString() string {
+	return c.String()
+}
+
+func (c Card) LogValue() slog.Value {
+	return slog.StringValue(c.String())
+}
+
+// CardToken is an opaque reference to a card in the vault, which card
+// payments carry in their card_token detail. It is safe to show and log.
+type CardToken struct {
+	Token       string    `json:"token"`
+	UserID      uint64    `json:"user_id"`
+	Last4       string    `json:"last4"`
+	ExpiryMonth int       `json:"expiry_month"`
+	ExpiryYear  int       `json:"expiry_year"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// VaultedCard is a card as the vault stores it, with its number and holder
+// name only in EncryptedCard
+type VaultedCard struct {
+	CardToken
+	EncryptedCard []byte
+}
+
+// luhn reports whether a string of digits passes the Luhn check
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			return false
+		}
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,103 @@
+package internal_test
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCard_Validate(t *testing.T) {
+	now := time.Date(2026, time.March, 15, 12, 0, 0, 0, time.UTC)
+	valid := internal.Card{Number: "4242424242424242", ExpiryMonth: 12, ExpiryYear: 2030, HolderName: "Ada Lovelace"}
+
+	tests := []struct {
+		name          string
+		modify        func(*internal.Card)
+		expectedError error
+	}{
+		{
+			name:   "valid card",
+			modify: func(c *internal.Card) {},
+		},
+		{
+			name:   "card expiring this month is still valid",
+			modify: func(c *internal.Card) { c.ExpiryMonth, c.ExpiryYear = 3, 2026 },
+		},
+		{
+			name:          "card expired last month",
+			modify:        func(c *internal.Card) { c.ExpiryMonth, c.ExpiryYear = 2, 2026 },
+			expectedError: internal.ErrInvalidCard,
+		},
+		{
+			name:          "number failing the Luhn check",
+			modify:        func(c *internal.Card) { c.Number = "4242424242424241" },
+			expectedError: internal.ErrInvalidCard,
+		},
+		{
+			name:          "number with letters",
+			modify:        func(c *internal.Card) { c.Number = "42424242424242a2" },
+			expectedError: internal.ErrInvalidCard,
+		},
+		{
+			name:          "number too short",
+			modify:        func(c *internal.Card) { c.Number = "42" },
+			expectedError: internal.ErrInvalidCard,
+		},
+		{
+			name:          "invalid expiry month",
+			modify:        func(c *internal.Card) { c.ExpiryMonth = 13 },
+			expectedError: internal.ErrInvalidCard,
+		},
+		{
+			name:          "two digit expiry year",
+			modify:        func(c *internal.Card) { c.ExpiryYear = 30 },
+			expectedError: internal.ErrInvalidCard,
+		},
+		{
+			name:          "missing holder name",
+			modify:        func(c *internal.Card) { c.HolderName = "  " },
+			expectedError: internal.ErrInvalidCard,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card := valid
+			tt.modify(&card)
+
+			err := card.Validate(now)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.NotContains(t, err.Error(), card.Number)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCard_NeverFormatsTheNumber(t *testing.T) {
+	card := internal.Card{Number: internal.NormalizeCardNumber("4242 4242-4242 4242"), ExpiryMonth: 12, ExpiryYear: 2030, HolderName: "Ada Lovelace"}
+	assert.Equal(t, "4242424242424242", card.Number)
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	logger.Info("charging", "card", card)
+
+	for _, formatted := range []string{
+		fmt.Sprintf("%v", card),
+		fmt.Sprintf("%+v", card),
+		fmt.Sprintf("%#v", card),
+		fmt.Sprint(card),
+		logs.String(),
+	} {
+		assert.NotContains(t, formatted, card.Number)
+		assert.Contains(t, formatted, "card ending in 4242")
+	}
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentMethodConfig_PerScope(t *testing.T) {
+	t.Setenv("PAYMENT_METHODS_STAGING", "card")
+	t.Setenv("PAYMENT_METHODS_PRODUCTION", "")
+
+	local := paymentMethodConfig(LocalScope, internal.PaymentMethodAccount, internal.PaymentMethodCard)
+	staging := paymentMethodConfig(StagingScope, internal.PaymentMethodAccount, internal.PaymentMethodCard)
+	production := paymentMethodConfig(ProductionScope, internal.PaymentMethodAccount)
+
+	assert.Equal(t, []string{internal.PaymentMethodAccount, internal.PaymentMethodCard}, local.Enabled)
+	assert.Equal(t, []string{internal.PaymentMethodCard}, staging.Enabled)
+	assert.Equal(t, []string{internal.PaymentMethodAccount}, production.Enabled)
+}
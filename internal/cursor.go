@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// TransactionCursor marks the last transaction of a page. Listings are
+// sorted by creation time and ID, newest first, so the next page starts with
+// the transactions sorted after it.
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// CursorAfter returns the cursor of the page ending with transaction
+func CursorAfter(transaction Transaction) TransactionCursor {
+	return TransactionCursor{CreatedAt: transaction.CreatedAt, ID: transaction.ID}
+}
+
+// Encode returns the cursor as an opaque string clients pass back unchanged
+func (c TransactionCursor) Encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeTransactionCursor parses a cursor returned by Encode, whose ID must be
+// a transaction UUID
+func DecodeTransactionCursor(cursor string) (TransactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return TransactionCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+	}
+
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return TransactionCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+	}
+	createdAt, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return TransactionCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return TransactionCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+	}
+
+	return TransactionCursor{CreatedAt: time.UnixMicro(createdAt).UTC(), ID: id}, nil
+}
@@ -0,0 +1,28 @@
+package internal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionCursor_RoundTrip(t *testing.T) {
+	cursor := internal.CursorAfter(internal.Transaction{
+		ID:        "550e8400-e29b-41d4-a716-446655440000",
+		CreatedAt: time.Date(2025, 11, 30, 14, 30, 0, 123456000, time.UTC),
+	})
+
+	decoded, err := internal.DecodeTransactionCursor(cursor.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+}
+
+func TestDecodeTransactionCursor_Invalid(t *testing.T) {
+	for _, cursor := range []string{"not base64!", "bm8tc2VwYXJhdG9y", "YWJjOmlk", "MTIzOg", "MTIzOmlk"} {
+		_, err := internal.DecodeTransactionCursor(cursor)
+		assert.ErrorIs(t, err, internal.ErrInvalidCursor, cursor)
+	}
+}
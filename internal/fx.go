@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	ErrInvalidRate    = errors.New("invalid exchange rate")
+	ErrRateNotFound   = errors.New("exchange rate not found")
+	ErrQuoteNotFound  = errors.New("fx quote not found")
+	ErrQuoteNotActive = errors.New("fx quote expired or was already executed")
+)
+
+// RateDecimals is the number of decimal places of exchange rates
+const RateDecimals = 8
+
+// Rate is the amount of one currency bought by one unit of another. It is
+// kept as an integer scaled by 10^RateDecimals, so converting with it never
+// suffers float rounding drift.
+type Rate int64
+
+// ParseRate parses a positive decimal string such as "0.92" into a Rate
+func ParseRate(rate string) (Rate, error) {
+	value, err := parseDecimal(rate, RateDecimals)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidRate, err)
+	}
+	if value <= 0 {
+		return 0, fmt.Errorf("%w: %q is not positive", ErrInvalidRate, rate)
+	}
+
+	return Rate(value), nil
+}
+
+// String formats the rate as a decimal number with RateDecimals places
+func (r Rate) String() string {
+	return formatDecimal(int64(r), RateDecimals)
+}
+
+// MarshalJSON encodes the rate as a JSON number, e.g. 0.92000000
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Convert returns amount converted into currency at rate r. The result is
+// rounded down to the minor units of currency, so the platform never gives
+// away more than the rate allows.
+func (r Rate) Convert(amount Money, currency string) (Money, error) {
+	fromMinorUnits, err := CurrencyMinorUnits(amount.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	toMinorUnits, err := CurrencyMinorUnits(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	numerator := new(big.Int).Mul(big.NewInt(amount.MinorUnits), big.NewInt(int64(r)))
+	numerator.Mul(numerator, pow10(toMinorUnits))
+	denominator := new(big.Int).Mul(pow10(RateDecimals), pow10(fromMinorUnits))
+
+	converted := numerator.Quo(numerator, denominator)
+	if !converted.IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s %s is out of range in %s", ErrInvalidAmount, amount, amount.Currency, currency)
+	}
+
+	return NewMoney(converted.Int64(), currency), nil
+}
+
+// FXQuoteRequest asks for the rate to sell SellAmount for BuyCurrency
+type FXQuoteRequest struct {
+	UserID      uint64 `json:"user_id"`
+	SellAmount  Money  `json:"sell_amount"`
+	BuyCurrency string `json:"buy_currency"`
+}
+
+// FXQuote locks the rate to exchange SellAmount for BuyAmount between two
+// balances of a wallet until ExpiresAt. Executing it records an fx_out and an
+// fx_in transaction, both linked to the quote through their transfer ID.
+type FXQuote struct {
+	ID                    string     `json:"id"`
+	UserID                uint64     `json:"user_id"`
+	SellAmount            Money      `json:"sell_amount"`
+	SellCurrency          string     `json:"sell_currency"`
+	BuyAmount             Money      `json:"buy_amount"`
+	BuyCurrency           string     `json:"buy_currency"`
+	Rate                  Rate       `json:"rate"`
+	OutgoingTransactionID string     `json:"outgoing_transaction_id,omitempty"`
+	IncomingTransactionID string     `json:"incoming_transaction_id,omitempty"`
+	ExpiresAt             time.Time  `json:"expires_at"`
+	ExecutedAt            *time.Time `json:"executed_at,omitempty"`
+	CreatedAt             time.Time  `json:"created_at"`
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
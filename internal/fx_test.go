@@ -0,0 +1,154 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		name          string
+		rate          string
+		expected      internal.Rate
+		expectedError error
+	}{
+		{
+			name:     "decimal rate",
+			rate:     "0.92",
+			expected: internal.Rate(92000000),
+		},
+		{
+			name:     "integer rate",
+			rate:     "150",
+			expected: internal.Rate(15000000000),
+		},
+		{
+			name:     "eight decimal places",
+			rate:     "1.08695652",
+			expected: internal.Rate(108695652),
+		},
+		{
+			name:          "too many decimal places",
+			rate:          "1.086956521",
+			expectedError: internal.ErrInvalidRate,
+		},
+		{
+			name:          "zero rate",
+			rate:          "0",
+			expectedError: internal.ErrInvalidRate,
+		},
+		{
+			name:          "negative rate",
+			rate:          "-0.92",
+			expectedError: internal.ErrInvalidRate,
+		},
+		{
+			name:          "not a number",
+			rate:          "abc",
+			expectedError: internal.ErrInvalidRate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := internal.ParseRate(tt.rate)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRate_Convert(t *testing.T) {
+	tests := []struct {
+		name          string
+		rate          string
+		amount        internal.Money
+		currency      string
+		expected      internal.Money
+		expectedError error
+	}{
+		{
+			name:     "same minor units",
+			rate:     "0.92",
+			amount:   internal.NewMoney(10050, "USD"),
+			currency: "EUR",
+			expected: internal.NewMoney(9246, "EUR"),
+		},
+		{
+			name:     "rounds down to the minor unit",
+			rate:     "1.08695652",
+			amount:   internal.NewMoney(1000, "EUR"),
+			currency: "USD",
+			expected: internal.NewMoney(1086, "USD"),
+		},
+		{
+			name:     "into a zero decimal currency",
+			rate:     "150.25",
+			amount:   internal.NewMoney(1001, "USD"),
+			currency: "JPY",
+			expected: internal.NewMoney(1504, "JPY"),
+		},
+		{
+			name:     "from a zero decimal currency",
+			rate:     "0.00665557",
+			amount:   internal.NewMoney(1500, "JPY"),
+			currency: "USD",
+			expected: internal.NewMoney(998, "USD"),
+		},
+		{
+			name:     "into a three decimal currency",
+			rate:     "0.3075",
+			amount:   internal.NewMoney(10000, "USD"),
+			currency: "KWD",
+			expected: internal.NewMoney(30750, "KWD"),
+		},
+		{
+			name:          "unsupported currency",
+			rate:          "1",
+			amount:        internal.NewMoney(100, "USD"),
+			currency:      "XXX",
+			expectedError: internal.ErrUnsupportedCurrency,
+		},
+		{
+			name:          "out of range",
+			rate:          "1000000",
+			amount:        internal.NewMoney(1<<62, "USD"),
+			currency:      "EUR",
+			expectedError: internal.ErrInvalidAmount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := internal.ParseRate(tt.rate)
+			assert.NoError(t, err)
+
+			result, err := rate.Convert(tt.amount, tt.currency)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRate_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Rate internal.Rate `json:"rate"`
+	}{Rate: internal.Rate(92000000)})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"rate": 0.92}`, string(data))
+	assert.Equal(t, "0.92000000", internal.Rate(92000000).String())
+}
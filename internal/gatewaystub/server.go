@@ -0,0 +1,203 @@
+// Package gatewaystub serves a fake payment gateway over the JSON HTTP API
+// spoken by repository.GatewayClientHTTP, so the whole stack can run offline
+package gatewaystub
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+)
+
+const (
+	statusApproved = "approved"
+	statusDeclined = "declined"
+	statusPending  = "pending"
+)
+
+// Gateway decides the outcome of the requests received by the stub
+type Gateway interface {
+	CreatePayment(ctx context.Context, transactionID string, paymentRequest internal.PaymentRequest) (internal.GatewayResponse, error)
+	CreateDeposit(ctx context.Context, transactionID string, depositRequest internal.DepositRequest) (internal.GatewayResponse, error)
+	Refund(ctx context.Context, transactionID string, refundRequest internal.RefundRequest) (internal.GatewayResponse, error)
+	GetPaymentStatus(ctx context.Context, transaction internal.Transaction) (internal.GatewayResponse, error)
+}
+
+type chargeRequest struct {
+	TransactionID string            `json:"transaction_id"`
+	UserID        uint64            `json:"user_id"`
+	Method        string            `json:"method"`
+	Amount        json.Number       `json:"amount"`
+	Currency      string            `json:"currency"`
+	Details       map[string]string `json:"details"`
+	Card          *cardRequest      `json:"card"`
+}
+
+type cardRequest struct {
+	Number      string `json:"number"`
+	ExpiryMonth int    `json:"expiry_month"`
+	ExpiryYear  int    `json:"expiry_year"`
+	HolderName  string `json:"holder_name"`
+}
+
+type refundRequest struct {
+	TransactionID        string      `json:"transaction_id"`
+	PaymentTransactionID string      `json:"payment_transaction_id"`
+	Amount               json.Number `json:"amount"`
+	Currency             string      `json:"currency"`
+}
+
+type transactionResponse struct {
+	Reference    string `json:"reference"`
+	Status       string `json:"status"`
+	ResponseCode string `json:"response_code,omitempty"`
+}
+
+type errorResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// NewHandler serves the gateway API backed by gateway. When apiKey is not
+// empty requests must send it as a bearer token.
+func NewHandler(gateway Gateway, apiKey string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/payments", func(w http.ResponseWriter, r *http.Request) {
+		var request chargeRequest
+		transactionID, amount, ok := decodeCharge(w, r, &request)
+		if !ok {
+			return
+		}
+		response, err := gateway.CreatePayment(r.Context(), transactionID, internal.PaymentRequest{
+			UserID:  request.UserID,
+			Method:  request.Method,
+			Amount:  amount,
+			Details: request.Details,
+		})
+		writeOutcome(w, r, response, err)
+	})
+
+	mux.HandleFunc("POST /v1/deposits", func(w http.ResponseWriter, r *http.Request) {
+		var request chargeRequest
+		transactionID, amount, ok := decodeCharge(w, r, &request)
+		if !ok {
+			return
+		}
+		response, err := gateway.CreateDeposit(r.Context(), transactionID, internal.DepositRequest{
+			UserID:  request.UserID,
+			Method:  request.Method,
+			Amount:  amount,
+			Details: request.Details,
+		})
+		writeOutcome(w, r, response, err)
+	})
+
+	mux.HandleFunc("POST /v1/refunds", func(w http.ResponseWriter, r *http.Request) {
+		var request refundRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		amount, err := internal.ParseMoney(request.Amount.String(), request.Currency)
+		if err != nil || request.TransactionID == "" || request.PaymentTransactionID == "" {
+			writeError(w, http.StatusBadRequest, "invalid_request", "transaction_id, payment_transaction_id, amount and currency are required")
+			return
+		}
+		response, err := gateway.Refund(r.Context(), request.TransactionID, internal.RefundRequest{
+			TransactionID: request.PaymentTransactionID,
+			Amount:        amount,
+		})
+		writeOutcome(w, r, response, err)
+	})
+
+	mux.HandleFunc("GET /v1/transactions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		response, err := gateway.GetPaymentStatus(r.Context(), internal.Transaction{ID: r.PathValue("id")})
+		writeOutcome(w, r, response, err)
+	})
+
+	return requireAPIKey(apiKey, mux)
+}
+
+// decodeCharge reads a payment or deposit body, writing a 400 when invalid,
+// card included
+func decodeCharge(w http.ResponseWriter, r *http.Request, request *chargeRequest) (string, internal.Money, bool) {
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return "", internal.Money{}, false
+	}
+	amount, err := internal.ParseMoney(request.Amount.String(), request.Currency)
+	if err != nil || request.TransactionID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "transaction_id, amount and currency are required")
+		return "", internal.Money{}, false
+	}
+	if request.Card != nil {
+		if err := internal.Card(*request.Card).Validate(time.Now()); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_card", err.Error())
+			return "", internal.Money{}, false
+		}
+	}
+	return request.TransactionID, amount, true
+}
+
+// writeOutcome answers with the gateway decision. Declines are regular
+// answers, only unknown transactions and gateway failures are errors.
+func writeOutcome(w http.ResponseWriter, r *http.Request, response internal.GatewayResponse, err error) {
+	switch {
+	case errors.Is(err, internal.ErrGatewayPaymentNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+		return
+	case errors.Is(err, internal.ErrGatewayDeclined):
+		response.Status = internal.PaymentStatusFailed
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Stub gateway failed", "path", r.URL.Path, "error", err.Error())
+		writeError(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+		return
+	}
+
+	status := statusApproved
+	switch response.Status {
+	case internal.PaymentStatusFailed:
+		status = statusDeclined
+	case internal.PaymentStatusPending:
+		status = statusPending
+	}
+
+	writeJSON(w, http.StatusOK, transactionResponse{
+		Reference:    response.Reference,
+		Status:       status,
+		ResponseCode: response.ResponseCode,
+	})
+}
+
+func requireAPIKey(apiKey string, next http.Handler) http.Handler {
+	if apiKey == "" {
+		return next
+	}
+	expected := []byte("Bearer " + apiKey)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid api key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code string, message string) {
+	writeJSON(w, statusCode, errorResponse{Code: code, Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error(fmt.Sprintf("Could not write stub gateway response: %v", err))
+	}
+}
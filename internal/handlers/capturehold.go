@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type CaptureService interface {
+	CapturePayment(ctx context.Context, captureRequest internal.CaptureRequest) (internal.Hold, error)
+}
+
+// CaptureHoldRequest captures the given amount, or the whole hold when the
+// amount is omitted. The amount must be in the currency of the hold.
+type CaptureHoldRequest struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+func CaptureHold(captureService CaptureService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		captureRequest, err := extractCaptureParams(c)
+		if err != nil {
+			handleHoldError(c, err)
+			return
+		}
+
+		hold, err := captureService.CapturePayment(ctx, captureRequest)
+		if err != nil {
+			handleHoldError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, HoldResponse{
+			Status: hold.Status,
+			Hold:   &hold,
+		})
+	}
+}
+
+func extractCaptureParams(c *gin.Context) (internal.CaptureRequest, error) {
+	var requestParams CaptureHoldRequest
+	// The body is optional, an empty one captures the whole hold
+	if err := c.ShouldBindJSON(&requestParams); err != nil && !errors.Is(err, io.EOF) {
+		return internal.CaptureRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	userID, holdID, err := extractHoldParams(c)
+	if err != nil {
+		return internal.CaptureRequest{}, err
+	}
+
+	captureRequest := internal.CaptureRequest{
+		UserID: userID,
+		HoldID: holdID,
+	}
+	if requestParams.Amount == "" {
+		return captureRequest, nil
+	}
+
+	currency, err := internal.ParseCurrency(requestParams.Currency)
+	if err != nil {
+		return internal.CaptureRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	amount, err := internal.ParseMoney(requestParams.Amount.String(), currency)
+	if err != nil {
+		return internal.CaptureRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if !amount.IsPositive() {
+		return internal.CaptureRequest{}, fmt.Errorf("%w: invalid amount %s", ErrInvalidRequest, amount)
+	}
+	captureRequest.Amount = amount
+
+	return captureRequest, nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type CardVault interface {
+	Tokenize(ctx context.Context, userID uint64, card internal.Card) (internal.CardToken, error)
+}
+
+// CreateCardTokenRequest carries the card number, which must never be logged
+// nor stored outside the vault. Hence the endpoint takes no Idempotency-Key,
+// whose middleware stores a hash of the body.
+type CreateCardTokenRequest struct {
+	Number      string `json:"number"`
+	ExpiryMonth int    `json:"expiry_month"`
+	ExpiryYear  int    `json:"expiry_year"`
+	HolderName  string `json:"holder_name"`
+}
+
+type CreateCardTokenResponse struct {
+	Status string              `json:"status"`
+	Card   *internal.CardToken `json:"card,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// CreateCardToken stores a card in the vault and answers with the token card
+// payments take instead of it
+func CreateCardToken(cardVault CardVault) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, card, err := extractCardParams(c)
+		if err != nil {
+			handleCreateCardTokenError(c, err)
+			return
+		}
+
+		cardToken, err := cardVault.Tokenize(ctx, userID, card)
+		if err != nil {
+			handleCreateCardTokenError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, CreateCardTokenResponse{
+			Status: internal.PaymentStatusSuccess,
+			Card:   &cardToken,
+		})
+	}
+}
+
+func handleCreateCardTokenError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+
+	if errors.Is(err, ErrInvalidRequest) || errors.Is(err, internal.ErrInvalidCard) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrCardVaultDisabled) {
+		errorStatusCode = http.StatusServiceUnavailable
+	}
+
+	c.JSON(errorStatusCode, CreateCardTokenResponse{
+		Status: internal.PaymentStatusFailed,
+		Error:  err.Error(),
+	})
+}
+
+func extractCardParams(c *gin.Context) (uint64, internal.Card, error) {
+	var requestParams CreateCardTokenRequest
+	if err := c.ShouldBindJSON(&requestParams); err != nil {
+		return 0, internal.Card{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	userID := c.Param("user_id")
+	userIDInt, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return 0, internal.Card{}, fmt.Errorf("%w: invalid user id %s", ErrInvalidRequest, userID)
+	}
+
+	return userIDInt, internal.Card{
+		Number:      internal.NormalizeCardNumber(requestParams.Number),
+		ExpiryMonth: requestParams.ExpiryMonth,
+		ExpiryYear:  requestParams.ExpiryYear,
+		HolderName:  requestParams.HolderName,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type DepositGatewayService interface {
+	CreateDeposit(ctx context.Context, depositRequest internal.DepositRequest) (string, string, error)
+}
+
+// CreateDepositRequest has the same body as CreatePaymentRequest
+type CreateDepositRequest = CreatePaymentRequest
+
+type CreateDepositResponse struct {
+	Status        string `json:"status"`
+	TransactionID string `json:"transaction_id,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// CreateDeposit answers 200 once the funds are credited, or 202 while the
+// gateway has not collected them yet
+func CreateDeposit(depositService DepositGatewayService, paymentMethods PaymentMethods, cards CardChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		paymentRequest, err := extractRequestParams(c, paymentMethods, cards)
+		if err != nil {
+			handleCreateDepositError(c, err)
+			return
+		}
+
+		transactionID, status, err := depositService.CreateDeposit(ctx, internal.DepositRequest(paymentRequest))
+		if err != nil {
+			handleCreateDepositError(c, err)
+			return
+		}
+
+		c.JSON(paymentStatusCode(status), CreateDepositResponse{
+			Status:        status,
+			TransactionID: transactionID,
+		})
+	}
+}
+
+func handleCreateDepositError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	_ = c.Error(err)
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+
+	if errors.Is(err, ErrInvalidRequest) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrCardUnresolved) {
+		errorStatusCode = http.StatusUnprocessableEntity
+	}
+
+	if errors.Is(err, internal.ErrCardVaultDisabled) {
+		errorStatusCode = http.StatusServiceUnavailable
+	}
+
+	c.JSON(errorStatusCode, CreateDepositResponse{
+		Status: internal.PaymentStatusFailed,
+		Error:  err.Error(),
+	})
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type FXService interface {
+	CreateQuote(ctx context.Context, quoteRequest internal.FXQuoteRequest) (internal.FXQuote, error)
+	ExecuteQuote(ctx context.Context, userID uint64, quoteID string) (internal.FXQuote, error)
+}
+
+// CreateFXQuoteRequest asks how much of BuyCurrency the Amount of
+// SellCurrency buys. SellCurrency defaults to USD.
+type CreateFXQuoteRequest struct {
+	Amount       json.Number `json:"amount"`
+	SellCurrency string      `json:"sell_currency"`
+	BuyCurrency  string      `json:"buy_currency"`
+}
+
+// FXQuoteResponse is returned by the quote and exchange endpoints
+type FXQuoteResponse struct {
+	Status string            `json:"status"`
+	Quote  *internal.FXQuote `json:"quote,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// CreateFXQuote prices a currency exchange and locks its rate for a short
+// time, during which ExecuteFXQuote can carry it out
+func CreateFXQuote(fxService FXService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		quoteRequest, err := extractFXQuoteParams(c)
+		if err != nil {
+			handleFXError(c, err)
+			return
+		}
+
+		quote, err := fxService.CreateQuote(ctx, quoteRequest)
+		if err != nil {
+			handleFXError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, FXQuoteResponse{
+			Status: internal.PaymentStatusSuccess,
+			Quote:  &quote,
+		})
+	}
+}
+
+func handleFXError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	_ = c.Error(err)
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+
+	if errors.Is(err, ErrInvalidRequest) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrQuoteNotFound) || errors.Is(err, internal.ErrWalletNotFound) {
+		errorStatusCode = http.StatusNotFound
+	}
+
+	if errors.Is(err, internal.ErrQuoteNotActive) {
+		errorStatusCode = http.StatusConflict
+	}
+
+	if errors.Is(err, internal.ErrRateNotFound) || errors.Is(err, internal.ErrInvalidAmount) ||
+		errors.Is(err, internal.ErrNotEnoughBalance) {
+		errorStatusCode = http.StatusUnprocessableEntity
+	}
+
+	c.JSON(errorStatusCode, FXQuoteResponse{
+		Status: internal.PaymentStatusFailed,
+		Error:  err.Error(),
+	})
+}
+
+func extractFXQuoteParams(c *gin.Context) (internal.FXQuoteRequest, error) {
+	var requestParams CreateFXQuoteRequest
+	if err := c.ShouldBindJSON(&requestParams); err != nil {
+		return internal.FXQuoteRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	userID := c.Param("user_id")
+	userIDInt, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return internal.FXQuoteRequest{}, fmt.Errorf("%w: invalid user id %s", ErrInvalidRequest, userID)
+	}
+
+	sellCurrency, err := internal.ParseCurrency(requestParams.SellCurrency)
+	if err != nil {
+		return internal.FXQuoteRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if requestParams.BuyCurrency == "" {
+		return internal.FXQuoteRequest{}, fmt.Errorf("%w: buy_currency is required", ErrInvalidRequest)
+	}
+	buyCurrency, err := internal.ParseCurrency(requestParams.BuyCurrency)
+	if err != nil {
+		return internal.FXQuoteRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if sellCurrency == buyCurrency {
+		return internal.FXQuoteRequest{}, fmt.Errorf("%w: cannot exchange %s for itself", ErrInvalidRequest, sellCurrency)
+	}
+
+	amount, err := internal.ParseMoney(requestParams.Amount.String(), sellCurrency)
+	if err != nil {
+		return internal.FXQuoteRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if !amount.IsPositive() {
+		return internal.FXQuoteRequest{}, fmt.Errorf("%w: invalid amount %s", ErrInvalidRequest, amount)
+	}
+
+	return internal.FXQuoteRequest{
+		UserID:      userIDInt,
+		SellAmount:  amount,
+		BuyCurrency: buyCurrency,
+	}, nil
+}
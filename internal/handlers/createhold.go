@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type HoldService interface {
+	AuthorizePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (internal.Hold, error)
+	VoidHold(ctx context.Context, userID uint64, holdID string) (internal.Hold, error)
+}
+
+// HoldResponse is returned by the authorize, capture and void endpoints
+type HoldResponse struct {
+	Status string         `json:"status"`
+	Hold   *internal.Hold `json:"hold,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// CreateHold authorizes a payment, reserving its amount until it is captured,
+// voided or expires. It takes the same body as CreatePayment.
+func CreateHold(holdService HoldService, paymentMethods PaymentMethods, cards CardChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		paymentRequest, err := extractRequestParams(c, paymentMethods, cards)
+		if err != nil {
+			handleHoldError(c, err)
+			return
+		}
+
+		hold, err := holdService.AuthorizePayment(ctx, paymentRequest)
+		if err != nil {
+			handleHoldError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, HoldResponse{
+			Status: hold.Status,
+			Hold:   &hold,
+		})
+	}
+}
+
+func handleHoldError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	_ = c.Error(err)
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+
+	if errors.Is(err, ErrInvalidRequest) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrHoldNotFound) {
+		errorStatusCode = http.StatusNotFound
+	}
+
+	if errors.Is(err, internal.ErrHoldNotActive) {
+		errorStatusCode = http.StatusConflict
+	}
+
+	if errors.Is(err, internal.ErrNotEnoughBalance) || errors.Is(err, internal.ErrCaptureExceedsHold) ||
+		errors.Is(err, internal.ErrCurrencyMismatch) {
+		errorStatusCode = http.StatusUnprocessableEntity
+	}
+
+	if errors.Is(err, internal.ErrCardUnresolved) {
+		errorStatusCode = http.StatusUnprocessableEntity
+	}
+
+	if errors.Is(err, internal.ErrCardVaultDisabled) {
+		errorStatusCode = http.StatusServiceUnavailable
+	}
+
+	c.JSON(errorStatusCode, HoldResponse{
+		Status: internal.PaymentStatusFailed,
+		Error:  err.Error(),
+	})
+}
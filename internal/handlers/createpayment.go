@@ -2,11 +2,11 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"slices"
 	"strconv"
 
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
@@ -18,13 +18,30 @@ var (
 )
 
 type PaymentGatewayService interface {
-	CreatePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error)
+	CreatePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (string, string, error)
 }
 
+// PaymentMethods holds the payment methods enabled in this scope
+type PaymentMethods interface {
+	Validate(paymentRequest internal.PaymentRequest) error
+	List() []internal.PaymentMethod
+}
+
+// CardChecker checks that a card token is a card of the user in the vault
+type CardChecker interface {
+	CheckCard(ctx context.Context, userID uint64, token string) error
+}
+
+// CreatePaymentRequest is the body of payments, deposits and holds. Currency
+// is an ISO 4217 code, USD when omitted, and the amount may not have more
+// decimal places than it allows. Details carries the fields required by the
+// payment method.
 type CreatePaymentRequest struct {
-	UserID uint64  `json:"user_id"`
-	Method string  `json:"method"`
-	Amount float64 `json:"amount"`
+	UserID   uint64            `json:"user_id"`
+	Method   string            `json:"method"`
+	Amount   json.Number       `json:"amount"`
+	Currency string            `json:"currency"`
+	Details  map[string]string `json:"details"`
 }
 
 type CreatePaymentResponse struct {
@@ -33,35 +50,44 @@ type CreatePaymentResponse struct {
 	Error         string `json:"error,omitempty"`
 }
 
-func CreatePayment(paymentsService PaymentGatewayService) gin.HandlerFunc {
+// CreatePayment answers 200 once the gateway charged the payment, or 202 while
+// it is still pending, as with asynchronous payments. The final status of a
+// pending payment is polled at GET /wallets/:user_id/transactions/:transaction_id.
+func CreatePayment(paymentsService PaymentGatewayService, paymentMethods PaymentMethods, cards CardChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
-		requestParams, err := extractRequestParams(c)
+		paymentRequest, err := extractRequestParams(c, paymentMethods, cards)
 		if err != nil {
 			handleCreatePaymentError(c, err)
 			return
 		}
 
-		paymentRequest := internal.PaymentRequest{
-			UserID: requestParams.UserID,
-			Method: requestParams.Method,
-			Amount: requestParams.Amount,
-		}
-		transactionID, err := paymentsService.CreatePayment(ctx, paymentRequest)
+		transactionID, status, err := paymentsService.CreatePayment(ctx, paymentRequest)
 		if err != nil {
 			handleCreatePaymentError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, CreatePaymentResponse{
-			Status:        internal.PaymentStatusSuccess,
+		c.JSON(paymentStatusCode(status), CreatePaymentResponse{
+			Status:        status,
 			TransactionID: transactionID,
 		})
 	}
 }
 
+// paymentStatusCode is 202 for transactions still pending, 200 otherwise
+func paymentStatusCode(status string) int {
+	if status == internal.PaymentStatusPending {
+		return http.StatusAccepted
+	}
+
+	return http.StatusOK
+}
+
 func handleCreatePaymentError(c *gin.Context, err error) {
 	slog.ErrorContext(c.Request.Context(), err.Error())
+	// Read by the idempotency middleware to tell whether a retry may run again
+	_ = c.Error(err)
 	errorStatusCode := http.StatusInternalServerError
 	// TODO: for each error type send it to telemetry service
 
@@ -69,31 +95,71 @@ func handleCreatePaymentError(c *gin.Context, err error) {
 		errorStatusCode = http.StatusBadRequest
 	}
 
+	if errors.Is(err, internal.ErrNotEnoughBalance) {
+		errorStatusCode = http.StatusUnprocessableEntity
+	}
+
+	if errors.Is(err, internal.ErrCardUnresolved) {
+		errorStatusCode = http.StatusUnprocessableEntity
+	}
+
+	if errors.Is(err, internal.ErrCardVaultDisabled) {
+		errorStatusCode = http.StatusServiceUnavailable
+	}
+
 	c.JSON(errorStatusCode, CreatePaymentResponse{
 		Status: internal.PaymentStatusFailed,
 		Error:  err.Error(),
 	})
 }
 
-func extractRequestParams(c *gin.Context) (CreatePaymentRequest, error) {
+// extractRequestParams reads and validates the body of payments, deposits and
+// holds. The card token of card charges must be a card of the user, checked
+// before any transaction is created for it.
+func extractRequestParams(c *gin.Context, paymentMethods PaymentMethods, cards CardChecker) (internal.PaymentRequest, error) {
 	var requestParams CreatePaymentRequest
 	if err := c.ShouldBindJSON(&requestParams); err != nil {
-		return CreatePaymentRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		return internal.PaymentRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
 
 	userID := c.Param("user_id")
 	userIDInt, err := strconv.Atoi(userID)
 	if err != nil {
-		return CreatePaymentRequest{}, fmt.Errorf("%w: invalid user id %s", ErrInvalidRequest, userID)
+		return internal.PaymentRequest{}, fmt.Errorf("%w: invalid user id %s", ErrInvalidRequest, userID)
 	}
-	requestParams.UserID = uint64(userIDInt)
 
-	if !slices.Contains(internal.ValidPaymentMethods, requestParams.Method) {
-		return CreatePaymentRequest{}, fmt.Errorf("%w: invalid payment method %s", ErrInvalidRequest, requestParams.Method)
+	currency, err := internal.ParseCurrency(requestParams.Currency)
+	if err != nil {
+		return internal.PaymentRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
 
-	if requestParams.Amount <= 0 {
-		return CreatePaymentRequest{}, fmt.Errorf("%w: invalid amount %.2f", ErrInvalidRequest, requestParams.Amount)
+	amount, err := internal.ParseMoney(requestParams.Amount.String(), currency)
+	if err != nil {
+		return internal.PaymentRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if !amount.IsPositive() {
+		return internal.PaymentRequest{}, fmt.Errorf("%w: invalid amount %s", ErrInvalidRequest, amount)
+	}
+
+	paymentRequest := internal.PaymentRequest{
+		UserID:  uint64(userIDInt),
+		Method:  requestParams.Method,
+		Amount:  amount,
+		Details: requestParams.Details,
+	}
+	if err := paymentMethods.Validate(paymentRequest); err != nil {
+		return internal.PaymentRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
-	return requestParams, nil
+
+	if token, ok := paymentRequest.Details[internal.CardTokenField]; ok && paymentRequest.Method == internal.PaymentMethodCard {
+		err := cards.CheckCard(c.Request.Context(), paymentRequest.UserID, token)
+		if errors.Is(err, internal.ErrCardNotFound) {
+			return internal.PaymentRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+		if err != nil {
+			return internal.PaymentRequest{}, err
+		}
+	}
+
+	return paymentRequest, nil
 }
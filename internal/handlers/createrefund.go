@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type RefundService interface {
+	CreateRefund(ctx context.Context, refundRequest internal.RefundRequest) (internal.Transaction, error)
+}
+
+// CreateRefundRequest refunds the given amount, or whatever is left of the
+// payment when the amount is omitted. The amount must be in the currency of
+// the payment.
+type CreateRefundRequest struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+type CreateRefundResponse struct {
+	Status string                `json:"status"`
+	Refund *internal.Transaction `json:"refund,omitempty"`
+	Error  string                `json:"error,omitempty"`
+}
+
+// CreateRefund answers 200 once the refund is credited, or 202 while the
+// gateway has not confirmed it yet
+func CreateRefund(refundService RefundService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		refundRequest, err := extractRefundParams(c)
+		if err != nil {
+			handleCreateRefundError(c, err)
+			return
+		}
+
+		refund, err := refundService.CreateRefund(ctx, refundRequest)
+		if err != nil {
+			handleCreateRefundError(c, err)
+			return
+		}
+
+		c.JSON(paymentStatusCode(refund.Status), CreateRefundResponse{
+			Status: refund.Status,
+			Refund: &refund,
+		})
+	}
+}
+
+func handleCreateRefundError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	_ = c.Error(err)
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+
+	if errors.Is(err, ErrInvalidRequest) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrTransactionNotFound) {
+		errorStatusCode = http.StatusNotFound
+	}
+
+	if errors.Is(err, internal.ErrPaymentNotRefundable) {
+		errorStatusCode = http.StatusConflict
+	}
+
+	if errors.Is(err, internal.ErrRefundExceedsPayment) || errors.Is(err, internal.ErrCurrencyMismatch) {
+		errorStatusCode = http.StatusUnprocessableEntity
+	}
+
+	c.JSON(errorStatusCode, CreateRefundResponse{
+		Status: internal.PaymentStatusFailed,
+		Error:  err.Error(),
+	})
+}
+
+func extractRefundParams(c *gin.Context) (internal.RefundRequest, error) {
+	var requestParams CreateRefundRequest
+	// The body is optional, an empty one asks for a full refund
+	if err := c.ShouldBindJSON(&requestParams); err != nil && !errors.Is(err, io.EOF) {
+		return internal.RefundRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	userID := c.Param("user_id")
+	userIDInt, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return internal.RefundRequest{}, fmt.Errorf("%w: invalid user id %s", ErrInvalidRequest, userID)
+	}
+
+	transactionID := c.Param("transaction_id")
+	if _, err := uuid.Parse(transactionID); err != nil {
+		return internal.RefundRequest{}, fmt.Errorf("%w: invalid transaction id %s", ErrInvalidRequest, transactionID)
+	}
+
+	refundRequest := internal.RefundRequest{
+		UserID:        userIDInt,
+		TransactionID: transactionID,
+	}
+	if requestParams.Amount == "" {
+		return refundRequest, nil
+	}
+
+	currency, err := internal.ParseCurrency(requestParams.Currency)
+	if err != nil {
+		return internal.RefundRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	amount, err := internal.ParseMoney(requestParams.Amount.String(), currency)
+	if err != nil {
+		return internal.RefundRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if !amount.IsPositive() {
+		return internal.RefundRequest{}, fmt.Errorf("%w: invalid amount %s", ErrInvalidRequest, amount)
+	}
+	refundRequest.Amount = amount
+
+	return refundRequest, nil
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type TransferService interface {
+	CreateTransfer(ctx context.Context, transferRequest internal.TransferRequest) (internal.Transfer, error)
+}
+
+type CreateTransferRequest struct {
+	RecipientUserID uint64      `json:"recipient_user_id"`
+	Amount          json.Number `json:"amount"`
+	Currency        string      `json:"currency"`
+}
+
+type CreateTransferResponse struct {
+	Status                string `json:"status"`
+	TransferID            string `json:"transfer_id,omitempty"`
+	OutgoingTransactionID string `json:"outgoing_transaction_id,omitempty"`
+	IncomingTransactionID string `json:"incoming_transaction_id,omitempty"`
+	Error                 string `json:"error,omitempty"`
+}
+
+func CreateTransfer(transferService TransferService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		transferRequest, err := extractTransferParams(c)
+		if err != nil {
+			handleCreateTransferError(c, err)
+			return
+		}
+
+		transfer, err := transferService.CreateTransfer(ctx, transferRequest)
+		if err != nil {
+			handleCreateTransferError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, CreateTransferResponse{
+			Status:                internal.PaymentStatusSuccess,
+			TransferID:            transfer.ID,
+			OutgoingTransactionID: transfer.OutgoingTransactionID,
+			IncomingTransactionID: transfer.IncomingTransactionID,
+		})
+	}
+}
+
+func handleCreateTransferError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	_ = c.Error(err)
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+
+	if errors.Is(err, ErrInvalidRequest) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrWalletNotFound) {
+		errorStatusCode = http.StatusNotFound
+	}
+
+	if errors.Is(err, internal.ErrNotEnoughBalance) {
+		errorStatusCode = http.StatusUnprocessableEntity
+	}
+
+	c.JSON(errorStatusCode, CreateTransferResponse{
+		Status: internal.PaymentStatusFailed,
+		Error:  err.Error(),
+	})
+}
+
+func extractTransferParams(c *gin.Context) (internal.TransferRequest, error) {
+	var requestParams CreateTransferRequest
+	if err := c.ShouldBindJSON(&requestParams); err != nil {
+		return internal.TransferRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	userID := c.Param("user_id")
+	userIDInt, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return internal.TransferRequest{}, fmt.Errorf("%w: invalid user id %s", ErrInvalidRequest, userID)
+	}
+
+	if requestParams.RecipientUserID == 0 {
+		return internal.TransferRequest{}, fmt.Errorf("%w: recipient_user_id is required", ErrInvalidRequest)
+	}
+	if requestParams.RecipientUserID == userIDInt {
+		return internal.TransferRequest{}, fmt.Errorf("%w: cannot transfer to the same wallet", ErrInvalidRequest)
+	}
+
+	currency, err := internal.ParseCurrency(requestParams.Currency)
+	if err != nil {
+		return internal.TransferRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	amount, err := internal.ParseMoney(requestParams.Amount.String(), currency)
+	if err != nil {
+		return internal.TransferRequest{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if !amount.IsPositive() {
+		return internal.TransferRequest{}, fmt.Errorf("%w: invalid amount %s", ErrInvalidRequest, amount)
+	}
+
+	return internal.TransferRequest{
+		FromUserID: userIDInt,
+		ToUserID:   requestParams.RecipientUserID,
+		Amount:     amount,
+	}, nil
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ExecuteFXQuoteRequest names the quote to execute
+type ExecuteFXQuoteRequest struct {
+	QuoteID string `json:"quote_id" binding:"required"`
+}
+
+// ExecuteFXQuote exchanges money between two balances of the wallet at the
+// rate locked by a quote that has not expired yet
+func ExecuteFXQuote(fxService FXService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, quoteID, err := extractExecuteFXQuoteParams(c)
+		if err != nil {
+			handleFXError(c, err)
+			return
+		}
+
+		quote, err := fxService.ExecuteQuote(ctx, userID, quoteID)
+		if err != nil {
+			handleFXError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, FXQuoteResponse{
+			Status: internal.PaymentStatusSuccess,
+			Quote:  &quote,
+		})
+	}
+}
+
+func extractExecuteFXQuoteParams(c *gin.Context) (uint64, string, error) {
+	var requestParams ExecuteFXQuoteRequest
+	if err := c.ShouldBindJSON(&requestParams); err != nil {
+		return 0, "", fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	userID := c.Param("user_id")
+	userIDInt, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: invalid user id %s", ErrInvalidRequest, userID)
+	}
+
+	if _, err := uuid.Parse(requestParams.QuoteID); err != nil {
+		return 0, "", fmt.Errorf("%w: invalid quote id %s", ErrInvalidRequest, requestParams.QuoteID)
+	}
+
+	return userIDInt, requestParams.QuoteID, nil
+}
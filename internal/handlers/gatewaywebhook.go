@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhookSize caps how much of a webhook body is read
+const maxWebhookSize = 1 << 20
+
+type GatewayWebhookService interface {
+	ProcessWebhook(ctx context.Context, payload []byte, timestamp string, signature string) error
+}
+
+type GatewayWebhookResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// GatewayWebhook receives the payment outcomes notified by the gateway. The
+// body is signed with the headers X-Webhook-Timestamp and X-Webhook-Signature.
+func GatewayWebhook(webhookService GatewayWebhookService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookSize))
+		if err != nil {
+			handleGatewayWebhookError(c, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+			return
+		}
+
+		err = webhookService.ProcessWebhook(ctx, payload, c.GetHeader(internal.WebhookTimestampHeader), c.GetHeader(internal.WebhookSignatureHeader))
+		if err != nil {
+			handleGatewayWebhookError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, GatewayWebhookResponse{
+			Status: internal.PaymentStatusSuccess,
+		})
+	}
+}
+
+func handleGatewayWebhookError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+	// telemetry.Incr("metric_name", "error_type", errType)
+
+	if errors.Is(err, ErrInvalidRequest) || errors.Is(err, internal.ErrInvalidWebhookEvent) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrInvalidWebhookSignature) {
+		errorStatusCode = http.StatusUnauthorized
+	}
+
+	if errors.Is(err, internal.ErrTransactionNotFound) {
+		errorStatusCode = http.StatusNotFound
+	}
+
+	c.JSON(errorStatusCode, GatewayWebhookResponse{
+		Status: internal.PaymentStatusFailed,
+		Error:  err.Error(),
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,12 +17,15 @@ var (
 )
 
 type WalletService interface {
-	GetBalance(ctx context.Context, userID uint64) (float64, error)
+	GetBalance(ctx context.Context, userID uint64) ([]internal.Balance, error)
 }
 
+// GetBalanceResponse reports, for each currency of the wallet, the ledger
+// balance along with the part of it held by authorizations and the part
+// still available
 type GetBalanceResponse struct {
-	Balance float64 `json:"balance"`
-	Error   string  `json:"error,omitempty"`
+	Balances []internal.Balance `json:"balances,omitempty"`
+	Error    string             `json:"error,omitempty"`
 }
 
 func GetBalance(walletService WalletService) gin.HandlerFunc {
@@ -35,7 +39,7 @@ func GetBalance(walletService WalletService) gin.HandlerFunc {
 			return
 		}
 
-		balance, err := walletService.GetBalance(ctx, userIDInt)
+		balances, err := walletService.GetBalance(ctx, userIDInt)
 		if err != nil {
 			err = fmt.Errorf("%w: %w", ErrGettingBalance, err)
 			handleGetBalanceError(c, err)
@@ -43,7 +47,7 @@ func GetBalance(walletService WalletService) gin.HandlerFunc {
 		}
 
 		c.JSON(http.StatusOK, GetBalanceResponse{
-			Balance: balance,
+			Balances: balances,
 		})
 	}
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type ContingencyService interface {
+	GetStuckContingencies(ctx context.Context) ([]internal.PaymentContingency, error)
+}
+
+var (
+	ErrGettingContingencies = errors.New("failed to get contingencies")
+)
+
+type GetContingenciesResponse struct {
+	Contingencies []internal.PaymentContingency `json:"contingencies"`
+	Error         string                        `json:"error,omitempty"`
+}
+
+func GetStuckContingencies(contingencyService ContingencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		contingencies, err := contingencyService.GetStuckContingencies(ctx)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrGettingContingencies, err)
+			handleGetContingenciesError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, GetContingenciesResponse{
+			Contingencies: contingencies,
+		})
+	}
+}
+
+func handleGetContingenciesError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	// TODO: for each error type send it to telemetry service
+	// telemetry.Incr("metric_name", "error_type", errType)
+
+	c.JSON(http.StatusInternalServerError, GetContingenciesResponse{
+		Error: err.Error(),
+	})
+}
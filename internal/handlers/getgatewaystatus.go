@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type GatewayDiagnostics interface {
+	CircuitBreakerStatus() internal.CircuitBreakerStatus
+}
+
+type GatewayStatus struct {
+	CircuitBreaker internal.CircuitBreakerStatus `json:"circuit_breaker"`
+}
+
+type GetGatewayStatusResponse struct {
+	Gateways map[string]GatewayStatus `json:"gateways"`
+}
+
+// GetGatewayStatus reports the status of every gateway by name
+func GetGatewayStatus(gateways map[string]GatewayDiagnostics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := GetGatewayStatusResponse{Gateways: make(map[string]GatewayStatus, len(gateways))}
+		for name, gatewayDiagnostics := range gateways {
+			response.Gateways[name] = GatewayStatus{
+				CircuitBreaker: gatewayDiagnostics.CircuitBreakerStatus(),
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type GetPaymentMethodsResponse struct {
+	PaymentMethods []internal.PaymentMethod `json:"payment_methods"`
+}
+
+// GetPaymentMethods lists the payment methods enabled in this scope, with the
+// details and amounts each one takes
+func GetPaymentMethods(paymentMethods PaymentMethods) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, GetPaymentMethodsResponse{
+			PaymentMethods: paymentMethods.List(),
+		})
+	}
+}
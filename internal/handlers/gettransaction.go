@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type TransactionDetailService interface {
+	GetTransaction(ctx context.Context, userID uint64, transactionID string) (internal.TransactionDetail, error)
+}
+
+var (
+	ErrGettingTransaction = errors.New("failed to get transaction")
+)
+
+type GetTransactionResponse struct {
+	Transaction *internal.TransactionDetail `json:"transaction,omitempty"`
+	Error       string                      `json:"error,omitempty"`
+}
+
+// GetTransaction returns a transaction of the wallet with its status history
+// and linked transactions, so clients of asynchronous payments can poll for
+// its final status
+func GetTransaction(transactionService TransactionDetailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID := c.Param("user_id")
+		userIDInt, err := strconv.ParseUint(userID, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("%w: invalid user_id: %w", ErrInvalidRequest, err)
+			handleGetTransactionError(c, err)
+			return
+		}
+
+		transactionID := c.Param("transaction_id")
+		if _, err := uuid.Parse(transactionID); err != nil {
+			err = fmt.Errorf("%w: invalid transaction_id %s", ErrInvalidRequest, transactionID)
+			handleGetTransactionError(c, err)
+			return
+		}
+
+		transaction, err := transactionService.GetTransaction(ctx, userIDInt, transactionID)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrGettingTransaction, err)
+			handleGetTransactionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, GetTransactionResponse{
+			Transaction: &transaction,
+		})
+	}
+}
+
+func handleGetTransactionError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+	// telemetry.Incr("metric_name", "error_type", errType)
+
+	if errors.Is(err, ErrInvalidRequest) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrTransactionNotFound) {
+		errorStatusCode = http.StatusNotFound
+	}
+
+	c.JSON(errorStatusCode, GetTransactionResponse{
+		Error: err.Error(),
+	})
+}
@@ -6,25 +6,34 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
 	"github.com/gin-gonic/gin"
 )
 
 type TransactionService interface {
-	GetTransactions(ctx context.Context, userID uint64) ([]internal.Transaction, error)
+	GetTransactions(ctx context.Context, userID uint64, filter internal.TransactionFilter) (internal.TransactionPage, error)
 }
 
 var (
 	ErrGettingTransactions = errors.New("failed to get transactions")
 )
 
+// maxTransactionsLimit caps the page size clients may ask for
+const maxTransactionsLimit = 100
+
 type GetTransactionsResponse struct {
 	Transactions []internal.Transaction `json:"transactions"`
+	NextCursor   string                 `json:"next_cursor,omitempty"`
 	Error        string                 `json:"error,omitempty"`
 }
 
+// GetTransactions lists the wallet's transactions, newest first, one page at
+// a time. The next_cursor of a response is passed as cursor to get the next
+// page.
 func GetTransactions(transactionService TransactionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
@@ -36,7 +45,13 @@ func GetTransactions(transactionService TransactionService) gin.HandlerFunc {
 			return
 		}
 
-		transactions, err := transactionService.GetTransactions(ctx, userIDInt)
+		filter, err := extractTransactionFilter(c)
+		if err != nil {
+			handleGetTransactionsError(c, err)
+			return
+		}
+
+		page, err := transactionService.GetTransactions(ctx, userIDInt, filter)
 		if err != nil {
 			err = fmt.Errorf("%w: %w", ErrGettingTransactions, err)
 			handleGetTransactionsError(c, err)
@@ -44,11 +59,100 @@ func GetTransactions(transactionService TransactionService) gin.HandlerFunc {
 		}
 
 		c.JSON(http.StatusOK, GetTransactionsResponse{
-			Transactions: transactions,
+			Transactions: page.Transactions,
+			NextCursor:   page.NextCursor,
 		})
 	}
 }
 
+// extractTransactionFilter reads the filters and paging of a transaction
+// listing from the query string
+func extractTransactionFilter(c *gin.Context) (internal.TransactionFilter, error) {
+	filter := internal.TransactionFilter{
+		Type:   c.Query("type"),
+		Status: c.Query("status"),
+	}
+	if filter.Type != "" && !slices.Contains(internal.TransactionTypes, filter.Type) {
+		return internal.TransactionFilter{}, fmt.Errorf("%w: invalid type %s", ErrInvalidRequest, filter.Type)
+	}
+	if filter.Status != "" && !slices.Contains(internal.PaymentStatuses, filter.Status) {
+		return internal.TransactionFilter{}, fmt.Errorf("%w: invalid status %s", ErrInvalidRequest, filter.Status)
+	}
+	if currency := c.Query("currency"); currency != "" {
+		var err error
+		if filter.Currency, err = internal.ParseCurrency(currency); err != nil {
+			return internal.TransactionFilter{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil || limitInt < 1 || limitInt > maxTransactionsLimit {
+			return internal.TransactionFilter{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidRequest, maxTransactionsLimit)
+		}
+		filter.Limit = limitInt
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := internal.DecodeTransactionCursor(cursor)
+		if err != nil {
+			return internal.TransactionFilter{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+		filter.After = &after
+	}
+
+	var err error
+	if filter.MinAmount, err = queryMoney(c, "min_amount", filter.Currency); err != nil {
+		return internal.TransactionFilter{}, err
+	}
+	if filter.MaxAmount, err = queryMoney(c, "max_amount", filter.Currency); err != nil {
+		return internal.TransactionFilter{}, err
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return internal.TransactionFilter{}, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return internal.TransactionFilter{}, err
+	}
+
+	return filter, nil
+}
+
+// queryMoney parses an optional amount query parameter in currency. Amounts
+// in different currencies cannot be compared, so the listing must be
+// filtered by one.
+func queryMoney(c *gin.Context, param string, currency string) (*internal.Money, error) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, nil
+	}
+	if currency == "" {
+		return nil, fmt.Errorf("%w: %s requires currency", ErrInvalidRequest, param)
+	}
+
+	amount, err := internal.ParseMoney(value, currency)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s: %w", ErrInvalidRequest, param, err)
+	}
+
+	return &amount, nil
+}
+
+// queryTime parses an optional RFC 3339 time query parameter
+func queryTime(c *gin.Context, param string) (*time.Time, error) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s, expected RFC 3339: %w", ErrInvalidRequest, param, err)
+	}
+
+	return &parsed, nil
+}
+
 func handleGetTransactionsError(c *gin.Context, err error) {
 	slog.ErrorContext(c.Request.Context(), err.Error())
 	errorStatusCode := http.StatusInternalServerError
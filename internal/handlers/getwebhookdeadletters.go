@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookDispatcher interface {
+	GetDeadDeliveries(ctx context.Context) ([]internal.WebhookDelivery, error)
+}
+
+var (
+	ErrGettingWebhookDeliveries = errors.New("failed to get webhook deliveries")
+)
+
+type GetWebhookDeadLettersResponse struct {
+	Deliveries []internal.WebhookDelivery `json:"deliveries"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+// GetWebhookDeadLetters lists the webhook deliveries that ran out of attempts
+func GetWebhookDeadLetters(dispatcher WebhookDispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		deliveries, err := dispatcher.GetDeadDeliveries(ctx)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrGettingWebhookDeliveries, err)
+			handleGetWebhookDeadLettersError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, GetWebhookDeadLettersResponse{
+			Deliveries: deliveries,
+		})
+	}
+}
+
+func handleGetWebhookDeadLettersError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	// TODO: for each error type send it to telemetry service
+	// telemetry.Incr("metric_name", "error_type", errType)
+
+	c.JSON(http.StatusInternalServerError, GetWebhookDeadLettersResponse{
+		Error: err.Error(),
+	})
+}
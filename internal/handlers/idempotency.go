@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyReplayMimeType = "application/json; charset=utf-8"
+)
+
+type IdempotencyService interface {
+	Begin(ctx context.Context, userID uint64, key string, requestHash string) (*internal.IdempotencyKey, error)
+	Complete(ctx context.Context, userID uint64, key string, statusCode int, responseBody []byte) error
+	Release(ctx context.Context, userID uint64, key string) error
+}
+
+type IdempotencyErrorResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// Idempotency makes the wrapped handler safe to retry. Requests carrying an
+// Idempotency-Key header run once per user and key; retries with the same
+// body replay the stored response and its status code, server errors
+// included. Only requests that failed before changing anything, as reported
+// by the error the handler attached to the context, are not stored, so
+// retrying them runs the request again.
+func Idempotency(idempotencyService IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		if len(key) > maxIdempotencyKeyLength {
+			handleIdempotencyError(c, fmt.Errorf("%w: %s header longer than %d characters", ErrInvalidRequest, IdempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		userID := c.Param("user_id")
+		userIDInt, err := strconv.ParseUint(userID, 10, 64)
+		if err != nil {
+			handleIdempotencyError(c, fmt.Errorf("%w: invalid user_id: %w", ErrInvalidRequest, err))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			handleIdempotencyError(c, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		stored, err := idempotencyService.Begin(ctx, userIDInt, key, hashRequest(c, body))
+		if err != nil {
+			handleIdempotencyError(c, err)
+			return
+		}
+
+		if stored != nil {
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(stored.StatusCode, idempotencyReplayMimeType, stored.ResponseBody)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Request = c.Request.WithContext(internal.WithIdempotencyKey(ctx, userIDInt, key))
+		c.Next()
+
+		// The response must be stored even if the client already went away,
+		// otherwise the key would stay in progress until its lease expires
+		ctx = context.WithoutCancel(ctx)
+		if lastErr := c.Errors.Last(); lastErr != nil && failedBeforeSideEffects(lastErr) {
+			err = idempotencyService.Release(ctx, userIDInt, key)
+		} else {
+			err = idempotencyService.Complete(ctx, userIDInt, key, recorder.Status(), recorder.body.Bytes())
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, err.Error())
+		}
+	}
+}
+
+func handleIdempotencyError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+
+	if errors.Is(err, ErrInvalidRequest) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrIdempotencyKeyReused) || errors.Is(err, internal.ErrIdempotencyKeyInProgress) {
+		errorStatusCode = http.StatusConflict
+	}
+
+	c.AbortWithStatusJSON(errorStatusCode, IdempotencyErrorResponse{
+		Status: internal.PaymentStatusFailed,
+		Error:  err.Error(),
+	})
+}
+
+// failedBeforeSideEffects reports whether a request failed before charging,
+// debiting or reserving anything, so running it again is safe: the request was
+// invalid, the balance did not cover it, the card vault was off, the card
+// could not be resolved or the payment never reached the gateway
+func failedBeforeSideEffects(err error) bool {
+	return errors.Is(err, ErrInvalidRequest) ||
+		errors.Is(err, internal.ErrNotEnoughBalance) ||
+		errors.Is(err, internal.ErrCardVaultDisabled) ||
+		errors.Is(err, internal.ErrCardUnresolved) ||
+		errors.Is(err, internal.ErrGatewayNotReached) ||
+		errors.Is(err, internal.ErrGatewayCircuitOpen)
+}
+
+// hashRequest fingerprints the request so a key cannot be reused for a
+// different endpoint or body. The actual path is used so that, for instance,
+// captures of two different holds do not share a fingerprint.
+func hashRequest(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body written by the handler
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func VoidHold(holdService HoldService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, holdID, err := extractHoldParams(c)
+		if err != nil {
+			handleHoldError(c, err)
+			return
+		}
+
+		hold, err := holdService.VoidHold(ctx, userID, holdID)
+		if err != nil {
+			handleHoldError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, HoldResponse{
+			Status: hold.Status,
+			Hold:   &hold,
+		})
+	}
+}
+
+func extractHoldParams(c *gin.Context) (uint64, string, error) {
+	userID := c.Param("user_id")
+	userIDInt, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: invalid user id %s", ErrInvalidRequest, userID)
+	}
+
+	holdID := c.Param("hold_id")
+	if _, err := uuid.Parse(holdID); err != nil {
+		return 0, "", fmt.Errorf("%w: invalid hold id %s", ErrInvalidRequest, holdID)
+	}
+
+	return userIDInt, holdID, nil
+}
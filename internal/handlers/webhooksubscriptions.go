@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type WebhookSubscriptionService interface {
+	CreateSubscription(ctx context.Context, request internal.WebhookSubscriptionRequest) (internal.WebhookSubscription, error)
+	GetSubscriptions(ctx context.Context) ([]internal.WebhookSubscription, error)
+	GetSubscription(ctx context.Context, subscriptionID string) (internal.WebhookSubscription, error)
+	UpdateSubscription(ctx context.Context, subscriptionID string, request internal.WebhookSubscriptionRequest) (internal.WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, subscriptionID string) error
+}
+
+// WebhookSubscriptionResponse is returned by the webhook subscription endpoints
+type WebhookSubscriptionResponse struct {
+	Subscription  *internal.WebhookSubscription  `json:"subscription,omitempty"`
+	Subscriptions []internal.WebhookSubscription `json:"subscriptions,omitempty"`
+	Error         string                         `json:"error,omitempty"`
+}
+
+// CreateWebhookSubscription registers an endpoint for our webhooks. The
+// response carries the signing secret, which is not shown again.
+func CreateWebhookSubscription(subscriptionService WebhookSubscriptionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var request internal.WebhookSubscriptionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			handleWebhookSubscriptionError(c, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+			return
+		}
+
+		subscription, err := subscriptionService.CreateSubscription(ctx, request)
+		if err != nil {
+			handleWebhookSubscriptionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, WebhookSubscriptionResponse{
+			Subscription: &subscription,
+		})
+	}
+}
+
+func GetWebhookSubscriptions(subscriptionService WebhookSubscriptionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		subscriptions, err := subscriptionService.GetSubscriptions(ctx)
+		if err != nil {
+			handleWebhookSubscriptionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, WebhookSubscriptionResponse{
+			Subscriptions: subscriptions,
+		})
+	}
+}
+
+func GetWebhookSubscription(subscriptionService WebhookSubscriptionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		subscriptionID, err := extractSubscriptionID(c)
+		if err != nil {
+			handleWebhookSubscriptionError(c, err)
+			return
+		}
+
+		subscription, err := subscriptionService.GetSubscription(ctx, subscriptionID)
+		if err != nil {
+			handleWebhookSubscriptionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, WebhookSubscriptionResponse{
+			Subscription: &subscription,
+		})
+	}
+}
+
+// UpdateWebhookSubscription changes the URL of a subscription and, when
+// given, whether it is active
+func UpdateWebhookSubscription(subscriptionService WebhookSubscriptionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		subscriptionID, err := extractSubscriptionID(c)
+		if err != nil {
+			handleWebhookSubscriptionError(c, err)
+			return
+		}
+
+		var request internal.WebhookSubscriptionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			handleWebhookSubscriptionError(c, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+			return
+		}
+
+		subscription, err := subscriptionService.UpdateSubscription(ctx, subscriptionID, request)
+		if err != nil {
+			handleWebhookSubscriptionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, WebhookSubscriptionResponse{
+			Subscription: &subscription,
+		})
+	}
+}
+
+func DeleteWebhookSubscription(subscriptionService WebhookSubscriptionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		subscriptionID, err := extractSubscriptionID(c)
+		if err != nil {
+			handleWebhookSubscriptionError(c, err)
+			return
+		}
+
+		if err := subscriptionService.DeleteSubscription(ctx, subscriptionID); err != nil {
+			handleWebhookSubscriptionError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func extractSubscriptionID(c *gin.Context) (string, error) {
+	subscriptionID := c.Param("subscription_id")
+	if _, err := uuid.Parse(subscriptionID); err != nil {
+		return "", fmt.Errorf("%w: invalid subscription id %s", ErrInvalidRequest, subscriptionID)
+	}
+
+	return subscriptionID, nil
+}
+
+func handleWebhookSubscriptionError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), err.Error())
+	errorStatusCode := http.StatusInternalServerError
+	// TODO: for each error type send it to telemetry service
+	// telemetry.Incr("metric_name", "error_type", errType)
+
+	if errors.Is(err, ErrInvalidRequest) || errors.Is(err, internal.ErrInvalidWebhookURL) {
+		errorStatusCode = http.StatusBadRequest
+	}
+
+	if errors.Is(err, internal.ErrSubscriptionNotFound) {
+		errorStatusCode = http.StatusNotFound
+	}
+
+	c.JSON(errorStatusCode, WebhookSubscriptionResponse{
+		Error: err.Error(),
+	})
+}
@@ -0,0 +1,23 @@
+package internal
+
+import "context"
+
+type idempotencyKeyContextKey struct{}
+
+// requestIdempotencyKey identifies the idempotency key of a request
+type requestIdempotencyKey struct {
+	userID uint64
+	key    string
+}
+
+// WithIdempotencyKey returns a copy of ctx carrying the idempotency key of the
+// request, so storage can tie what the request creates to the key
+func WithIdempotencyKey(ctx context.Context, userID uint64, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, requestIdempotencyKey{userID: userID, key: key})
+}
+
+// IdempotencyKeyFromContext returns the idempotency key carried by ctx, if any
+func IdempotencyKeyFromContext(ctx context.Context) (uint64, string, bool) {
+	requestKey, ok := ctx.Value(idempotencyKeyContextKey{}).(requestIdempotencyKey)
+	return requestKey.userID, requestKey.key, ok
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnbalancedJournalEntry = errors.New("unbalanced journal entry")
+)
+
+const (
+	LedgerAccountTypeWallet = "wallet"
+	LedgerAccountTypeSystem = "system"
+)
+
+// LedgerAccount is an account of the double-entry ledger. Wallet accounts
+// belong to a user, system accounts (UserID zero) hold the other side of
+// money moving in and out of wallets. Every account holds a single currency.
+type LedgerAccount struct {
+	Code   string
+	Type   string
+	UserID uint64
+}
+
+// GatewayClearingAccount holds money sent to or collected from payment gateways
+func GatewayClearingAccount(currency string) LedgerAccount {
+	return LedgerAccount{Code: "system:gateway_clearing:" + currency, Type: LedgerAccountTypeSystem}
+}
+
+// OpeningBalancesAccount offsets balances that existed before the ledger
+func OpeningBalancesAccount(currency string) LedgerAccount {
+	return LedgerAccount{Code: "system:opening_balances:" + currency, Type: LedgerAccountTypeSystem}
+}
+
+// FXAccount holds the currency bought from and sold to users exchanging
+// between their balances
+func FXAccount(currency string) LedgerAccount {
+	return LedgerAccount{Code: "system:fx:" + currency, Type: LedgerAccountTypeSystem}
+}
+
+// WalletAccount returns the ledger account backing a user's wallet in currency
+func WalletAccount(userID uint64, currency string) LedgerAccount {
+	return LedgerAccount{
+		Code:   fmt.Sprintf("wallet:%d:%s", userID, currency),
+		Type:   LedgerAccountTypeWallet,
+		UserID: userID,
+	}
+}
+
+// LedgerPosting moves an amount in or out of an account. Amounts are signed:
+// credits are positive and debits negative, so the balance of an account is
+// the sum of its postings.
+type LedgerPosting struct {
+	Account LedgerAccount
+	Amount  Money
+}
+
+// JournalEntry groups the postings of a single money movement. Its postings
+// must sum to zero.
+type JournalEntry struct {
+	TransactionID string
+	Description   string
+	Postings      []LedgerPosting
+}
+
+// Validate checks the entry has at least two postings in one currency that
+// sum to zero
+func (e JournalEntry) Validate() error {
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("%w: %q needs at least two postings", ErrUnbalancedJournalEntry, e.Description)
+	}
+
+	var total int64
+	currency := e.Postings[0].Amount.Currency
+	for _, posting := range e.Postings {
+		if posting.Amount.Currency != currency {
+			return fmt.Errorf("%w: %q mixes %s and %s", ErrUnbalancedJournalEntry, e.Description, currency, posting.Amount.Currency)
+		}
+		total += posting.Amount.MinorUnits
+	}
+
+	if total != 0 {
+		return fmt.Errorf("%w: %q postings sum to %s", ErrUnbalancedJournalEntry, e.Description, NewMoney(total, currency))
+	}
+
+	return nil
+}
+
+// Reversed returns an entry that undoes e
+func (e JournalEntry) Reversed(description string) JournalEntry {
+	postings := make([]LedgerPosting, len(e.Postings))
+	for i, posting := range e.Postings {
+		postings[i] = LedgerPosting{
+			Account: posting.Account,
+			Amount:  NewMoney(-posting.Amount.MinorUnits, posting.Amount.Currency),
+		}
+	}
+
+	return JournalEntry{
+		TransactionID: e.TransactionID,
+		Description:   description,
+		Postings:      postings,
+	}
+}
+
+// NewTransferEntry moves amount from one account to another
+func NewTransferEntry(transactionID string, description string, from LedgerAccount, to LedgerAccount, amount Money) JournalEntry {
+	return JournalEntry{
+		TransactionID: transactionID,
+		Description:   description,
+		Postings: []LedgerPosting{
+			{Account: from, Amount: NewMoney(-amount.MinorUnits, amount.Currency)},
+			{Account: to, Amount: amount},
+		},
+	}
+}
+
+// NewPaymentEntry debits the user's wallet and credits the gateway clearing account
+func NewPaymentEntry(transactionID string, userID uint64, amount Money) JournalEntry {
+	return NewTransferEntry(transactionID, "payment", WalletAccount(userID, amount.Currency), GatewayClearingAccount(amount.Currency), amount)
+}
+
+// NewDepositEntry debits the gateway clearing account and credits the user's wallet
+func NewDepositEntry(transactionID string, userID uint64, amount Money) JournalEntry {
+	return NewTransferEntry(transactionID, "deposit", GatewayClearingAccount(amount.Currency), WalletAccount(userID, amount.Currency), amount)
+}
+
+// NewRefundEntry debits the gateway clearing account and credits the user's wallet
+func NewRefundEntry(transactionID string, userID uint64, amount Money) JournalEntry {
+	return NewTransferEntry(transactionID, "refund", GatewayClearingAccount(amount.Currency), WalletAccount(userID, amount.Currency), amount)
+}
+
+// NewWalletTransferEntry debits the sender's wallet and credits the recipient's
+func NewWalletTransferEntry(transactionID string, fromUserID uint64, toUserID uint64, amount Money) JournalEntry {
+	return NewTransferEntry(transactionID, "transfer", WalletAccount(fromUserID, amount.Currency), WalletAccount(toUserID, amount.Currency), amount)
+}
+
+// NewFXOutEntry debits the user's wallet in the sold currency and credits the FX account
+func NewFXOutEntry(transactionID string, userID uint64, amount Money) JournalEntry {
+	return NewTransferEntry(transactionID, "fx_out", WalletAccount(userID, amount.Currency), FXAccount(amount.Currency), amount)
+}
+
+// NewFXInEntry debits the FX account and credits the user's wallet in the bought currency
+func NewFXInEntry(transactionID string, userID uint64, amount Money) JournalEntry {
+	return NewTransferEntry(transactionID, "fx_in", FXAccount(amount.Currency), WalletAccount(userID, amount.Currency), amount)
+}
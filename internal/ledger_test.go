@@ -0,0 +1,92 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalEntry_Validate(t *testing.T) {
+	wallet := internal.WalletAccount(1234, "USD")
+
+	tests := []struct {
+		name          string
+		entry         internal.JournalEntry
+		expectedError error
+	}{
+		{
+			name:  "payment entry is balanced",
+			entry: internal.NewPaymentEntry("payment-123", 1234, internal.NewMoney(10050, "USD")),
+		},
+		{
+			name:  "fx out entry is balanced",
+			entry: internal.NewFXOutEntry("fx-out-123", 1234, internal.NewMoney(10000, "USD")),
+		},
+		{
+			name:  "fx in entry is balanced",
+			entry: internal.NewFXInEntry("fx-in-123", 1234, internal.NewMoney(9200, "EUR")),
+		},
+		{
+			name:  "reversed entry is balanced",
+			entry: internal.NewPaymentEntry("payment-123", 1234, internal.NewMoney(10050, "USD")).Reversed("payment reversal"),
+		},
+		{
+			name: "postings do not sum to zero",
+			entry: internal.JournalEntry{
+				Description: "payment",
+				Postings: []internal.LedgerPosting{
+					{Account: wallet, Amount: internal.NewMoney(-10050, "USD")},
+					{Account: internal.GatewayClearingAccount("USD"), Amount: internal.NewMoney(10000, "USD")},
+				},
+			},
+			expectedError: internal.ErrUnbalancedJournalEntry,
+		},
+		{
+			name: "single posting",
+			entry: internal.JournalEntry{
+				Description: "payment",
+				Postings: []internal.LedgerPosting{
+					{Account: wallet, Amount: internal.NewMoney(0, "USD")},
+				},
+			},
+			expectedError: internal.ErrUnbalancedJournalEntry,
+		},
+		{
+			name: "mixed currencies",
+			entry: internal.JournalEntry{
+				Description: "payment",
+				Postings: []internal.LedgerPosting{
+					{Account: wallet, Amount: internal.NewMoney(-100, "USD")},
+					{Account: internal.GatewayClearingAccount("EUR"), Amount: internal.NewMoney(100, "EUR")},
+				},
+			},
+			expectedError: internal.ErrUnbalancedJournalEntry,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.entry.Validate()
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestJournalEntry_Reversed(t *testing.T) {
+	entry := internal.NewPaymentEntry("payment-123", 1234, internal.NewMoney(10050, "USD"))
+
+	reversed := entry.Reversed("payment reversal")
+
+	assert.Equal(t, "payment-123", reversed.TransactionID)
+	assert.Equal(t, "payment reversal", reversed.Description)
+	assert.Equal(t, []internal.LedgerPosting{
+		{Account: internal.WalletAccount(1234, "USD"), Amount: internal.NewMoney(10050, "USD")},
+		{Account: internal.GatewayClearingAccount("USD"), Amount: internal.NewMoney(-10050, "USD")},
+	}, reversed.Postings)
+}
@@ -1,27 +1,313 @@
 package internal
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotEnoughBalance         = errors.New("not enough balance")
+	ErrIdempotencyKeyReused     = errors.New("idempotency key already used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still in progress")
+	ErrTransactionNotPending    = errors.New("transaction is not pending")
+	ErrGatewayPaymentNotFound   = errors.New("payment not found in gateway")
+	ErrGatewayDeclined          = errors.New("payment declined by gateway")
+	ErrGatewayUnavailable       = errors.New("payment gateway unavailable")
+	ErrGatewayRejected          = errors.New("payment gateway rejected the request")
+	ErrGatewayInvalidResponse   = errors.New("invalid payment gateway response")
+	ErrGatewayCircuitOpen       = errors.New("payment gateway circuit breaker is open")
+	ErrGatewayNotReached        = errors.New("request never reached the payment gateway")
+	ErrInvalidWebhookSignature  = errors.New("invalid webhook signature")
+	ErrInvalidWebhookEvent      = errors.New("invalid webhook event")
+	ErrInvalidWebhookURL        = errors.New("webhook url must be an absolute http or https url")
+	ErrWebhookEventDuplicate    = errors.New("webhook event already processed")
+	ErrSubscriptionNotFound     = errors.New("webhook subscription not found")
+	ErrWalletNotFound           = errors.New("wallet not found")
+	ErrTransactionNotFound      = errors.New("transaction not found")
+	ErrPaymentNotRefundable     = errors.New("only successful or partially refunded payments can be refunded")
+	ErrRefundExceedsPayment     = errors.New("refund exceeds the amount left to refund")
+	ErrHoldNotFound             = errors.New("hold not found")
+	ErrHoldNotActive            = errors.New("hold is no longer active")
+	ErrCaptureExceedsHold       = errors.New("capture exceeds the held amount")
+)
+
+// Balance of a wallet in one currency. Balance is the ledger balance, Held
+// the part of it reserved by active holds and Available what can still be
+// spent.
+type Balance struct {
+	Currency  string `json:"currency"`
+	Balance   Money  `json:"balance"`
+	Available Money  `json:"available"`
+	Held      Money  `json:"held"`
+}
 
+// PaymentRequest charges a wallet through a payment gateway. It only debits
+// the wallet's balance in the currency of Amount. Details holds the fields
+// required by the payment method, e.g. the card token.
 type PaymentRequest struct {
-	UserID uint64  `json:"user_id"`
-	Method string  `json:"method"`
-	Amount float64 `json:"amount"`
+	UserID  uint64            `json:"user_id"`
+	Method  string            `json:"method"`
+	Amount  Money             `json:"amount"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// DepositRequest adds money to a wallet, collected through a payment gateway
+type DepositRequest struct {
+	UserID  uint64            `json:"user_id"`
+	Method  string            `json:"method"`
+	Amount  Money             `json:"amount"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// TransferRequest moves money from one wallet to another
+type TransferRequest struct {
+	FromUserID uint64 `json:"from_user_id"`
+	ToUserID   uint64 `json:"to_user_id"`
+	Amount     Money  `json:"amount"`
+}
+
+// Transfer links the transfer_out and transfer_in transactions of a transfer
+type Transfer struct {
+	ID                    string `json:"transfer_id"`
+	OutgoingTransactionID string `json:"outgoing_transaction_id"`
+	IncomingTransactionID string `json:"incoming_transaction_id"`
+}
+
+// RefundRequest returns part or all of a successful payment to the wallet.
+// A zero Amount refunds everything not refunded yet. Method and Gateway are
+// the payment method of the refunded payment and the gateway that charged it.
+type RefundRequest struct {
+	UserID        uint64 `json:"user_id"`
+	TransactionID string `json:"transaction_id"`
+	Amount        Money  `json:"amount"`
+	Method        string `json:"method,omitempty"`
+	Gateway       string `json:"gateway,omitempty"`
+}
+
+// Hold reserves funds of a wallet so they can be captured later. It lowers
+// the available balance but not the ledger balance until it is captured.
+type Hold struct {
+	ID             string    `json:"id"`
+	UserID         uint64    `json:"user_id"`
+	Method         string    `json:"method"`
+	Amount         Money     `json:"amount"`
+	Currency       string    `json:"currency"`
+	CapturedAmount Money     `json:"captured_amount"`
+	Status         string    `json:"status"`
+	TransactionID  string    `json:"transaction_id,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	// Details are the payment method fields, kept to charge the capture
+	Details map[string]string `json:"-"`
+}
+
+// CaptureRequest charges part or all of an active hold. A zero Amount
+// captures the whole hold.
+type CaptureRequest struct {
+	UserID uint64 `json:"user_id"`
+	HoldID string `json:"hold_id"`
+	Amount Money  `json:"amount"`
 }
 
 type Transaction struct {
 	ID        string    `json:"id"`
-	Amount    float64   `json:"amount"`
+	UserID    uint64    `json:"user_id"`
+	Amount    Money     `json:"amount"`
+	Currency  string    `json:"currency"`
 	Type      string    `json:"type"`
 	Status    string    `json:"status"`
+	Method    string    `json:"method,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	GatewayReference    string `json:"gateway_reference,omitempty"`
+	GatewayName         string `json:"gateway_name,omitempty"`
+	GatewayResponseCode string `json:"gateway_response_code,omitempty"`
+
+	// TransferID links the two sides of a transfer, or of a currency
+	// exchange, where it is the ID of the executed quote
+	TransferID            string `json:"transfer_id,omitempty"`
+	OriginalTransactionID string `json:"original_transaction_id,omitempty"`
+	// ExchangeRate is the rate applied to fx_out and fx_in transactions
+	ExchangeRate Rate `json:"exchange_rate,omitempty"`
+}
+
+// TransactionDetail is a transaction with its status history and the
+// transactions linked to it: the refunds of a payment, the payment of a
+// refund or the other side of a transfer or currency exchange
+type TransactionDetail struct {
+	Transaction
+	StatusHistory      []TransactionStatusChange `json:"status_history"`
+	LinkedTransactions []Transaction             `json:"linked_transactions"`
+}
+
+// TransactionFilter narrows a listing of transactions. Empty fields are not
+// applied. From is inclusive and To exclusive. After resumes the listing past
+// a previous page of at most Limit transactions.
+type TransactionFilter struct {
+	Currency  string
+	Type      string
+	Status    string
+	MinAmount *Money
+	MaxAmount *Money
+	From      *time.Time
+	To        *time.Time
+	After     *TransactionCursor
+	Limit     int
+}
+
+// TransactionPage is a page of a transaction listing. NextCursor is empty on
+// the last page.
+type TransactionPage struct {
+	Transactions []Transaction
+	NextCursor   string
+}
+
+// GatewayResponse is what a payment gateway reported for a request. It may be
+// partially filled when the gateway rejected the payment. Status is the
+// outcome reported by the gateway, pending when it has not decided yet.
+type GatewayResponse struct {
+	Gateway      string `json:"gateway,omitempty"`
+	Reference    string `json:"reference,omitempty"`
+	ResponseCode string `json:"response_code,omitempty"`
+	Status       string `json:"status,omitempty"`
+}
+
+// PaymentContingency is a payment, deposit or refund whose final status could
+// not be recorded after the gateway call. It is retried in the background until
+// it sticks. Deposits and refunds carry their user and amount in PaymentRequest
+// as well.
+type PaymentContingency struct {
+	ID              int64           `json:"id"`
+	TransactionID   string          `json:"transaction_id"`
+	TransactionType string          `json:"transaction_type"`
+	PaymentRequest  PaymentRequest  `json:"payment_request"`
+	IntendedStatus  string          `json:"intended_status"`
+	GatewayResponse GatewayResponse `json:"gateway_response"`
+	Attempts        int             `json:"attempts"`
+	LastError       string          `json:"last_error,omitempty"`
+	NextAttemptAt   time.Time       `json:"next_attempt_at"`
+	CreatedAt       time.Time       `json:"created_at"`
 }
 
-var ValidPaymentMethods = []string{
-	"account",
-	"card",
+// GatewayWebhookEvent is a notification from the payment gateway about the
+// outcome of one of our transactions. Status uses the gateway wording:
+// approved, declined or pending.
+type GatewayWebhookEvent struct {
+	ID            string `json:"id"`
+	TransactionID string `json:"transaction_id"`
+	Reference     string `json:"reference"`
+	Status        string `json:"status"`
+	ResponseCode  string `json:"response_code"`
 }
 
+// WebhookEvent is a change published to webhook subscriptions through the
+// outbox. Data holds the changed resource, e.g. the Transaction.
+type WebhookEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// WebhookSubscription is an endpoint that receives every WebhookEvent, signed
+// with Secret. The secret is only shown when the subscription is created.
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookSubscriptionRequest creates or updates a WebhookSubscription. Active
+// defaults to true on creation and is left unchanged on updates when nil.
+type WebhookSubscriptionRequest struct {
+	URL    string `json:"url" binding:"required"`
+	Active *bool  `json:"active"`
+}
+
+// WebhookDelivery is the delivery of an event to a subscription. Failed
+// deliveries are retried until they are dead-lettered.
+type WebhookDelivery struct {
+	ID             int64        `json:"id"`
+	SubscriptionID string       `json:"subscription_id"`
+	URL            string       `json:"url"`
+	Secret         string       `json:"-"`
+	Event          WebhookEvent `json:"event"`
+	Status         string       `json:"status"`
+	Attempts       int          `json:"attempts"`
+	LastError      string       `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time    `json:"next_attempt_at"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// CircuitBreakerStatus reports the circuit breaker guarding the payment
+// gateway. While open, calls fail without reaching the gateway until
+// HalfOpenAt, when a single trial call decides whether it closes again.
+type CircuitBreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	HalfOpenAt          *time.Time `json:"half_open_at,omitempty"`
+}
+
+// IdempotencyKey tracks a request made with an Idempotency-Key header so
+// retries can replay the original response instead of running it again
+type IdempotencyKey struct {
+	UserID       uint64
+	Key          string
+	RequestHash  string
+	StatusCode   int
+	ResponseBody []byte
+	Completed    bool
+	// ResourceID is the first transaction or hold created by the request,
+	// empty while it created none
+	ResourceID string
+}
+
+const (
+	TransactionTypePayment     = "payment"
+	TransactionTypeDeposit     = "deposit"
+	TransactionTypeTransferOut = "transfer_out"
+	TransactionTypeTransferIn  = "transfer_in"
+	TransactionTypeRefund      = "refund"
+	TransactionTypeFXOut       = "fx_out"
+	TransactionTypeFXIn        = "fx_in"
+)
+
+var TransactionTypes = []string{
+	TransactionTypePayment,
+	TransactionTypeDeposit,
+	TransactionTypeTransferOut,
+	TransactionTypeTransferIn,
+	TransactionTypeRefund,
+	TransactionTypeFXOut,
+	TransactionTypeFXIn,
+}
+
+const (
+	HoldStatusActive   = "active"
+	HoldStatusCaptured = "captured"
+	HoldStatusVoided   = "voided"
+	HoldStatusExpired  = "expired"
+)
+
+const (
+	CircuitBreakerClosed   = "closed"
+	CircuitBreakerOpen     = "open"
+	CircuitBreakerHalfOpen = "half_open"
+)
+
+const (
+	WebhookEventTransactionCreated = "transaction.created"
+	WebhookEventTransactionUpdated = "transaction.updated"
+)
+
 const (
-	PaymentStatusSuccess = "success"
-	PaymentStatusFailed  = "failed"
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
 )
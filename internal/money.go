@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount = errors.New("invalid amount")
+
+	errTooManyDecimals = errors.New("too many decimal places")
+)
+
+// Money is an exact monetary amount expressed in the minor units of its
+// currency (e.g. cents for USD), so it never suffers float rounding drift
+type Money struct {
+	MinorUnits int64
+	Currency   string
+}
+
+// NewMoney builds a Money value from an amount already expressed in minor units
+func NewMoney(minorUnits int64, currency string) Money {
+	return Money{MinorUnits: minorUnits, Currency: currency}
+}
+
+// ParseMoney parses a decimal string such as "100.50" into Money. Amounts with
+// more decimal places than the currency allows are rejected.
+func ParseMoney(amount string, currency string) (Money, error) {
+	minorUnits, err := CurrencyMinorUnits(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	value, err := parseDecimal(amount, minorUnits)
+	if errors.Is(err, errTooManyDecimals) {
+		return Money{}, fmt.Errorf("%w: %s allows at most %d decimal places, got %q", ErrInvalidAmount, currency, minorUnits, amount)
+	}
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %w", ErrInvalidAmount, err)
+	}
+
+	return Money{MinorUnits: value, Currency: currency}, nil
+}
+
+// IsPositive reports whether the amount is greater than zero
+func (m Money) IsPositive() bool {
+	return m.MinorUnits > 0
+}
+
+// LessThan reports whether m is smaller than other. Both values are expected
+// to be in the same currency.
+func (m Money) LessThan(other Money) bool {
+	return m.MinorUnits < other.MinorUnits
+}
+
+// String formats the amount as a decimal number using the currency's minor units
+func (m Money) String() string {
+	minorUnits, err := CurrencyMinorUnits(m.Currency)
+	if err != nil {
+		return strconv.FormatInt(m.MinorUnits, 10)
+	}
+
+	return formatDecimal(m.MinorUnits, minorUnits)
+}
+
+// MarshalJSON encodes the amount as a JSON number with exactly the
+// currency's decimal places, e.g. 100.50
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// parseDecimal parses a decimal string into an integer scaled by 10^scale,
+// e.g. "1.5" with scale 2 is 150. Values with more than scale decimal places
+// fail with errTooManyDecimals.
+func parseDecimal(value string, scale int) (int64, error) {
+	digits := value
+	negative := strings.HasPrefix(digits, "-")
+	digits = strings.TrimPrefix(digits, "-")
+
+	integerPart, fractionalPart, hasFraction := strings.Cut(digits, ".")
+	if integerPart == "" || (hasFraction && fractionalPart == "") ||
+		!isDigits(integerPart) || !isDigits(fractionalPart) {
+		return 0, fmt.Errorf("%q is not a decimal number", value)
+	}
+	if len(fractionalPart) > scale {
+		return 0, errTooManyDecimals
+	}
+
+	fractionalPart += strings.Repeat("0", scale-len(fractionalPart))
+	scaled, err := strconv.ParseInt(integerPart+fractionalPart, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is out of range", value)
+	}
+	if negative {
+		scaled = -scaled
+	}
+
+	return scaled, nil
+}
+
+// formatDecimal formats an integer scaled by 10^scale as a decimal string
+// with exactly scale decimal places
+func formatDecimal(value int64, scale int) string {
+	if scale == 0 {
+		return strconv.FormatInt(value, 10)
+	}
+
+	sign := ""
+	magnitude := uint64(value)
+	if value < 0 {
+		sign = "-"
+		magnitude = uint64(-(value + 1)) + 1
+	}
+
+	divisor := uint64(math.Pow10(scale))
+	return fmt.Sprintf("%s%d.%0*d", sign, magnitude/divisor, scale, magnitude%divisor)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
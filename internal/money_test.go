@@ -0,0 +1,165 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name          string
+		amount        string
+		currency      string
+		expected      internal.Money
+		expectedError error
+	}{
+		{
+			name:     "two decimal places",
+			amount:   "100.50",
+			currency: "USD",
+			expected: internal.NewMoney(10050, "USD"),
+		},
+		{
+			name:     "one decimal place",
+			amount:   "0.1",
+			currency: "USD",
+			expected: internal.NewMoney(10, "USD"),
+		},
+		{
+			name:     "integer amount",
+			amount:   "42",
+			currency: "USD",
+			expected: internal.NewMoney(4200, "USD"),
+		},
+		{
+			name:     "zero decimal currency",
+			amount:   "1500",
+			currency: "JPY",
+			expected: internal.NewMoney(1500, "JPY"),
+		},
+		{
+			name:     "three decimal currency",
+			amount:   "12.345",
+			currency: "KWD",
+			expected: internal.NewMoney(12345, "KWD"),
+		},
+		{
+			name:     "negative amount",
+			amount:   "-3.05",
+			currency: "USD",
+			expected: internal.NewMoney(-305, "USD"),
+		},
+		{
+			name:          "too many decimal places",
+			amount:        "100.505",
+			currency:      "USD",
+			expectedError: internal.ErrInvalidAmount,
+		},
+		{
+			name:          "decimals on zero decimal currency",
+			amount:        "10.5",
+			currency:      "JPY",
+			expectedError: internal.ErrInvalidAmount,
+		},
+		{
+			name:          "exponent notation",
+			amount:        "1e2",
+			currency:      "USD",
+			expectedError: internal.ErrInvalidAmount,
+		},
+		{
+			name:          "out of range",
+			amount:        "999999999999999999999",
+			currency:      "USD",
+			expectedError: internal.ErrInvalidAmount,
+		},
+		{
+			name:          "unsupported currency",
+			amount:        "10",
+			currency:      "XXX",
+			expectedError: internal.ErrUnsupportedCurrency,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			money, err := internal.ParseMoney(tt.amount, tt.currency)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, money)
+			}
+		})
+	}
+}
+
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		name          string
+		code          string
+		expected      string
+		expectedError error
+	}{
+		{name: "iso code", code: "EUR", expected: "EUR"},
+		{name: "lower case code", code: "brl", expected: "BRL"},
+		{name: "empty code defaults", code: "", expected: internal.DefaultCurrency},
+		{name: "unknown code", code: "ABC", expectedError: internal.ErrUnsupportedCurrency},
+		{name: "fund code", code: "USN", expectedError: internal.ErrUnsupportedCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			currency, err := internal.ParseCurrency(tt.code)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, currency)
+			}
+		})
+	}
+}
+
+func TestMoney_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		money    internal.Money
+		expected string
+	}{
+		{
+			name:     "pads decimal places",
+			money:    internal.NewMoney(10050, "USD"),
+			expected: "100.50",
+		},
+		{
+			name:     "small amount",
+			money:    internal.NewMoney(5, "USD"),
+			expected: "0.05",
+		},
+		{
+			name:     "negative amount",
+			money:    internal.NewMoney(-305, "USD"),
+			expected: "-3.05",
+		},
+		{
+			name:     "zero decimal currency",
+			money:    internal.NewMoney(1500, "JPY"),
+			expected: "1500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := json.Marshal(tt.money)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(encoded))
+		})
+	}
+}
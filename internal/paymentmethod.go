@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrPaymentMethodUnavailable = errors.New("payment method not available")
+	ErrInvalidPaymentDetails    = errors.New("invalid payment details")
+	ErrAmountOutOfLimits        = errors.New("amount outside the limits of the payment method")
+)
+
+const (
+	PaymentMethodAccount = "account"
+	PaymentMethodCard    = "card"
+)
+
+// PaymentMethodField is a detail a payment method needs in
+// PaymentRequest.Details, e.g. the card token. Its value must match Pattern.
+type PaymentMethodField struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Pattern     *regexp.Regexp `json:"-"`
+}
+
+// AmountLimits bound the amounts a payment method accepts in one currency. A
+// nil Max means there is no upper bound.
+type AmountLimits struct {
+	Min Money  `json:"min"`
+	Max *Money `json:"max,omitempty"`
+}
+
+// PaymentMethod is a way of paying or depositing. Requests using it must
+// carry every one of its Fields and nothing else, and stay within the Limits
+// of their currency; currencies without limits accept any amount. Gateway
+// names the gateway client that processes it.
+type PaymentMethod struct {
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Fields      []PaymentMethodField    `json:"required_fields"`
+	Limits      map[string]AmountLimits `json:"limits,omitempty"`
+	Gateway     string                  `json:"-"`
+}
+
+// Validate checks the details and amount of a request against the method
+func (m PaymentMethod) Validate(paymentRequest PaymentRequest) error {
+	for _, field := range m.Fields {
+		value, ok := paymentRequest.Details[field.Name]
+		if !ok || value == "" {
+			return fmt.Errorf("%w: %s requires %s", ErrInvalidPaymentDetails, m.Name, field.Name)
+		}
+		if field.Pattern != nil && !field.Pattern.MatchString(value) {
+			return fmt.Errorf("%w: invalid %s", ErrInvalidPaymentDetails, field.Name)
+		}
+	}
+	for name := range paymentRequest.Details {
+		if !slices.ContainsFunc(m.Fields, func(field PaymentMethodField) bool { return field.Name == name }) {
+			return fmt.Errorf("%w: %s does not take %s", ErrInvalidPaymentDetails, m.Name, name)
+		}
+	}
+
+	amount := paymentRequest.Amount
+	limits, ok := m.Limits[amount.Currency]
+	if !ok {
+		return nil
+	}
+	if amount.LessThan(limits.Min) {
+		return fmt.Errorf("%w: %s takes at least %s %s", ErrAmountOutOfLimits, m.Name, limits.Min, amount.Currency)
+	}
+	if limits.Max != nil && limits.Max.LessThan(amount) {
+		return fmt.Errorf("%w: %s takes at most %s %s", ErrAmountOutOfLimits, m.Name, limits.Max, amount.Currency)
+	}
+
+	return nil
+}
+
+// PaymentMethodDefinitions holds every payment method the API supports,
+// which the configuration of each scope enables by name
+var PaymentMethodDefinitions = map[string]PaymentMethod{
+	PaymentMethodAccount: {
+		Name:        PaymentMethodAccount,
+		Description: "Bank account debit",
+		Fields: []PaymentMethodField{
+			{
+				Name:        "account_id",
+				Description: "ID of the bank account to debit",
+				Pattern:     regexp.MustCompile(`^[A-Za-z0-9-]{4,64}$`),
+			},
+		},
+		Limits: map[string]AmountLimits{
+			"USD": amountLimits(100, 5000000, "USD"),
+			"EUR": amountLimits(100, 5000000, "EUR"),
+		},
+	},
+	PaymentMethodCard: {
+		Name:        PaymentMethodCard,
+		Description: "Credit or debit card",
+		Fields: []PaymentMethodField{
+			{
+				Name:        CardTokenField,
+				Description: "Token of the card to charge, returned by the card vault",
+				Pattern:     regexp.MustCompile(`^[A-Za-z0-9_-]{8,128}$`),
+			},
+		},
+		Limits: map[string]AmountLimits{
+			"USD": amountLimits(50, 1000000, "USD"),
+			"EUR": amountLimits(50, 1000000, "EUR"),
+		},
+	},
+}
+
+// PaymentMethodRegistry holds the payment methods enabled in this scope
+type PaymentMethodRegistry struct {
+	methods map[string]PaymentMethod
+}
+
+func NewPaymentMethodRegistry(methods ...PaymentMethod) *PaymentMethodRegistry {
+	registry := &PaymentMethodRegistry{methods: make(map[string]PaymentMethod, len(methods))}
+	for _, method := range methods {
+		registry.methods[method.Name] = method
+	}
+	return registry
+}
+
+// Get returns the enabled payment method called name
+func (r *PaymentMethodRegistry) Get(name string) (PaymentMethod, bool) {
+	method, ok := r.methods[name]
+	return method, ok
+}
+
+// List returns the enabled payment methods sorted by name
+func (r *PaymentMethodRegistry) List() []PaymentMethod {
+	methods := make([]PaymentMethod, 0, len(r.methods))
+	for _, method := range r.methods {
+		methods = append(methods, method)
+	}
+	slices.SortFunc(methods, func(a, b PaymentMethod) int { return strings.Compare(a.Name, b.Name) })
+	return methods
+}
+
+// Validate checks the request uses an enabled payment method and meets its
+// rules
+func (r *PaymentMethodRegistry) Validate(paymentRequest PaymentRequest) error {
+	method, ok := r.Get(paymentRequest.Method)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrPaymentMethodUnavailable, paymentRequest.Method)
+	}
+	return method.Validate(paymentRequest)
+}
+
+// amountLimits bounds amounts between two amounts in minor units of currency
+func amountLimits(minUnits int64, maxUnits int64, currency string) AmountLimits {
+	maxAmount := NewMoney(maxUnits, currency)
+	return AmountLimits{Min: NewMoney(minUnits, currency), Max: &maxAmount}
+}
@@ -0,0 +1,92 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentMethodRegistry_Validate(t *testing.T) {
+	registry := internal.NewPaymentMethodRegistry(internal.PaymentMethodDefinitions[internal.PaymentMethodCard])
+	cardDetails := map[string]string{"card_token": "tok_4f9a8b7c6d5e"}
+
+	tests := []struct {
+		name          string
+		request       internal.PaymentRequest
+		expectedError error
+	}{
+		{
+			name:    "valid card payment",
+			request: internal.PaymentRequest{Method: "card", Amount: internal.NewMoney(10050, "USD"), Details: cardDetails},
+		},
+		{
+			name:    "currency without limits accepts any amount",
+			request: internal.PaymentRequest{Method: "card", Amount: internal.NewMoney(1, "JPY"), Details: cardDetails},
+		},
+		{
+			name:          "method not enabled",
+			request:       internal.PaymentRequest{Method: "account", Amount: internal.NewMoney(10050, "USD"), Details: map[string]string{"account_id": "acc-1234"}},
+			expectedError: internal.ErrPaymentMethodUnavailable,
+		},
+		{
+			name:          "unknown method",
+			request:       internal.PaymentRequest{Method: "cash", Amount: internal.NewMoney(10050, "USD")},
+			expectedError: internal.ErrPaymentMethodUnavailable,
+		},
+		{
+			name:          "missing required field",
+			request:       internal.PaymentRequest{Method: "card", Amount: internal.NewMoney(10050, "USD")},
+			expectedError: internal.ErrInvalidPaymentDetails,
+		},
+		{
+			name:          "field not matching its pattern",
+			request:       internal.PaymentRequest{Method: "card", Amount: internal.NewMoney(10050, "USD"), Details: map[string]string{"card_token": "tok 123"}},
+			expectedError: internal.ErrInvalidPaymentDetails,
+		},
+		{
+			name: "field of another method",
+			request: internal.PaymentRequest{
+				Method:  "card",
+				Amount:  internal.NewMoney(10050, "USD"),
+				Details: map[string]string{"card_token": "tok_4f9a8b7c6d5e", "account_id": "acc-1234"},
+			},
+			expectedError: internal.ErrInvalidPaymentDetails,
+		},
+		{
+			name:          "amount below the minimum",
+			request:       internal.PaymentRequest{Method: "card", Amount: internal.NewMoney(49, "USD"), Details: cardDetails},
+			expectedError: internal.ErrAmountOutOfLimits,
+		},
+		{
+			name:          "amount above the maximum",
+			request:       internal.PaymentRequest{Method: "card", Amount: internal.NewMoney(1000001, "EUR"), Details: cardDetails},
+			expectedError: internal.ErrAmountOutOfLimits,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := registry.Validate(tt.request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPaymentMethodRegistry_List(t *testing.T) {
+	registry := internal.NewPaymentMethodRegistry(
+		internal.PaymentMethodDefinitions[internal.PaymentMethodCard],
+		internal.PaymentMethodDefinitions[internal.PaymentMethodAccount],
+	)
+
+	methods := registry.List()
+
+	assert.Len(t, methods, 2)
+	assert.Equal(t, internal.PaymentMethodAccount, methods[0].Name)
+	assert.Equal(t, internal.PaymentMethodCard, methods[1].Name)
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	ErrInvalidStatusTransition  = errors.New("invalid transaction status transition")
+	ErrTransactionStatusChanged = errors.New("transaction status changed concurrently")
+)
+
+const (
+	PaymentStatusPending           = "pending"
+	PaymentStatusAuthorized        = "authorized"
+	PaymentStatusSuccess           = "success"
+	PaymentStatusFailed            = "failed"
+	PaymentStatusRefunded          = "refunded"
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+	PaymentStatusReversed          = "reversed"
+	PaymentStatusExpired           = "expired"
+)
+
+var PaymentStatuses = []string{
+	PaymentStatusPending,
+	PaymentStatusAuthorized,
+	PaymentStatusSuccess,
+	PaymentStatusFailed,
+	PaymentStatusRefunded,
+	PaymentStatusPartiallyRefunded,
+	PaymentStatusReversed,
+	PaymentStatusExpired,
+}
+
+// Reasons recorded in the status history of a transaction
+const (
+	StatusReasonCreated         = "created"
+	StatusReasonGatewayResponse = "gateway_response"
+	StatusReasonRefund          = "refund"
+	StatusReasonCapture         = "capture"
+	StatusReasonVoid            = "void"
+	StatusReasonExpiry          = "expiry"
+)
+
+// paymentTransitions lists the statuses a transaction may move to from each
+// status. Failed, refunded, reversed and expired transactions are final.
+var paymentTransitions = map[string][]string{
+	PaymentStatusPending: {
+		PaymentStatusAuthorized,
+		PaymentStatusSuccess,
+		PaymentStatusFailed,
+		PaymentStatusExpired,
+	},
+	PaymentStatusAuthorized: {
+		PaymentStatusSuccess,
+		PaymentStatusFailed,
+		PaymentStatusReversed,
+		PaymentStatusExpired,
+	},
+	PaymentStatusSuccess: {
+		PaymentStatusPartiallyRefunded,
+		PaymentStatusRefunded,
+		PaymentStatusReversed,
+	},
+	// Every further partial refund keeps the payment partially refunded
+	PaymentStatusPartiallyRefunded: {
+		PaymentStatusPartiallyRefunded,
+		PaymentStatusRefunded,
+	},
+}
+
+// holdPaymentStatuses maps each hold status to the payment status it stands
+// for. An active hold is an authorized payment that is captured, reversed
+// when voided or expires.
+var holdPaymentStatuses = map[string]string{
+	HoldStatusActive:   PaymentStatusAuthorized,
+	HoldStatusCaptured: PaymentStatusSuccess,
+	HoldStatusVoided:   PaymentStatusReversed,
+	HoldStatusExpired:  PaymentStatusExpired,
+}
+
+// TransactionStatusChange is an entry of the status history of a
+// transaction. From is empty for the status it was created with.
+type TransactionStatusChange struct {
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidateStatusTransition checks a transaction may move from one status to
+// another, returning ErrInvalidStatusTransition otherwise
+func ValidateStatusTransition(from string, to string) error {
+	if !slices.Contains(paymentTransitions[from], to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, from, to)
+	}
+
+	return nil
+}
+
+// ValidateHoldTransition checks a hold may move from one status to another
+// under the payment state machine, returning ErrInvalidStatusTransition
+// otherwise. Captured holds are final too: refunds apply to the payment
+// transaction the capture created.
+func ValidateHoldTransition(from string, to string) error {
+	fromStatus, okFrom := holdPaymentStatuses[from]
+	toStatus, okTo := holdPaymentStatuses[to]
+	if !okFrom || !okTo || from != HoldStatusActive {
+		return fmt.Errorf("%w: hold %s to %s", ErrInvalidStatusTransition, from, to)
+	}
+
+	return ValidateStatusTransition(fromStatus, toStatus)
+}
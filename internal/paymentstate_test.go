@@ -0,0 +1,115 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStatusTransition(t *testing.T) {
+	tests := []struct {
+		name          string
+		from          string
+		to            string
+		expectedError error
+	}{
+		{name: "pending payment succeeds", from: internal.PaymentStatusPending, to: internal.PaymentStatusSuccess},
+		{name: "pending payment fails", from: internal.PaymentStatusPending, to: internal.PaymentStatusFailed},
+		{name: "pending payment is authorized", from: internal.PaymentStatusPending, to: internal.PaymentStatusAuthorized},
+		{name: "authorized payment is reversed", from: internal.PaymentStatusAuthorized, to: internal.PaymentStatusReversed},
+		{name: "authorized payment expires", from: internal.PaymentStatusAuthorized, to: internal.PaymentStatusExpired},
+		{name: "successful payment is partially refunded", from: internal.PaymentStatusSuccess, to: internal.PaymentStatusPartiallyRefunded},
+		{name: "partially refunded payment is partially refunded again", from: internal.PaymentStatusPartiallyRefunded, to: internal.PaymentStatusPartiallyRefunded},
+		{name: "partially refunded payment is fully refunded", from: internal.PaymentStatusPartiallyRefunded, to: internal.PaymentStatusRefunded},
+		{
+			name:          "failed payment is final",
+			from:          internal.PaymentStatusFailed,
+			to:            internal.PaymentStatusSuccess,
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+		{
+			name:          "refunded payment is final",
+			from:          internal.PaymentStatusRefunded,
+			to:            internal.PaymentStatusPartiallyRefunded,
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+		{
+			name:          "pending payment cannot be refunded",
+			from:          internal.PaymentStatusPending,
+			to:            internal.PaymentStatusRefunded,
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+		{
+			name:          "pending payment stays pending",
+			from:          internal.PaymentStatusPending,
+			to:            internal.PaymentStatusPending,
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+		{
+			name:          "unknown status",
+			from:          "settled",
+			to:            internal.PaymentStatusSuccess,
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := internal.ValidateStatusTransition(tt.from, tt.to)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateHoldTransition(t *testing.T) {
+	tests := []struct {
+		name          string
+		from          string
+		to            string
+		expectedError error
+	}{
+		{name: "active hold is captured", from: internal.HoldStatusActive, to: internal.HoldStatusCaptured},
+		{name: "active hold is voided", from: internal.HoldStatusActive, to: internal.HoldStatusVoided},
+		{name: "active hold expires", from: internal.HoldStatusActive, to: internal.HoldStatusExpired},
+		{
+			name:          "captured hold cannot be voided",
+			from:          internal.HoldStatusCaptured,
+			to:            internal.HoldStatusVoided,
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+		{
+			name:          "expired hold cannot be captured",
+			from:          internal.HoldStatusExpired,
+			to:            internal.HoldStatusCaptured,
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+		{
+			name:          "active hold stays active",
+			from:          internal.HoldStatusActive,
+			to:            internal.HoldStatusActive,
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+		{
+			name:          "unknown status",
+			from:          internal.HoldStatusActive,
+			to:            "settled",
+			expectedError: internal.ErrInvalidStatusTransition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := internal.ValidateHoldTransition(tt.from, tt.to)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
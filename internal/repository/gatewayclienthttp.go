@@ -0,0 +1,296 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+)
+
+const (
+	gatewayStatusApproved = "approved"
+	gatewayStatusDeclined = "declined"
+	gatewayStatusPending  = "pending"
+
+	// maxGatewayResponseSize caps how much of a response body is read
+	maxGatewayResponseSize = 1 << 20
+)
+
+// GatewayError is returned by GatewayClientHTTP when the gateway answers with
+// an error status or a body that cannot be understood. Kind is one of the
+// internal.ErrGateway* errors so callers can match it with errors.Is.
+// NotReached marks requests that could not be sent at all, which also match
+// internal.ErrGatewayNotReached.
+type GatewayError struct {
+	Kind       error
+	StatusCode int
+	Code       string
+	Message    string
+	NotReached bool
+}
+
+func (e *GatewayError) Error() string {
+	message := e.Kind.Error()
+	if e.StatusCode != 0 {
+		message = fmt.Sprintf("%s: status %d", message, e.StatusCode)
+	}
+	if e.Code != "" {
+		message = fmt.Sprintf("%s: %s", message, e.Code)
+	}
+	if e.Message != "" {
+		message = fmt.Sprintf("%s: %s", message, e.Message)
+	}
+	return message
+}
+
+func (e *GatewayError) Unwrap() []error {
+	if e.NotReached {
+		return []error{e.Kind, internal.ErrGatewayNotReached}
+	}
+	return []error{e.Kind}
+}
+
+// CardResolver turns the token of a vaulted card back into the card
+type CardResolver interface {
+	ResolveCard(ctx context.Context, userID uint64, token string) (internal.Card, error)
+}
+
+// GatewayClientHTTP talks to a payment gateway over its JSON HTTP API. Our
+// transaction ID is sent in every request, and as Idempotency-Key, so the
+// gateway can deduplicate retries. Card tokens are resolved with cards right
+// before sending a charge, so card data never leaves the client otherwise.
+type GatewayClientHTTP struct {
+	name       string
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	cards      CardResolver
+}
+
+func NewGatewayClientHTTP(name string, baseURL string, apiKey string, timeout time.Duration, cards CardResolver) *GatewayClientHTTP {
+	return &GatewayClientHTTP{
+		name:       name,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: timeout},
+		cards:      cards,
+	}
+}
+
+// gatewayChargeRequest is the body of payment and deposit requests
+type gatewayChargeRequest struct {
+	TransactionID string         `json:"transaction_id"`
+	UserID        uint64         `json:"user_id"`
+	Method        string         `json:"method"`
+	Amount        internal.Money `json:"amount"`
+	Currency      string         `json:"currency"`
+	// Details are the fields required by the payment method, except the card
+	// token of card charges, which is sent resolved as Card
+	Details map[string]string `json:"details,omitempty"`
+	Card    *gatewayCard      `json:"card,omitempty"`
+}
+
+// gatewayCard is the card charged by a card payment or deposit
+type gatewayCard struct {
+	Number      string `json:"number"`
+	ExpiryMonth int    `json:"expiry_month"`
+	ExpiryYear  int    `json:"expiry_year"`
+	HolderName  string `json:"holder_name"`
+}
+
+// gatewayRefundRequest is the body of refund requests
+type gatewayRefundRequest struct {
+	TransactionID        string         `json:"transaction_id"`
+	PaymentTransactionID string         `json:"payment_transaction_id"`
+	Amount               internal.Money `json:"amount"`
+	Currency             string         `json:"currency"`
+}
+
+// gatewayTransactionResponse is what the gateway answers for every transaction
+type gatewayTransactionResponse struct {
+	Reference    string `json:"reference"`
+	Status       string `json:"status"`
+	ResponseCode string `json:"response_code"`
+}
+
+// gatewayErrorResponse is the body of non-2xx responses
+type gatewayErrorResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+func (g *GatewayClientHTTP) CreatePayment(ctx context.Context, transactionID string, paymentRequest internal.PaymentRequest) (internal.GatewayResponse, error) {
+	chargeRequest, err := g.chargeRequest(ctx, transactionID, paymentRequest)
+	if err != nil {
+		return internal.GatewayResponse{Gateway: g.name}, err
+	}
+	return g.do(ctx, http.MethodPost, "/v1/payments", transactionID, chargeRequest)
+}
+
+func (g *GatewayClientHTTP) CreateDeposit(ctx context.Context, transactionID string, depositRequest internal.DepositRequest) (internal.GatewayResponse, error) {
+	chargeRequest, err := g.chargeRequest(ctx, transactionID, internal.PaymentRequest(depositRequest))
+	if err != nil {
+		return internal.GatewayResponse{Gateway: g.name}, err
+	}
+	return g.do(ctx, http.MethodPost, "/v1/deposits", transactionID, chargeRequest)
+}
+
+// chargeRequest builds the body of a payment or deposit, resolving the card
+// token of card charges into the card. Cards that cannot be resolved fail
+// with internal.ErrCardUnresolved: nothing was sent, but another gateway
+// could not charge them either.
+func (g *GatewayClientHTTP) chargeRequest(ctx context.Context, transactionID string, paymentRequest internal.PaymentRequest) (gatewayChargeRequest, error) {
+	chargeRequest := gatewayChargeRequest{
+		TransactionID: transactionID,
+		UserID:        paymentRequest.UserID,
+		Method:        paymentRequest.Method,
+		Amount:        paymentRequest.Amount,
+		Currency:      paymentRequest.Amount.Currency,
+		Details:       paymentRequest.Details,
+	}
+
+	token, ok := paymentRequest.Details[internal.CardTokenField]
+	if paymentRequest.Method != internal.PaymentMethodCard || !ok {
+		return chargeRequest, nil
+	}
+	if g.cards == nil {
+		return gatewayChargeRequest{}, fmt.Errorf("%w: %w", internal.ErrCardUnresolved, internal.ErrCardVaultDisabled)
+	}
+	card, err := g.cards.ResolveCard(ctx, paymentRequest.UserID, token)
+	if err != nil {
+		return gatewayChargeRequest{}, fmt.Errorf("%w: %w", internal.ErrCardUnresolved, err)
+	}
+
+	chargeRequest.Details = maps.Clone(paymentRequest.Details)
+	delete(chargeRequest.Details, internal.CardTokenField)
+	chargeRequest.Card = &gatewayCard{
+		Number:      card.Number,
+		ExpiryMonth: card.ExpiryMonth,
+		ExpiryYear:  card.ExpiryYear,
+		HolderName:  card.HolderName,
+	}
+	return chargeRequest, nil
+}
+
+func (g *GatewayClientHTTP) Refund(ctx context.Context, transactionID string, refundRequest internal.RefundRequest) (internal.GatewayResponse, error) {
+	return g.do(ctx, http.MethodPost, "/v1/refunds", transactionID, gatewayRefundRequest{
+		TransactionID:        transactionID,
+		PaymentTransactionID: refundRequest.TransactionID,
+		Amount:               refundRequest.Amount,
+		Currency:             refundRequest.Amount.Currency,
+	})
+}
+
+func (g *GatewayClientHTTP) GetPaymentStatus(ctx context.Context, transaction internal.Transaction) (internal.GatewayResponse, error) {
+	return g.do(ctx, http.MethodGet, "/v1/transactions/"+url.PathEscape(transaction.ID), "", nil)
+}
+
+// do sends a request to the gateway and maps its answer. The returned
+// response always carries the gateway name, plus whatever the gateway
+// reported even when the payment was declined.
+func (g *GatewayClientHTTP) do(ctx context.Context, method string, path string, idempotencyKey string, body any) (internal.GatewayResponse, error) {
+	gatewayResponse := internal.GatewayResponse{Gateway: g.name}
+
+	var requestBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return gatewayResponse, fmt.Errorf("error encoding gateway request: %v", err)
+		}
+		requestBody = bytes.NewReader(payload)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, requestBody)
+	if err != nil {
+		return gatewayResponse, fmt.Errorf("error creating gateway request: %v", err)
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		request.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if g.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+g.apiKey)
+	}
+
+	response, err := g.httpClient.Do(request)
+	if err != nil {
+		// Timeouts and broken connections: the request may or may not have
+		// reached the gateway. Only a connection that could not be opened
+		// proves it did not.
+		var opErr *net.OpError
+		notReached := errors.As(err, &opErr) && opErr.Op == "dial"
+		return gatewayResponse, &GatewayError{Kind: internal.ErrGatewayUnavailable, Message: err.Error(), NotReached: notReached}
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, maxGatewayResponseSize))
+	if err != nil {
+		return gatewayResponse, &GatewayError{Kind: internal.ErrGatewayUnavailable, StatusCode: response.StatusCode, Message: err.Error()}
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return gatewayResponse, newGatewayStatusError(response.StatusCode, responseBody)
+	}
+
+	var transactionResponse gatewayTransactionResponse
+	if err := json.Unmarshal(responseBody, &transactionResponse); err != nil {
+		return gatewayResponse, &GatewayError{Kind: internal.ErrGatewayInvalidResponse, StatusCode: response.StatusCode, Message: err.Error()}
+	}
+
+	gatewayResponse.Reference = transactionResponse.Reference
+	gatewayResponse.ResponseCode = transactionResponse.ResponseCode
+	switch transactionResponse.Status {
+	case gatewayStatusApproved:
+		gatewayResponse.Status = internal.PaymentStatusSuccess
+	case gatewayStatusPending:
+		gatewayResponse.Status = internal.PaymentStatusPending
+	case gatewayStatusDeclined:
+		gatewayResponse.Status = internal.PaymentStatusFailed
+		// Looking up a declined payment is not an error
+		if method == http.MethodGet {
+			return gatewayResponse, nil
+		}
+		return gatewayResponse, &GatewayError{Kind: internal.ErrGatewayDeclined, StatusCode: response.StatusCode, Code: transactionResponse.ResponseCode}
+	default:
+		return internal.GatewayResponse{Gateway: g.name}, &GatewayError{
+			Kind:       internal.ErrGatewayInvalidResponse,
+			StatusCode: response.StatusCode,
+			Message:    fmt.Sprintf("unknown status %q", transactionResponse.Status),
+		}
+	}
+
+	return gatewayResponse, nil
+}
+
+// newGatewayStatusError maps a non-2xx answer to a GatewayError. Server errors
+// and rate limiting are reported as unavailable so they can be retried.
+func newGatewayStatusError(statusCode int, body []byte) error {
+	gatewayErr := &GatewayError{Kind: internal.ErrGatewayRejected, StatusCode: statusCode}
+	switch {
+	case statusCode == http.StatusNotFound:
+		gatewayErr.Kind = internal.ErrGatewayPaymentNotFound
+	case statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError:
+		gatewayErr.Kind = internal.ErrGatewayUnavailable
+	}
+
+	var errorResponse gatewayErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err == nil {
+		gatewayErr.Code = errorResponse.Code
+		gatewayErr.Message = errorResponse.Error
+	}
+
+	return gatewayErr
+}
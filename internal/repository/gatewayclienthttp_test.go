@@ -0,0 +1,227 @@
+package repository_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/gatewaystub"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayClientHTTP_AgainstStub(t *testing.T) {
+	server := httptest.NewServer(gatewaystub.NewHandler(repository.NewGatewayClient(), "secret"))
+	t.Cleanup(server.Close)
+	ctx := context.Background()
+	client := repository.NewGatewayClientHTTP("stub", server.URL, "secret", time.Second, nil)
+	payment := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: internal.NewMoney(10050, "USD")}
+
+	response, err := client.CreatePayment(ctx, "payment-123", payment)
+	require.NoError(t, err)
+	assert.Equal(t, "stub", response.Gateway)
+	assert.NotEmpty(t, response.Reference)
+	assert.Equal(t, internal.PaymentStatusSuccess, response.Status)
+
+	// Retries are deduplicated by transaction ID
+	retried, err := client.CreatePayment(ctx, "payment-123", payment)
+	require.NoError(t, err)
+	assert.Equal(t, response.Reference, retried.Reference)
+
+	status, err := client.GetPaymentStatus(ctx, internal.Transaction{ID: "payment-123"})
+	require.NoError(t, err)
+	assert.Equal(t, response.Reference, status.Reference)
+	assert.Equal(t, internal.PaymentStatusSuccess, status.Status)
+
+	refund, err := client.Refund(ctx, "refund-123", internal.RefundRequest{TransactionID: "payment-123", Amount: internal.NewMoney(50, "USD")})
+	require.NoError(t, err)
+	assert.Equal(t, internal.PaymentStatusSuccess, refund.Status)
+
+	_, err = client.GetPaymentStatus(ctx, internal.Transaction{ID: "unknown-123"})
+	assert.ErrorIs(t, err, internal.ErrGatewayPaymentNotFound)
+
+	unauthorized := repository.NewGatewayClientHTTP("stub", server.URL, "wrong", time.Second, nil)
+	_, err = unauthorized.CreatePayment(ctx, "payment-456", payment)
+	assert.ErrorIs(t, err, internal.ErrGatewayRejected)
+}
+
+func TestGatewayClientHTTP_ErrorMapping(t *testing.T) {
+	tests := []struct {
+		name           string
+		statusCode     int
+		body           string
+		delay          time.Duration
+		expectedError  error
+		expectedStatus string
+		expectedCode   string
+	}{
+		{
+			name:           "declined payment",
+			statusCode:     http.StatusOK,
+			body:           `{"reference": "gateway-tx-123", "status": "declined", "response_code": "insufficient_funds"}`,
+			expectedError:  internal.ErrGatewayDeclined,
+			expectedStatus: internal.PaymentStatusFailed,
+			expectedCode:   "insufficient_funds",
+		},
+		{
+			name:           "pending payment",
+			statusCode:     http.StatusAccepted,
+			body:           `{"reference": "gateway-tx-123", "status": "pending"}`,
+			expectedStatus: internal.PaymentStatusPending,
+		},
+		{
+			name:          "server error is unavailable",
+			statusCode:    http.StatusBadGateway,
+			body:          `{"code": "upstream", "error": "acquirer down"}`,
+			expectedError: internal.ErrGatewayUnavailable,
+		},
+		{
+			name:          "rate limited is unavailable",
+			statusCode:    http.StatusTooManyRequests,
+			expectedError: internal.ErrGatewayUnavailable,
+		},
+		{
+			name:          "bad request is rejected",
+			statusCode:    http.StatusBadRequest,
+			body:          `{"code": "invalid_request", "error": "amount is required"}`,
+			expectedError: internal.ErrGatewayRejected,
+		},
+		{
+			name:          "malformed body",
+			statusCode:    http.StatusOK,
+			body:          `{"reference": `,
+			expectedError: internal.ErrGatewayInvalidResponse,
+		},
+		{
+			name:          "unknown status",
+			statusCode:    http.StatusOK,
+			body:          `{"reference": "gateway-tx-123", "status": "maybe"}`,
+			expectedError: internal.ErrGatewayInvalidResponse,
+		},
+		{
+			name:          "timeout is unavailable",
+			statusCode:    http.StatusOK,
+			body:          `{"reference": "gateway-tx-123", "status": "approved"}`,
+			delay:         200 * time.Millisecond,
+			expectedError: internal.ErrGatewayUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "payment-123", r.Header.Get("Idempotency-Key"))
+				time.Sleep(tt.delay)
+				w.WriteHeader(tt.statusCode)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(server.Close)
+
+			client := repository.NewGatewayClientHTTP("acme", server.URL, "", 100*time.Millisecond, nil)
+			response, err := client.CreatePayment(context.Background(), "payment-123", internal.PaymentRequest{
+				UserID: 1234,
+				Method: "card",
+				Amount: internal.NewMoney(10050, "USD"),
+			})
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				var gatewayErr *repository.GatewayError
+				assert.ErrorAs(t, err, &gatewayErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, "acme", response.Gateway)
+			assert.Equal(t, tt.expectedStatus, response.Status)
+			assert.Equal(t, tt.expectedCode, response.ResponseCode)
+		})
+	}
+}
+
+func TestGatewayClientHTTP_NotReached(t *testing.T) {
+	payment := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: internal.NewMoney(10050, "USD")}
+
+	// Nothing listens on a closed server, so the connection is refused
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	client := repository.NewGatewayClientHTTP("acme", server.URL, "", 100*time.Millisecond, nil)
+
+	_, err := client.CreatePayment(context.Background(), "payment-123", payment)
+	assert.ErrorIs(t, err, internal.ErrGatewayUnavailable)
+	assert.ErrorIs(t, err, internal.ErrGatewayNotReached)
+
+	// A timeout may hide a payment the gateway processed
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	t.Cleanup(slow.Close)
+	client = repository.NewGatewayClientHTTP("acme", slow.URL, "", 100*time.Millisecond, nil)
+
+	_, err = client.CreatePayment(context.Background(), "payment-123", payment)
+	assert.ErrorIs(t, err, internal.ErrGatewayUnavailable)
+	assert.NotErrorIs(t, err, internal.ErrGatewayNotReached)
+}
+
+type stubCardResolver map[string]internal.Card
+
+func (r stubCardResolver) ResolveCard(ctx context.Context, userID uint64, token string) (internal.Card, error) {
+	card, ok := r[token]
+	if !ok {
+		return internal.Card{}, internal.ErrCardNotFound
+	}
+	return card, nil
+}
+
+func TestGatewayClientHTTP_ResolvesCardTokens(t *testing.T) {
+	card := internal.Card{Number: "4242424242424242", ExpiryMonth: 12, ExpiryYear: time.Now().Year() + 2, HolderName: "Ada Lovelace"}
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"reference": "gateway-tx-123", "status": "approved"}`))
+	}))
+	t.Cleanup(server.Close)
+	client := repository.NewGatewayClientHTTP("acme", server.URL, "", time.Second, stubCardResolver{"tok_4f9a8b7c6d5e": card})
+
+	_, err := client.CreatePayment(context.Background(), "payment-123", internal.PaymentRequest{
+		UserID:  1234,
+		Method:  "card",
+		Amount:  internal.NewMoney(10050, "USD"),
+		Details: map[string]string{"card_token": "tok_4f9a8b7c6d5e"},
+	})
+	require.NoError(t, err)
+
+	var body struct {
+		Details map[string]string `json:"details"`
+		Card    struct {
+			Number      string `json:"number"`
+			ExpiryMonth int    `json:"expiry_month"`
+			ExpiryYear  int    `json:"expiry_year"`
+			HolderName  string `json:"holder_name"`
+		} `json:"card"`
+	}
+	require.NoError(t, json.Unmarshal(received, &body))
+	assert.Empty(t, body.Details)
+	assert.Equal(t, card.Number, body.Card.Number)
+	assert.Equal(t, card.ExpiryYear, body.Card.ExpiryYear)
+	assert.Equal(t, card.HolderName, body.Card.HolderName)
+
+	// Unknown tokens never reach the gateway
+	received = nil
+	response, err := client.CreateDeposit(context.Background(), "deposit-123", internal.DepositRequest{
+		UserID:  1234,
+		Method:  "card",
+		Amount:  internal.NewMoney(10050, "USD"),
+		Details: map[string]string{"card_token": "tok_unknown123"},
+	})
+	assert.ErrorIs(t, err, internal.ErrCardNotFound)
+	assert.ErrorIs(t, err, internal.ErrCardUnresolved)
+	assert.NotErrorIs(t, err, internal.ErrGatewayNotReached)
+	assert.Equal(t, "acme", response.Gateway)
+	assert.Nil(t, received)
+}
@@ -2,17 +2,210 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
 
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
 	"github.com/google/uuid"
 )
 
-type GatewayClientMock struct{}
+const (
+	MockGatewayName         = "mock"
+	mockGatewayApprovedCode = "approved"
+	mockGatewayRetention    = 24 * time.Hour
+)
+
+type GatewayClientMock struct {
+	mu sync.Mutex
+	// payments keeps the response of every payment, deposit and refund by
+	// transaction ID so their status can be queried later. Entries older than
+	// retention are dropped by the next charge after lastSweep + retention.
+	payments  map[string]mockPayment
+	lastSweep time.Time
+	retention time.Duration
+	faults    config.GatewayFaultConfig
+	// timeout bounds how long timed out calls hang
+	timeout time.Duration
+}
+
+// mockPayment is the outcome of a charge and when the gateway decided it
+type mockPayment struct {
+	response internal.GatewayResponse
+	storedAt time.Time
+}
 
 func NewGatewayClient() *GatewayClientMock {
-	return &GatewayClientMock{}
+	return NewFaultyGatewayClient(config.GatewayFaultConfig{}, 0)
+}
+
+// NewFaultyGatewayClient returns a mock gateway that injects the configured
+// faults. Timed out calls hang until ctx is done or timeout passes.
+func NewFaultyGatewayClient(faults config.GatewayFaultConfig, timeout time.Duration) *GatewayClientMock {
+	retention := faults.Retention
+	if retention <= 0 {
+		retention = mockGatewayRetention
+	}
+
+	return &GatewayClientMock{
+		payments:  make(map[string]mockPayment),
+		lastSweep: time.Now(),
+		retention: retention,
+		faults:    faults,
+		timeout:   timeout,
+	}
+}
+
+func (g *GatewayClientMock) CreatePayment(ctx context.Context, transactionID string, paymentRequest internal.PaymentRequest) (internal.GatewayResponse, error) {
+	return g.charge(ctx, transactionID, paymentRequest.UserID, paymentRequest.Amount)
+}
+
+func (g *GatewayClientMock) CreateDeposit(ctx context.Context, transactionID string, depositRequest internal.DepositRequest) (internal.GatewayResponse, error) {
+	return g.CreatePayment(ctx, transactionID, internal.PaymentRequest(depositRequest))
+}
+
+func (g *GatewayClientMock) Refund(ctx context.Context, transactionID string, refundRequest internal.RefundRequest) (internal.GatewayResponse, error) {
+	if _, ok := g.load(refundRequest.TransactionID); !ok {
+		return internal.GatewayResponse{Gateway: MockGatewayName}, internal.ErrGatewayPaymentNotFound
+	}
+
+	return g.charge(ctx, transactionID, refundRequest.UserID, refundRequest.Amount)
+}
+
+func (g *GatewayClientMock) GetPaymentStatus(ctx context.Context, transaction internal.Transaction) (internal.GatewayResponse, error) {
+	if err := g.injectFaults(ctx); err != nil {
+		return internal.GatewayResponse{Gateway: MockGatewayName}, err
+	}
+
+	stored, ok := g.load(transaction.ID)
+	if !ok {
+		return internal.GatewayResponse{Gateway: MockGatewayName}, internal.ErrGatewayPaymentNotFound
+	}
+
+	return stored, nil
+}
+
+// charge decides the outcome of a payment, deposit or refund and keeps it
+// under transactionID, so retries get the same answer
+func (g *GatewayClientMock) charge(ctx context.Context, transactionID string, userID uint64, amount internal.Money) (internal.GatewayResponse, error) {
+	if err := g.injectFaults(ctx); err != nil {
+		return internal.GatewayResponse{Gateway: MockGatewayName}, err
+	}
+
+	response := internal.GatewayResponse{
+		Gateway:      MockGatewayName,
+		Reference:    uuid.New().String(),
+		ResponseCode: mockGatewayApprovedCode,
+		Status:       internal.PaymentStatusSuccess,
+	}
+	if code, ok := g.declineCode(userID, amount); ok {
+		response.ResponseCode = code
+		response.Status = internal.PaymentStatusFailed
+	}
+	response = g.loadOrStore(transactionID, response)
+
+	if g.happens(g.faults.LostResponseRate) {
+		// Processed by the gateway, but the caller never hears about it
+		return internal.GatewayResponse{Gateway: MockGatewayName}, g.hang(ctx)
+	}
+
+	if response.Status == internal.PaymentStatusFailed {
+		return response, fmt.Errorf("%w: %s", internal.ErrGatewayDeclined, response.ResponseCode)
+	}
+
+	return response, nil
+}
+
+// load returns the response kept under transactionID unless it is older than
+// the retention
+func (g *GatewayClientMock) load(transactionID string) (internal.GatewayResponse, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stored, ok := g.payments[transactionID]
+	if !ok || time.Since(stored.storedAt) >= g.retention {
+		return internal.GatewayResponse{}, false
+	}
+
+	return stored.response, true
+}
+
+// loadOrStore returns the response kept under transactionID, storing response
+// if there is none. Expired responses are swept at most once per retention.
+func (g *GatewayClientMock) loadOrStore(transactionID string, response internal.GatewayResponse) internal.GatewayResponse {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(g.lastSweep) >= g.retention {
+		for id, stored := range g.payments {
+			if now.Sub(stored.storedAt) >= g.retention {
+				delete(g.payments, id)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	if stored, ok := g.payments[transactionID]; ok && now.Sub(stored.storedAt) < g.retention {
+		return stored.response
+	}
+	g.payments[transactionID] = mockPayment{response: response, storedAt: now}
+
+	return response
+}
+
+// injectFaults applies the configured latency, timeouts and failures
+func (g *GatewayClientMock) injectFaults(ctx context.Context) error {
+	latency := g.faults.Latency
+	if g.faults.LatencyJitter > 0 {
+		latency += rand.N(g.faults.LatencyJitter)
+	}
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", internal.ErrGatewayUnavailable, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	if g.happens(g.faults.TimeoutRate) {
+		return g.hang(ctx)
+	}
+
+	if g.happens(g.faults.FailureRate) {
+		return fmt.Errorf("%w: %w: injected failure", internal.ErrGatewayUnavailable, internal.ErrGatewayNotReached)
+	}
+
+	return nil
+}
+
+// hang blocks like a gateway that never answers, until ctx is done or the
+// timeout passes
+func (g *GatewayClientMock) hang(ctx context.Context) error {
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", internal.ErrGatewayUnavailable, ctx.Err())
+	case <-timer.C:
+		return fmt.Errorf("%w: timed out after %s", internal.ErrGatewayUnavailable, g.timeout)
+	}
+}
+
+// declineCode returns the code configured for the user or, failing that, for
+// the amount
+func (g *GatewayClientMock) declineCode(userID uint64, amount internal.Money) (string, bool) {
+	if code, ok := g.faults.DeclinedUsers[userID]; ok {
+		return code, true
+	}
+	code, ok := g.faults.DeclinedAmounts[amount.MinorUnits]
+	return code, ok
 }
 
-func (g *GatewayClientMock) CreatePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error) {
-	return uuid.New().String(), nil
+func (g *GatewayClientMock) happens(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
 }
@@ -0,0 +1,136 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayClientMock_Faults(t *testing.T) {
+	payment := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: internal.NewMoney(10050, "USD")}
+
+	tests := []struct {
+		name           string
+		faults         config.GatewayFaultConfig
+		request        internal.PaymentRequest
+		expectedError  error
+		expectedStatus string
+		expectedCode   string
+		storedStatus   string
+	}{
+		{
+			name:           "no faults approves",
+			request:        payment,
+			expectedStatus: internal.PaymentStatusSuccess,
+			expectedCode:   "approved",
+			storedStatus:   internal.PaymentStatusSuccess,
+		},
+		{
+			name:           "declined by amount",
+			faults:         config.GatewayFaultConfig{DeclinedAmounts: map[int64]string{10050: "insufficient_funds"}},
+			request:        payment,
+			expectedError:  internal.ErrGatewayDeclined,
+			expectedStatus: internal.PaymentStatusFailed,
+			expectedCode:   "insufficient_funds",
+			storedStatus:   internal.PaymentStatusFailed,
+		},
+		{
+			name: "user decline code wins over amount",
+			faults: config.GatewayFaultConfig{
+				DeclinedAmounts: map[int64]string{10050: "insufficient_funds"},
+				DeclinedUsers:   map[uint64]string{1234: "stolen_card"},
+			},
+			request:        payment,
+			expectedError:  internal.ErrGatewayDeclined,
+			expectedStatus: internal.PaymentStatusFailed,
+			expectedCode:   "stolen_card",
+			storedStatus:   internal.PaymentStatusFailed,
+		},
+		{
+			name:           "other amounts are approved",
+			faults:         config.GatewayFaultConfig{DeclinedAmounts: map[int64]string{1313: "insufficient_funds"}},
+			request:        payment,
+			expectedStatus: internal.PaymentStatusSuccess,
+			expectedCode:   "approved",
+			storedStatus:   internal.PaymentStatusSuccess,
+		},
+		{
+			name:          "failure is not processed",
+			faults:        config.GatewayFaultConfig{FailureRate: 1},
+			request:       payment,
+			expectedError: internal.ErrGatewayUnavailable,
+		},
+		{
+			name:          "timeout is not processed",
+			faults:        config.GatewayFaultConfig{TimeoutRate: 1},
+			request:       payment,
+			expectedError: internal.ErrGatewayUnavailable,
+		},
+		{
+			name:          "lost response is processed by the gateway",
+			faults:        config.GatewayFaultConfig{LostResponseRate: 1},
+			request:       payment,
+			expectedError: internal.ErrGatewayUnavailable,
+			storedStatus:  internal.PaymentStatusSuccess,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := repository.NewFaultyGatewayClient(tt.faults, 10*time.Millisecond)
+
+			response, err := gateway.CreatePayment(context.Background(), "payment-123", tt.request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, repository.MockGatewayName, response.Gateway)
+			assert.Equal(t, tt.expectedStatus, response.Status)
+			assert.Equal(t, tt.expectedCode, response.ResponseCode)
+
+			if tt.storedStatus != "" {
+				stored, err := gateway.GetPaymentStatus(context.Background(), internal.Transaction{ID: "payment-123"})
+				require.NoError(t, err)
+				assert.Equal(t, tt.storedStatus, stored.Status)
+			}
+		})
+	}
+}
+
+func TestGatewayClientMock_LatencyRespectsContext(t *testing.T) {
+	gateway := repository.NewFaultyGatewayClient(config.GatewayFaultConfig{Latency: time.Second, LatencyJitter: time.Second}, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := gateway.CreatePayment(ctx, "payment-123", internal.PaymentRequest{UserID: 1234, Method: "card", Amount: internal.NewMoney(10050, "USD")})
+
+	assert.ErrorIs(t, err, internal.ErrGatewayUnavailable)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestGatewayClientMock_ForgetsPaymentsAfterRetention(t *testing.T) {
+	gateway := repository.NewFaultyGatewayClient(config.GatewayFaultConfig{Retention: 20 * time.Millisecond}, time.Minute)
+	payment := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: internal.NewMoney(10050, "USD")}
+
+	_, err := gateway.CreatePayment(context.Background(), "payment-123", payment)
+	require.NoError(t, err)
+	_, err = gateway.GetPaymentStatus(context.Background(), internal.Transaction{ID: "payment-123"})
+	require.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+
+	_, err = gateway.GetPaymentStatus(context.Background(), internal.Transaction{ID: "payment-123"})
+	assert.ErrorIs(t, err, internal.ErrGatewayPaymentNotFound)
+	_, err = gateway.Refund(context.Background(), "refund-123", internal.RefundRequest{UserID: 1234, TransactionID: "payment-123", Amount: payment.Amount})
+	assert.ErrorIs(t, err, internal.ErrGatewayPaymentNotFound)
+}
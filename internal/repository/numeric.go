@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// moneyFromNumeric converts a DECIMAL column scanned by pgx into Money using the
+// minor units of the given currency. A NULL value is treated as zero.
+func moneyFromNumeric(n pgtype.Numeric, currency string) (internal.Money, error) {
+	if !n.Valid {
+		return internal.NewMoney(0, currency), nil
+	}
+	if n.NaN || n.InfinityModifier != pgtype.Finite {
+		return internal.Money{}, fmt.Errorf("non finite numeric value")
+	}
+
+	minorUnits, err := internal.CurrencyMinorUnits(currency)
+	if err != nil {
+		return internal.Money{}, err
+	}
+
+	value, err := scaledFromNumeric(n, minorUnits)
+	if err != nil {
+		return internal.Money{}, fmt.Errorf("%v for %s", err, currency)
+	}
+
+	return internal.NewMoney(value, currency), nil
+}
+
+// numericFromMoney converts Money into a pgx numeric parameter
+func numericFromMoney(m internal.Money) (pgtype.Numeric, error) {
+	minorUnits, err := internal.CurrencyMinorUnits(m.Currency)
+	if err != nil {
+		return pgtype.Numeric{}, err
+	}
+
+	return pgtype.Numeric{
+		Int:   big.NewInt(m.MinorUnits),
+		Exp:   int32(-minorUnits),
+		Valid: true,
+	}, nil
+}
+
+// rateFromNumeric converts a DECIMAL column scanned by pgx into a Rate. A
+// NULL value is a zero rate.
+func rateFromNumeric(n pgtype.Numeric) (internal.Rate, error) {
+	if !n.Valid {
+		return 0, nil
+	}
+	if n.NaN || n.InfinityModifier != pgtype.Finite {
+		return 0, fmt.Errorf("non finite numeric value")
+	}
+
+	value, err := scaledFromNumeric(n, internal.RateDecimals)
+	if err != nil {
+		return 0, fmt.Errorf("%v for an exchange rate", err)
+	}
+
+	return internal.Rate(value), nil
+}
+
+// numericFromRate converts a Rate into a pgx numeric parameter
+func numericFromRate(r internal.Rate) pgtype.Numeric {
+	return pgtype.Numeric{
+		Int:   big.NewInt(int64(r)),
+		Exp:   -internal.RateDecimals,
+		Valid: true,
+	}
+}
+
+// scaledFromNumeric returns n as an integer scaled by 10^scale, failing if n
+// has more decimal places than scale or does not fit in an int64
+func scaledFromNumeric(n pgtype.Numeric, scale int) (int64, error) {
+	value := new(big.Int)
+	if n.Int != nil {
+		value.Set(n.Int)
+	}
+	exp := int64(n.Exp) + int64(scale)
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(abs(exp)), nil)
+	if exp >= 0 {
+		value.Mul(value, factor)
+	} else {
+		remainder := new(big.Int)
+		value.QuoRem(value, factor, remainder)
+		if remainder.Sign() != 0 {
+			return 0, fmt.Errorf("numeric value has too many decimal places")
+		}
+	}
+
+	if !value.IsInt64() {
+		return 0, fmt.Errorf("numeric value out of range")
+	}
+
+	return value.Int64(), nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateVaultedCard stores an encrypted card under its token
+func (s *PostgresStorage) CreateVaultedCard(ctx context.Context, card internal.VaultedCard) (internal.VaultedCard, error) {
+	err := s.pool.QueryRow(
+		ctx,
+		`INSERT INTO card_vault (token, user_id, last4, expiry_month, expiry_year, encrypted_card, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		 RETURNING created_at`,
+		card.Token,
+		card.UserID,
+		card.Last4,
+		card.ExpiryMonth,
+		card.ExpiryYear,
+		card.EncryptedCard,
+	).Scan(&card.CreatedAt)
+	if err != nil {
+		return internal.VaultedCard{}, fmt.Errorf("error creating vaulted card: %v", err)
+	}
+
+	return card, nil
+}
+
+// GetVaultedCard returns the encrypted card of a token. Tokens of other users
+// fail with internal.ErrCardNotFound.
+func (s *PostgresStorage) GetVaultedCard(ctx context.Context, userID uint64, token string) (internal.VaultedCard, error) {
+	var card internal.VaultedCard
+	err := s.pool.QueryRow(
+		ctx,
+		`SELECT token, user_id, last4, expiry_month, expiry_year, encrypted_card, created_at
+		 FROM card_vault
+		 WHERE token = $1 AND user_id = $2`,
+		token,
+		userID,
+	).Scan(
+		&card.Token,
+		&card.UserID,
+		&card.Last4,
+		&card.ExpiryMonth,
+		&card.ExpiryYear,
+		&card.EncryptedCard,
+		&card.CreatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return internal.VaultedCard{}, fmt.Errorf("%w: %s", internal.ErrCardNotFound, token)
+	}
+	if err != nil {
+		return internal.VaultedCard{}, fmt.Errorf("error getting vaulted card: %v", err)
+	}
+
+	return card, nil
+}
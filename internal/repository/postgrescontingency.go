@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// contingencyColumns lists the columns read by collectPaymentContingencies, in order
+const contingencyColumns = `id, transaction_id, transaction_type, user_id, method, amount, currency, intended_status,
+	COALESCE(gateway_reference, ''), COALESCE(gateway_name, ''), COALESCE(gateway_response_code, ''),
+	attempts, COALESCE(last_error, ''), next_attempt_at, created_at`
+
+// CreatePaymentContingency records a payment, deposit or refund whose final status could not be stored
+func (s *PostgresStorage) CreatePaymentContingency(ctx context.Context, contingency internal.PaymentContingency) error {
+	amount, err := numericFromMoney(contingency.PaymentRequest.Amount)
+	if err != nil {
+		return fmt.Errorf("error converting amount: %v", err)
+	}
+
+	_, err = s.pool.Exec(
+		ctx,
+		`INSERT INTO payment_contingencies
+		 (transaction_id, transaction_type, user_id, method, amount, currency, intended_status,
+		  gateway_reference, gateway_name, gateway_response_code,
+		  last_error, next_attempt_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NOW(), NOW())`,
+		contingency.TransactionID,
+		contingency.TransactionType,
+		contingency.PaymentRequest.UserID,
+		contingency.PaymentRequest.Method,
+		amount,
+		contingency.PaymentRequest.Amount.Currency,
+		contingency.IntendedStatus,
+		contingency.GatewayResponse.Reference,
+		contingency.GatewayResponse.Gateway,
+		contingency.GatewayResponse.ResponseCode,
+		contingency.LastError,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating payment contingency: %v", err)
+	}
+
+	return nil
+}
+
+// ClaimDuePaymentContingencies returns unresolved contingencies ready to be
+// retried and pushes their next attempt forward by lease, so other instances
+// running the worker skip them meanwhile
+func (s *PostgresStorage) ClaimDuePaymentContingencies(ctx context.Context, limit int, lease time.Duration) ([]internal.PaymentContingency, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`UPDATE payment_contingencies
+		 SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		 WHERE id IN (
+		     SELECT id FROM payment_contingencies
+		     WHERE resolved_at IS NULL AND next_attempt_at <= NOW()
+		     ORDER BY next_attempt_at
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+contingencyColumns,
+		limit,
+		lease.Seconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming payment contingencies: %v", err)
+	}
+
+	return collectPaymentContingencies(rows)
+}
+
+// GetStuckPaymentContingencies lists unresolved contingencies that already
+// failed at least minAttempts times
+func (s *PostgresStorage) GetStuckPaymentContingencies(ctx context.Context, minAttempts int) ([]internal.PaymentContingency, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT `+contingencyColumns+`
+		 FROM payment_contingencies
+		 WHERE resolved_at IS NULL AND attempts >= $1
+		 ORDER BY created_at
+		 LIMIT 100`,
+		minAttempts,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying payment contingencies: %v", err)
+	}
+
+	return collectPaymentContingencies(rows)
+}
+
+// ResolvePaymentContingency marks a contingency as done
+func (s *PostgresStorage) ResolvePaymentContingency(ctx context.Context, contingencyID int64) error {
+	_, err := s.pool.Exec(
+		ctx,
+		"UPDATE payment_contingencies SET resolved_at = NOW() WHERE id = $1",
+		contingencyID,
+	)
+	if err != nil {
+		return fmt.Errorf("error resolving payment contingency: %v", err)
+	}
+
+	return nil
+}
+
+// ReschedulePaymentContingency records a failed attempt and when to try again
+func (s *PostgresStorage) ReschedulePaymentContingency(ctx context.Context, contingencyID int64, nextAttemptAt time.Time, lastError string) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`UPDATE payment_contingencies
+		 SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+		 WHERE id = $1`,
+		contingencyID,
+		nextAttemptAt,
+		lastError,
+	)
+	if err != nil {
+		return fmt.Errorf("error rescheduling payment contingency: %v", err)
+	}
+
+	return nil
+}
+
+func collectPaymentContingencies(rows pgx.Rows) ([]internal.PaymentContingency, error) {
+	defer rows.Close()
+
+	var contingencies []internal.PaymentContingency
+	for rows.Next() {
+		var c internal.PaymentContingency
+		var currency string
+		var amount pgtype.Numeric
+		err := rows.Scan(
+			&c.ID,
+			&c.TransactionID,
+			&c.TransactionType,
+			&c.PaymentRequest.UserID,
+			&c.PaymentRequest.Method,
+			&amount,
+			&currency,
+			&c.IntendedStatus,
+			&c.GatewayResponse.Reference,
+			&c.GatewayResponse.Gateway,
+			&c.GatewayResponse.ResponseCode,
+			&c.Attempts,
+			&c.LastError,
+			&c.NextAttemptAt,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning payment contingency row: %v", err)
+		}
+
+		c.PaymentRequest.Amount, err = moneyFromNumeric(amount, currency)
+		if err != nil {
+			return nil, fmt.Errorf("error reading payment contingency amount: %v", err)
+		}
+		contingencies = append(contingencies, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating payment contingency rows: %v", err)
+	}
+
+	return contingencies, nil
+}
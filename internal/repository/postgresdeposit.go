@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/google/uuid"
+)
+
+// CreateDepositRequest creates a pending deposit, opening the wallet's
+// balance in the deposit currency if the user does not have one yet. The
+// balance is credited once the gateway confirms the funds.
+func (s *PostgresStorage) CreateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest) (string, error) {
+	amount, err := numericFromMoney(depositRequest.Amount)
+	if err != nil {
+		return "", fmt.Errorf("error converting amount: %v", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO user_balances (user_id, currency, balance)
+		 VALUES ($1, $2, 0)
+		 ON CONFLICT (user_id, currency) DO NOTHING`,
+		depositRequest.UserID,
+		depositRequest.Amount.Currency,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error creating wallet: %v", err)
+	}
+
+	transactionID := uuid.New().String()
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO transactions 
+		 (id, user_id, amount, currency, transaction_type, status, method, created_at)
+		 VALUES ($1, $2, $3, $4, 'deposit', 'pending', $5, NOW())`,
+		transactionID,
+		depositRequest.UserID,
+		amount,
+		depositRequest.Amount.Currency,
+		depositRequest.Method,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error creating transaction: %v", err)
+	}
+
+	if err := recordTransactionCreated(ctx, tx, transactionID, internal.PaymentStatusPending); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return transactionID, nil
+}
+
+// UpdateDepositRequest sets the final status of a pending deposit, crediting
+// the wallet when it succeeded. It returns internal.ErrTransactionNotPending
+// if the deposit was already finalised.
+func (s *PostgresStorage) UpdateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	amount, err := numericFromMoney(depositRequest.Amount)
+	if err != nil {
+		return fmt.Errorf("error converting amount: %v", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	if err := finalizeTransaction(ctx, tx, transactionID, status, gatewayResponse); err != nil {
+		return err
+	}
+
+	if status == internal.PaymentStatusSuccess {
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE user_balances
+			 SET balance = balance + $3, updated_at = NOW()
+			 WHERE user_id = $1 AND currency = $2`,
+			depositRequest.UserID,
+			depositRequest.Amount.Currency,
+			amount,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating balance: %v", err)
+		}
+
+		entry := internal.NewDepositEntry(transactionID, depositRequest.UserID, depositRequest.Amount)
+		if err := postJournalEntry(ctx, tx, entry); err != nil {
+			return fmt.Errorf("error posting deposit to ledger: %v", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// fxQuoteColumns lists the columns read by scanFXQuote, in order
+const fxQuoteColumns = `id, user_id, sell_amount, sell_currency, buy_amount, buy_currency, rate,
+	COALESCE(outgoing_transaction_id::text, ''), COALESCE(incoming_transaction_id::text, ''),
+	expires_at, executed_at, created_at`
+
+// GetExchangeRate returns the rate to buy quote currency with base currency
+// from the fx_rates table. A pair only listed the other way round is used at
+// its inverse rate. It returns internal.ErrRateNotFound if neither is listed.
+func (s *PostgresStorage) GetExchangeRate(ctx context.Context, base string, quote string) (internal.Rate, error) {
+	var rate pgtype.Numeric
+	err := s.pool.QueryRow(
+		ctx,
+		`SELECT rate FROM (
+		     SELECT rate, 0 AS preference FROM fx_rates WHERE base_currency = $1 AND quote_currency = $2
+		     UNION ALL
+		     SELECT ROUND(1 / rate, 8), 1 FROM fx_rates WHERE base_currency = $2 AND quote_currency = $1
+		 ) rates
+		 ORDER BY preference
+		 LIMIT 1`,
+		base,
+		quote,
+	).Scan(&rate)
+	if err == pgx.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s to %s", internal.ErrRateNotFound, base, quote)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error getting exchange rate: %v", err)
+	}
+
+	exchangeRate, err := rateFromNumeric(rate)
+	if err != nil {
+		return 0, fmt.Errorf("error reading exchange rate: %v", err)
+	}
+	if exchangeRate <= 0 {
+		return 0, fmt.Errorf("%w: %s to %s rounds to zero", internal.ErrRateNotFound, base, quote)
+	}
+
+	return exchangeRate, nil
+}
+
+// CreateFXQuote stores a quote and returns it with its ID
+func (s *PostgresStorage) CreateFXQuote(ctx context.Context, quote internal.FXQuote) (internal.FXQuote, error) {
+	sellAmount, err := numericFromMoney(quote.SellAmount)
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error converting amount: %v", err)
+	}
+	buyAmount, err := numericFromMoney(quote.BuyAmount)
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error converting amount: %v", err)
+	}
+
+	created, err := scanFXQuote(s.pool.QueryRow(
+		ctx,
+		`INSERT INTO fx_quotes
+		 (id, user_id, sell_amount, sell_currency, buy_amount, buy_currency, rate, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		 RETURNING `+fxQuoteColumns,
+		uuid.New().String(),
+		quote.UserID,
+		sellAmount,
+		quote.SellAmount.Currency,
+		buyAmount,
+		quote.BuyAmount.Currency,
+		numericFromRate(quote.Rate),
+		quote.ExpiresAt,
+	))
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error creating fx quote: %v", err)
+	}
+
+	return created, nil
+}
+
+// ExecuteFXQuote exchanges the amounts of an active quote between two
+// balances of the user in a single transaction: the sold currency is debited
+// with an fx_out transaction and the bought one credited with an fx_in
+// transaction, both keeping the quoted rate. The balance in the bought
+// currency is opened if needed. It returns internal.ErrQuoteNotFound if the
+// quote does not exist or belongs to another user,
+// internal.ErrQuoteNotActive if it expired or was executed already and
+// internal.ErrNotEnoughBalance if the sold balance cannot cover it.
+func (s *PostgresStorage) ExecuteFXQuote(ctx context.Context, userID uint64, quoteID string) (internal.FXQuote, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	quote, err := scanFXQuote(tx.QueryRow(
+		ctx,
+		`SELECT `+fxQuoteColumns+`
+		 FROM fx_quotes
+		 WHERE id = $1 AND user_id = $2
+		 FOR UPDATE`,
+		quoteID,
+		userID,
+	))
+	if err == pgx.ErrNoRows {
+		return internal.FXQuote{}, fmt.Errorf("%w: %s", internal.ErrQuoteNotFound, quoteID)
+	}
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error getting fx quote: %v", err)
+	}
+	if quote.ExecutedAt != nil {
+		return internal.FXQuote{}, fmt.Errorf("%w: executed at %s", internal.ErrQuoteNotActive, quote.ExecutedAt.Format(time.RFC3339))
+	}
+	if !quote.ExpiresAt.After(time.Now()) {
+		return internal.FXQuote{}, fmt.Errorf("%w: expired at %s", internal.ErrQuoteNotActive, quote.ExpiresAt.Format(time.RFC3339))
+	}
+
+	// Lock both balances in currency order, like transfers lock wallets in
+	// user ID order, so concurrent exchanges cannot deadlock
+	first, second := quote.SellCurrency, quote.BuyCurrency
+	if second < first {
+		first, second = second, first
+	}
+	balances := make(map[string]pgtype.Numeric, 2)
+	for _, currency := range []string{first, second} {
+		balance, err := lockBalance(ctx, tx, userID, currency)
+		if err != nil {
+			return internal.FXQuote{}, err
+		}
+		balances[currency] = balance
+	}
+
+	available, err := moneyFromNumeric(balances[quote.SellCurrency], quote.SellCurrency)
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error reading balance: %v", err)
+	}
+	if available.LessThan(quote.SellAmount) {
+		return internal.FXQuote{}, internal.ErrNotEnoughBalance
+	}
+
+	quote.OutgoingTransactionID = uuid.New().String()
+	quote.IncomingTransactionID = uuid.New().String()
+
+	sides := []struct {
+		transactionID   string
+		amount          internal.Money
+		transactionType string
+		delta           string
+		entry           internal.JournalEntry
+	}{
+		{
+			quote.OutgoingTransactionID, quote.SellAmount, internal.TransactionTypeFXOut, "balance - $3",
+			internal.NewFXOutEntry(quote.OutgoingTransactionID, userID, quote.SellAmount),
+		},
+		{
+			quote.IncomingTransactionID, quote.BuyAmount, internal.TransactionTypeFXIn, "balance + $3",
+			internal.NewFXInEntry(quote.IncomingTransactionID, userID, quote.BuyAmount),
+		},
+	}
+	for _, side := range sides {
+		amount, err := numericFromMoney(side.amount)
+		if err != nil {
+			return internal.FXQuote{}, fmt.Errorf("error converting amount: %v", err)
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE user_balances
+			 SET balance = `+side.delta+`, updated_at = NOW()
+			 WHERE user_id = $1 AND currency = $2`,
+			userID,
+			side.amount.Currency,
+			amount,
+		)
+		if err != nil {
+			return internal.FXQuote{}, fmt.Errorf("error updating balance: %v", err)
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO transactions
+			 (id, user_id, amount, currency, transaction_type, status, transfer_id, exchange_rate, created_at)
+			 VALUES ($1, $2, $3, $4, $5, 'success', $6, $7, NOW())`,
+			side.transactionID,
+			userID,
+			amount,
+			side.amount.Currency,
+			side.transactionType,
+			quote.ID,
+			numericFromRate(quote.Rate),
+		)
+		if err != nil {
+			return internal.FXQuote{}, fmt.Errorf("error creating transaction: %v", err)
+		}
+
+		if err := recordTransactionCreated(ctx, tx, side.transactionID, internal.PaymentStatusSuccess); err != nil {
+			return internal.FXQuote{}, err
+		}
+
+		if err := postJournalEntry(ctx, tx, side.entry); err != nil {
+			return internal.FXQuote{}, fmt.Errorf("error posting exchange to ledger: %v", err)
+		}
+	}
+
+	quote, err = scanFXQuote(tx.QueryRow(
+		ctx,
+		`UPDATE fx_quotes
+		 SET outgoing_transaction_id = $2, incoming_transaction_id = $3, executed_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+fxQuoteColumns,
+		quote.ID,
+		quote.OutgoingTransactionID,
+		quote.IncomingTransactionID,
+	))
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error executing fx quote: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return quote, nil
+}
+
+// scanFXQuote reads a row selected with fxQuoteColumns
+func scanFXQuote(row pgx.Row) (internal.FXQuote, error) {
+	var q internal.FXQuote
+	var sellAmount, buyAmount, rate pgtype.Numeric
+	err := row.Scan(
+		&q.ID,
+		&q.UserID,
+		&sellAmount,
+		&q.SellCurrency,
+		&buyAmount,
+		&q.BuyCurrency,
+		&rate,
+		&q.OutgoingTransactionID,
+		&q.IncomingTransactionID,
+		&q.ExpiresAt,
+		&q.ExecutedAt,
+		&q.CreatedAt,
+	)
+	if err != nil {
+		return internal.FXQuote{}, err
+	}
+
+	q.SellAmount, err = moneyFromNumeric(sellAmount, q.SellCurrency)
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error reading sell amount: %v", err)
+	}
+	q.BuyAmount, err = moneyFromNumeric(buyAmount, q.BuyCurrency)
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error reading buy amount: %v", err)
+	}
+	q.Rate, err = rateFromNumeric(rate)
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("error reading rate: %v", err)
+	}
+
+	return q, nil
+}
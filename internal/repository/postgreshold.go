@@ -0,0 +1,378 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// holdColumns lists the columns read by scanHold, in order
+const holdColumns = `id, user_id, method, amount, currency, captured_amount, status,
+	COALESCE(transaction_id::text, ''), expires_at, created_at, details`
+
+// CreateHold reserves the amount of the request until expiresAt. It returns
+// internal.ErrNotEnoughBalance if the available balance does not cover it.
+func (s *PostgresStorage) CreateHold(ctx context.Context, paymentRequest internal.PaymentRequest, expiresAt time.Time) (internal.Hold, error) {
+	amount, err := numericFromMoney(paymentRequest.Amount)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error converting amount: %v", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	result, err := tx.Exec(
+		ctx,
+		`UPDATE user_balances
+		 SET held_balance = held_balance + $3, updated_at = NOW()
+		 WHERE user_id = $1 AND currency = $2 AND balance - held_balance >= $3`,
+		paymentRequest.UserID,
+		paymentRequest.Amount.Currency,
+		amount,
+	)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error updating held balance: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return internal.Hold{}, internal.ErrNotEnoughBalance
+	}
+
+	hold, err := scanHold(tx.QueryRow(
+		ctx,
+		`INSERT INTO payment_holds (id, user_id, method, amount, currency, details, status, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, 'active', $7, NOW())
+		 RETURNING `+holdColumns,
+		uuid.New().String(),
+		paymentRequest.UserID,
+		paymentRequest.Method,
+		amount,
+		paymentRequest.Amount.Currency,
+		paymentRequest.Details,
+		expiresAt,
+	))
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error creating hold: %v", err)
+	}
+
+	if err := recordHoldStatusChange(ctx, tx, hold.ID, "", hold.Status, internal.StatusReasonCreated); err != nil {
+		return internal.Hold{}, err
+	}
+
+	if err := recordIdempotencyResource(ctx, tx, hold.ID); err != nil {
+		return internal.Hold{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return internal.Hold{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return hold, nil
+}
+
+// CaptureHold turns an active hold into a pending payment of the captured
+// amount, debiting the wallet and releasing the rest of the hold. The
+// returned hold carries the ID of the payment transaction.
+func (s *PostgresStorage) CaptureHold(ctx context.Context, captureRequest internal.CaptureRequest) (internal.Hold, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	hold, err := lockActiveHold(ctx, tx, captureRequest.UserID, captureRequest.HoldID)
+	if err != nil {
+		return internal.Hold{}, err
+	}
+	if !hold.ExpiresAt.After(time.Now()) {
+		return internal.Hold{}, fmt.Errorf("%w: hold expired at %s", internal.ErrHoldNotActive, hold.ExpiresAt.Format(time.RFC3339))
+	}
+
+	captured := captureRequest.Amount
+	if captured.MinorUnits == 0 {
+		captured = hold.Amount
+	}
+	if captured.Currency != hold.Currency {
+		return internal.Hold{}, fmt.Errorf("%w: hold is in %s, capture in %s", internal.ErrCurrencyMismatch, hold.Currency, captured.Currency)
+	}
+	if hold.Amount.LessThan(captured) {
+		return internal.Hold{}, fmt.Errorf("%w: %s held", internal.ErrCaptureExceedsHold, hold.Amount)
+	}
+
+	heldAmount, err := numericFromMoney(hold.Amount)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error converting amount: %v", err)
+	}
+	capturedAmount, err := numericFromMoney(captured)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error converting amount: %v", err)
+	}
+
+	// The held funds are always part of the balance, so the debit cannot
+	// overdraw the wallet
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE user_balances
+		 SET balance = balance - $4, held_balance = held_balance - $3, updated_at = NOW()
+		 WHERE user_id = $1 AND currency = $2`,
+		hold.UserID,
+		hold.Currency,
+		heldAmount,
+		capturedAmount,
+	)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error updating balance: %v", err)
+	}
+
+	transactionID := uuid.New().String()
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO transactions
+		 (id, user_id, amount, currency, transaction_type, status, method, created_at)
+		 VALUES ($1, $2, $3, $4, 'payment', 'pending', $5, NOW())`,
+		transactionID,
+		hold.UserID,
+		capturedAmount,
+		hold.Currency,
+		hold.Method,
+	)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error creating transaction: %v", err)
+	}
+
+	err = postJournalEntry(ctx, tx, internal.NewPaymentEntry(transactionID, hold.UserID, captured))
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error posting payment to ledger: %v", err)
+	}
+
+	if err := recordTransactionCreated(ctx, tx, transactionID, internal.PaymentStatusPending); err != nil {
+		return internal.Hold{}, err
+	}
+
+	hold, err = transitionHold(ctx, tx, hold.ID, internal.HoldStatusCaptured, internal.StatusReasonCapture, capturedAmount, transactionID)
+	if err != nil {
+		return internal.Hold{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return internal.Hold{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return hold, nil
+}
+
+// VoidHold releases an active hold of the user without charging it
+func (s *PostgresStorage) VoidHold(ctx context.Context, userID uint64, holdID string) (internal.Hold, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	hold, err := lockActiveHold(ctx, tx, userID, holdID)
+	if err != nil {
+		return internal.Hold{}, err
+	}
+
+	hold, err = releaseHold(ctx, tx, hold, internal.HoldStatusVoided, internal.StatusReasonVoid)
+	if err != nil {
+		return internal.Hold{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return internal.Hold{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return hold, nil
+}
+
+// ReleaseExpiredHolds expires up to limit active holds past their expiry,
+// returning their funds to the available balance. Holds being captured or
+// voided at the same time are skipped.
+func (s *PostgresStorage) ReleaseExpiredHolds(ctx context.Context, limit int) ([]internal.Hold, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT `+holdColumns+`
+		 FROM payment_holds
+		 WHERE status = 'active' AND expires_at <= NOW()
+		 ORDER BY expires_at
+		 LIMIT $1
+		 FOR UPDATE SKIP LOCKED`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying expired holds: %v", err)
+	}
+	expired, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (internal.Hold, error) {
+		return scanHold(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning hold row: %v", err)
+	}
+
+	released := make([]internal.Hold, 0, len(expired))
+	for _, hold := range expired {
+		hold, err := releaseHold(ctx, tx, hold, internal.HoldStatusExpired, internal.StatusReasonExpiry)
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, hold)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return released, nil
+}
+
+// lockActiveHold locks the user's hold until tx ends. Holds of other users
+// are reported as not found.
+func lockActiveHold(ctx context.Context, tx pgx.Tx, userID uint64, holdID string) (internal.Hold, error) {
+	hold, err := scanHold(tx.QueryRow(
+		ctx,
+		`SELECT `+holdColumns+`
+		 FROM payment_holds
+		 WHERE id = $1 AND user_id = $2
+		 FOR UPDATE`,
+		holdID,
+		userID,
+	))
+	if err == pgx.ErrNoRows {
+		return internal.Hold{}, fmt.Errorf("%w: %s", internal.ErrHoldNotFound, holdID)
+	}
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error getting hold: %v", err)
+	}
+	if hold.Status != internal.HoldStatusActive {
+		return internal.Hold{}, fmt.Errorf("%w: hold is %s", internal.ErrHoldNotActive, hold.Status)
+	}
+
+	return hold, nil
+}
+
+// releaseHold returns the funds of a locked active hold to the available
+// balance and closes it with status
+func releaseHold(ctx context.Context, tx pgx.Tx, hold internal.Hold, status string, reason string) (internal.Hold, error) {
+	amount, err := numericFromMoney(hold.Amount)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error converting amount: %v", err)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE user_balances
+		 SET held_balance = held_balance - $3, updated_at = NOW()
+		 WHERE user_id = $1 AND currency = $2`,
+		hold.UserID,
+		hold.Currency,
+		amount,
+	)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error updating held balance: %v", err)
+	}
+
+	return transitionHold(ctx, tx, hold.ID, status, reason, pgtype.Numeric{}, "")
+}
+
+// transitionHold moves an active hold to status, storing the captured amount
+// and payment transaction when given. Like transitionTransaction, the update
+// only applies while the hold is still active and is recorded in its status
+// history; a hold no longer active fails with internal.ErrHoldNotActive.
+func transitionHold(ctx context.Context, tx pgx.Tx, holdID string, status string, reason string, capturedAmount pgtype.Numeric, transactionID string) (internal.Hold, error) {
+	if err := internal.ValidateHoldTransition(internal.HoldStatusActive, status); err != nil {
+		return internal.Hold{}, err
+	}
+
+	hold, err := scanHold(tx.QueryRow(
+		ctx,
+		`UPDATE payment_holds
+		 SET status = $3,
+		     captured_amount = COALESCE($4, captured_amount),
+		     transaction_id = COALESCE(NULLIF($5, '')::uuid, transaction_id),
+		     updated_at = NOW()
+		 WHERE id = $1 AND status = $2
+		 RETURNING `+holdColumns,
+		holdID,
+		internal.HoldStatusActive,
+		status,
+		capturedAmount,
+		transactionID,
+	))
+	if err == pgx.ErrNoRows {
+		return internal.Hold{}, fmt.Errorf("%w: %s changed concurrently", internal.ErrHoldNotActive, holdID)
+	}
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error updating hold status: %v", err)
+	}
+
+	if err := recordHoldStatusChange(ctx, tx, holdID, internal.HoldStatusActive, status, reason); err != nil {
+		return internal.Hold{}, err
+	}
+
+	return hold, nil
+}
+
+// recordHoldStatusChange appends an entry to the status history of a hold
+func recordHoldStatusChange(ctx context.Context, tx pgx.Tx, holdID string, from string, to string, reason string) error {
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO hold_status_history (hold_id, from_status, to_status, reason, created_at)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, NOW())`,
+		holdID,
+		from,
+		to,
+		reason,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording hold status change: %v", err)
+	}
+
+	return nil
+}
+
+// scanHold reads a row selected with holdColumns
+func scanHold(row pgx.Row) (internal.Hold, error) {
+	var h internal.Hold
+	var amount, capturedAmount pgtype.Numeric
+	err := row.Scan(
+		&h.ID,
+		&h.UserID,
+		&h.Method,
+		&amount,
+		&h.Currency,
+		&capturedAmount,
+		&h.Status,
+		&h.TransactionID,
+		&h.ExpiresAt,
+		&h.CreatedAt,
+		&h.Details,
+	)
+	if err != nil {
+		return internal.Hold{}, err
+	}
+
+	h.Amount, err = moneyFromNumeric(amount, h.Currency)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error reading hold amount: %v", err)
+	}
+	h.CapturedAmount, err = moneyFromNumeric(capturedAmount, h.Currency)
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("error reading captured amount: %v", err)
+	}
+
+	return h, nil
+}
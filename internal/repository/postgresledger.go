@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// postJournalEntry writes a balanced journal entry inside tx, creating the
+// ledger accounts it touches on first use
+func postJournalEntry(ctx context.Context, tx pgx.Tx, entry internal.JournalEntry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	entryID := uuid.New().String()
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO ledger_entries (id, transaction_id, description, created_at)
+		 VALUES ($1, NULLIF($2, '')::uuid, $3, NOW())`,
+		entryID,
+		entry.TransactionID,
+		entry.Description,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating ledger entry: %v", err)
+	}
+
+	for _, posting := range entry.Postings {
+		accountID, err := ensureLedgerAccount(ctx, tx, posting.Account)
+		if err != nil {
+			return err
+		}
+
+		amount, err := numericFromMoney(posting.Amount)
+		if err != nil {
+			return fmt.Errorf("error converting amount: %v", err)
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO ledger_postings (entry_id, account_id, amount, created_at)
+			 VALUES ($1, $2, $3, NOW())`,
+			entryID,
+			accountID,
+			amount,
+		)
+		if err != nil {
+			return fmt.Errorf("error creating ledger posting: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// ensureLedgerAccount returns the ID of the account, creating it if needed.
+// Existing accounts are only read, so concurrent entries posting to the same
+// system account do not serialise on its row.
+func ensureLedgerAccount(ctx context.Context, tx pgx.Tx, account internal.LedgerAccount) (int64, error) {
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO ledger_accounts (code, account_type, user_id, created_at)
+		 VALUES ($1, $2, NULLIF($3::bigint, 0), NOW())
+		 ON CONFLICT (code) DO NOTHING`,
+		account.Code,
+		account.Type,
+		int64(account.UserID),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error creating ledger account %s: %v", account.Code, err)
+	}
+
+	var accountID int64
+	err = tx.QueryRow(
+		ctx,
+		`SELECT id FROM ledger_accounts WHERE code = $1`,
+		account.Code,
+	).Scan(&accountID)
+	if err != nil {
+		return 0, fmt.Errorf("error getting ledger account %s: %v", account.Code, err)
+	}
+
+	return accountID, nil
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/jackc/pgx/v5"
+)
+
+// deliveryColumns lists the columns read by collectWebhookDeliveries, in order.
+// They are read from webhook_deliveries d joined with outbox_events e and
+// webhook_subscriptions s.
+const deliveryColumns = `d.id, d.subscription_id, s.url, s.secret,
+	e.id, e.event_type, e.payload, e.created_at,
+	d.status, d.attempts, COALESCE(d.last_error, ''), d.next_attempt_at, d.created_at`
+
+// recordTransactionEvent writes the current state of a transaction to the
+// outbox within tx, so the event is published only if tx commits
+func recordTransactionEvent(ctx context.Context, tx pgx.Tx, eventType string, transactionID string) error {
+	transaction, err := scanTransaction(tx.QueryRow(
+		ctx,
+		`SELECT `+transactionColumns+`
+		 FROM transactions
+		 WHERE id = $1`,
+		transactionID,
+	))
+	if err != nil {
+		return fmt.Errorf("error getting transaction for outbox: %v", err)
+	}
+
+	payload, err := json.Marshal(transaction)
+	if err != nil {
+		return fmt.Errorf("error encoding outbox event: %v", err)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO outbox_events (event_type, aggregate_id, payload, created_at)
+		 VALUES ($1, $2, $3, NOW())`,
+		eventType,
+		transactionID,
+		payload,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording outbox event: %v", err)
+	}
+
+	return nil
+}
+
+// FanOutOutboxEvents creates a pending delivery of up to limit undispatched
+// outbox events for every active subscription and marks the events as
+// dispatched. It returns how many events were dispatched.
+func (s *PostgresStorage) FanOutOutboxEvents(ctx context.Context, limit int) (int, error) {
+	var dispatched int
+	err := s.pool.QueryRow(
+		ctx,
+		`WITH events AS (
+		     SELECT id FROM outbox_events
+		     WHERE dispatched_at IS NULL
+		     ORDER BY id
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED
+		 ), deliveries AS (
+		     INSERT INTO webhook_deliveries (event_id, subscription_id, next_attempt_at, created_at)
+		     SELECT e.id, s.id, NOW(), NOW()
+		     FROM events e CROSS JOIN webhook_subscriptions s
+		     WHERE s.active
+		     ON CONFLICT (event_id, subscription_id) DO NOTHING
+		 ), marked AS (
+		     UPDATE outbox_events SET dispatched_at = NOW()
+		     WHERE id IN (SELECT id FROM events)
+		     RETURNING id
+		 )
+		 SELECT COUNT(*) FROM marked`,
+		limit,
+	).Scan(&dispatched)
+	if err != nil {
+		return 0, fmt.Errorf("error fanning out outbox events: %v", err)
+	}
+
+	return dispatched, nil
+}
+
+// ClaimDueWebhookDeliveries returns pending deliveries ready to be attempted
+// and pushes their next attempt forward by lease, so other instances running
+// the dispatcher skip them meanwhile
+func (s *PostgresStorage) ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]internal.WebhookDelivery, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`WITH claimed AS (
+		     UPDATE webhook_deliveries
+		     SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		     WHERE id IN (
+		         SELECT id FROM webhook_deliveries
+		         WHERE status = 'pending' AND next_attempt_at <= NOW()
+		         ORDER BY next_attempt_at
+		         LIMIT $1
+		         FOR UPDATE SKIP LOCKED
+		     )
+		     RETURNING *
+		 )
+		 SELECT `+deliveryColumns+`
+		 FROM claimed d
+		 JOIN outbox_events e ON e.id = d.event_id
+		 JOIN webhook_subscriptions s ON s.id = d.subscription_id`,
+		limit,
+		lease.Seconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %v", err)
+	}
+
+	return collectWebhookDeliveries(rows)
+}
+
+// MarkWebhookDelivered records the successful attempt of a delivery
+func (s *PostgresStorage) MarkWebhookDelivered(ctx context.Context, deliveryID int64) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`UPDATE webhook_deliveries
+		 SET status = 'delivered', attempts = attempts + 1, last_error = NULL, delivered_at = NOW()
+		 WHERE id = $1`,
+		deliveryID,
+	)
+	if err != nil {
+		return fmt.Errorf("error marking webhook delivered: %v", err)
+	}
+
+	return nil
+}
+
+// RescheduleWebhookDelivery records a failed attempt and when to try again
+func (s *PostgresStorage) RescheduleWebhookDelivery(ctx context.Context, deliveryID int64, nextAttemptAt time.Time, lastError string) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`UPDATE webhook_deliveries
+		 SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+		 WHERE id = $1`,
+		deliveryID,
+		nextAttemptAt,
+		lastError,
+	)
+	if err != nil {
+		return fmt.Errorf("error rescheduling webhook delivery: %v", err)
+	}
+
+	return nil
+}
+
+// DeadLetterWebhookDelivery records the last failed attempt of a delivery
+// that will not be retried
+func (s *PostgresStorage) DeadLetterWebhookDelivery(ctx context.Context, deliveryID int64, lastError string) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`UPDATE webhook_deliveries
+		 SET status = 'dead', attempts = attempts + 1, last_error = $2
+		 WHERE id = $1`,
+		deliveryID,
+		lastError,
+	)
+	if err != nil {
+		return fmt.Errorf("error dead-lettering webhook delivery: %v", err)
+	}
+
+	return nil
+}
+
+// GetDeadWebhookDeliveries lists the most recent dead-lettered deliveries
+func (s *PostgresStorage) GetDeadWebhookDeliveries(ctx context.Context, limit int) ([]internal.WebhookDelivery, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT `+deliveryColumns+`
+		 FROM webhook_deliveries d
+		 JOIN outbox_events e ON e.id = d.event_id
+		 JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		 WHERE d.status = 'dead'
+		 ORDER BY d.created_at DESC
+		 LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying dead webhook deliveries: %v", err)
+	}
+
+	return collectWebhookDeliveries(rows)
+}
+
+func collectWebhookDeliveries(rows pgx.Rows) ([]internal.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []internal.WebhookDelivery
+	for rows.Next() {
+		var d internal.WebhookDelivery
+		err := rows.Scan(
+			&d.ID,
+			&d.SubscriptionID,
+			&d.URL,
+			&d.Secret,
+			&d.Event.ID,
+			&d.Event.Type,
+			&d.Event.Data,
+			&d.Event.CreatedAt,
+			&d.Status,
+			&d.Attempts,
+			&d.LastError,
+			&d.NextAttemptAt,
+			&d.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery row: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook delivery rows: %v", err)
+	}
+
+	return deliveries, nil
+}
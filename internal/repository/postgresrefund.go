@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// CreateRefundRequest reserves a pending refund against a successful payment
+// of the user. The payment row stays locked while the amount already refunded
+// is checked, so concurrent refunds can never exceed the payment. Pending
+// refunds count towards that total until they fail. The refund is in the
+// payment's currency; a given amount in another one fails with
+// internal.ErrCurrencyMismatch. The refund keeps the payment method and
+// gateway of the payment, so it is sent back to the gateway that charged it.
+func (s *PostgresStorage) CreateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest) (internal.Transaction, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	var (
+		userID   uint64
+		paid     pgtype.Numeric
+		status   string
+		method   string
+		gateway  string
+		currency string
+	)
+	err = tx.QueryRow(
+		ctx,
+		`SELECT user_id, amount, status, COALESCE(method, ''), COALESCE(gateway_name, ''), currency
+		 FROM transactions
+		 WHERE id = $1 AND transaction_type = 'payment'
+		 FOR UPDATE`,
+		refundRequest.TransactionID,
+	).Scan(&userID, &paid, &status, &method, &gateway, &currency)
+	if err == pgx.ErrNoRows || (err == nil && userID != refundRequest.UserID) {
+		return internal.Transaction{}, fmt.Errorf("%w: %s", internal.ErrTransactionNotFound, refundRequest.TransactionID)
+	}
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error getting payment: %v", err)
+	}
+	if status != internal.PaymentStatusSuccess && status != internal.PaymentStatusPartiallyRefunded {
+		return internal.Transaction{}, fmt.Errorf("%w: payment is %s", internal.ErrPaymentNotRefundable, status)
+	}
+	if refundRequest.Amount.MinorUnits != 0 && refundRequest.Amount.Currency != currency {
+		return internal.Transaction{}, fmt.Errorf("%w: payment is in %s, refund in %s", internal.ErrCurrencyMismatch, currency, refundRequest.Amount.Currency)
+	}
+
+	var refunded pgtype.Numeric
+	err = tx.QueryRow(
+		ctx,
+		`SELECT COALESCE(SUM(amount), 0)
+		 FROM transactions
+		 WHERE original_transaction_id = $1
+		   AND transaction_type = 'refund'
+		   AND status IN ('pending', 'success')`,
+		refundRequest.TransactionID,
+	).Scan(&refunded)
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error getting refunded amount: %v", err)
+	}
+
+	paidMoney, err := moneyFromNumeric(paid, currency)
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error reading payment amount: %v", err)
+	}
+	refundedMoney, err := moneyFromNumeric(refunded, currency)
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error reading refunded amount: %v", err)
+	}
+	remaining := internal.NewMoney(paidMoney.MinorUnits-refundedMoney.MinorUnits, paidMoney.Currency)
+
+	amount := refundRequest.Amount
+	if amount.MinorUnits == 0 {
+		amount = remaining
+	}
+	if !remaining.IsPositive() || remaining.LessThan(amount) {
+		return internal.Transaction{}, fmt.Errorf("%w: %s left to refund", internal.ErrRefundExceedsPayment, remaining)
+	}
+
+	numericAmount, err := numericFromMoney(amount)
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error converting amount: %v", err)
+	}
+
+	refund := internal.Transaction{
+		ID:                    uuid.New().String(),
+		UserID:                refundRequest.UserID,
+		Amount:                amount,
+		Currency:              currency,
+		Type:                  internal.TransactionTypeRefund,
+		Status:                internal.PaymentStatusPending,
+		Method:                method,
+		GatewayName:           gateway,
+		OriginalTransactionID: refundRequest.TransactionID,
+	}
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO transactions
+		 (id, user_id, amount, currency, transaction_type, status, method, gateway_name, original_transaction_id, created_at)
+		 VALUES ($1, $2, $3, $4, 'refund', 'pending', NULLIF($5, ''), NULLIF($6, ''), $7, NOW())
+		 RETURNING created_at, updated_at`,
+		refund.ID,
+		refund.UserID,
+		numericAmount,
+		refund.Currency,
+		refund.Method,
+		refund.GatewayName,
+		refund.OriginalTransactionID,
+	).Scan(&refund.CreatedAt, &refund.UpdatedAt)
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error creating transaction: %v", err)
+	}
+
+	if err := recordTransactionCreated(ctx, tx, refund.ID, internal.PaymentStatusPending); err != nil {
+		return internal.Transaction{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return internal.Transaction{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return refund, nil
+}
+
+// UpdateRefundRequest sets the final status of a pending refund, crediting
+// the wallet and moving the payment to refunded or partially refunded when it
+// succeeded. A failed refund no longer counts towards the
+// refunded total. It returns internal.ErrTransactionNotPending if the refund
+// was already finalised.
+func (s *PostgresStorage) UpdateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	amount, err := numericFromMoney(refundRequest.Amount)
+	if err != nil {
+		return fmt.Errorf("error converting amount: %v", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	if err := finalizeTransaction(ctx, tx, transactionID, status, gatewayResponse); err != nil {
+		return err
+	}
+
+	if status == internal.PaymentStatusSuccess {
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE user_balances
+			 SET balance = balance + $3, updated_at = NOW()
+			 WHERE user_id = $1 AND currency = $2`,
+			refundRequest.UserID,
+			refundRequest.Amount.Currency,
+			amount,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating balance: %v", err)
+		}
+
+		entry := internal.NewRefundEntry(transactionID, refundRequest.UserID, refundRequest.Amount)
+		if err := postJournalEntry(ctx, tx, entry); err != nil {
+			return fmt.Errorf("error posting refund to ledger: %v", err)
+		}
+
+		if err := markPaymentRefunded(ctx, tx, transactionID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// markPaymentRefunded moves the payment refunded by refundID to refunded once
+// its successful refunds cover the whole amount, or to partially refunded
+// otherwise
+func markPaymentRefunded(ctx context.Context, tx pgx.Tx, refundID string) error {
+	var (
+		paymentID string
+		status    string
+	)
+	err := tx.QueryRow(
+		ctx,
+		`SELECT id, status
+		 FROM transactions
+		 WHERE id = (SELECT original_transaction_id FROM transactions WHERE id = $1)
+		 FOR UPDATE`,
+		refundID,
+	).Scan(&paymentID, &status)
+	if err != nil {
+		return fmt.Errorf("error getting refunded payment: %v", err)
+	}
+
+	var fully bool
+	err = tx.QueryRow(
+		ctx,
+		`SELECT COALESCE(SUM(r.amount), 0) >= p.amount
+		 FROM transactions p
+		 LEFT JOIN transactions r
+		   ON r.original_transaction_id = p.id
+		  AND r.transaction_type = 'refund'
+		  AND r.status = 'success'
+		 WHERE p.id = $1
+		 GROUP BY p.amount`,
+		paymentID,
+	).Scan(&fully)
+	if err != nil {
+		return fmt.Errorf("error getting refunded amount: %v", err)
+	}
+
+	refunded := internal.PaymentStatusPartiallyRefunded
+	if fully {
+		refunded = internal.PaymentStatusRefunded
+	}
+
+	return transitionTransaction(ctx, tx, paymentID, status, refunded, internal.StatusReasonRefund, internal.GatewayResponse{})
+}
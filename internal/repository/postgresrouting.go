@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+)
+
+// SetTransactionGateway records the gateway a pending transaction is about to
+// be sent to, so later status queries and refunds go to the same gateway. It
+// returns internal.ErrTransactionNotPending if the transaction was already
+// finalised.
+func (s *PostgresStorage) SetTransactionGateway(ctx context.Context, transactionID string, gateway string) error {
+	result, err := s.pool.Exec(
+		ctx,
+		`UPDATE transactions
+		 SET gateway_name = $2, updated_at = NOW()
+		 WHERE id = $1 AND status = 'pending'`,
+		transactionID,
+		gateway,
+	)
+	if err != nil {
+		return fmt.Errorf("error setting transaction gateway: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", internal.ErrTransactionNotPending, transactionID)
+	}
+
+	return nil
+}
+
+// SetPendingGatewayResponse records the reference, gateway and response code
+// the gateway gave a transaction it left pending, so it can be traced at the
+// gateway before it is settled. It returns internal.ErrTransactionNotPending
+// if the transaction was already finalised.
+func (s *PostgresStorage) SetPendingGatewayResponse(ctx context.Context, transactionID string, gatewayResponse internal.GatewayResponse) error {
+	result, err := s.pool.Exec(
+		ctx,
+		`UPDATE transactions
+		 SET gateway_reference = COALESCE(NULLIF($2, ''), gateway_reference),
+		     gateway_name = COALESCE(NULLIF($3, ''), gateway_name),
+		     gateway_response_code = COALESCE(NULLIF($4, ''), gateway_response_code),
+		     updated_at = NOW()
+		 WHERE id = $1 AND status = 'pending'`,
+		transactionID,
+		gatewayResponse.Reference,
+		gatewayResponse.Gateway,
+		gatewayResponse.ResponseCode,
+	)
+	if err != nil {
+		return fmt.Errorf("error setting pending gateway response: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", internal.ErrTransactionNotPending, transactionID)
+	}
+
+	return nil
+}
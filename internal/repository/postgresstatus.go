@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/jackc/pgx/v5"
+)
+
+// recordTransactionCreated starts the status history of a transaction just
+// inserted within tx, ties it to the idempotency key of the request and
+// publishes it to the outbox
+func recordTransactionCreated(ctx context.Context, tx pgx.Tx, transactionID string, status string) error {
+	if err := recordStatusChange(ctx, tx, transactionID, "", status, internal.StatusReasonCreated); err != nil {
+		return err
+	}
+
+	if err := recordIdempotencyResource(ctx, tx, transactionID); err != nil {
+		return err
+	}
+
+	return recordTransactionEvent(ctx, tx, internal.WebhookEventTransactionCreated, transactionID)
+}
+
+// transitionTransaction moves a transaction from one status to another,
+// storing the gateway's response when given. The update only applies while
+// the transaction is still in from, so concurrent transitions cannot
+// overwrite each other; the loser gets internal.ErrTransactionStatusChanged.
+// Transitions not allowed by the state machine fail with
+// internal.ErrInvalidStatusTransition.
+func transitionTransaction(ctx context.Context, tx pgx.Tx, transactionID string, from string, to string, reason string, gatewayResponse internal.GatewayResponse) error {
+	if err := internal.ValidateStatusTransition(from, to); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		ctx,
+		`UPDATE transactions
+		 SET status = $3,
+		     gateway_reference = COALESCE(NULLIF($4, ''), gateway_reference),
+		     gateway_name = COALESCE(NULLIF($5, ''), gateway_name),
+		     gateway_response_code = COALESCE(NULLIF($6, ''), gateway_response_code),
+		     updated_at = NOW()
+		 WHERE id = $1 AND status = $2`,
+		transactionID,
+		from,
+		to,
+		gatewayResponse.Reference,
+		gatewayResponse.Gateway,
+		gatewayResponse.ResponseCode,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating transaction status: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s is no longer %s", internal.ErrTransactionStatusChanged, transactionID, from)
+	}
+
+	if err := recordStatusChange(ctx, tx, transactionID, from, to, reason); err != nil {
+		return err
+	}
+
+	return recordTransactionEvent(ctx, tx, internal.WebhookEventTransactionUpdated, transactionID)
+}
+
+// recordStatusChange appends an entry to the status history of a transaction
+func recordStatusChange(ctx context.Context, tx pgx.Tx, transactionID string, from string, to string, reason string) error {
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO transaction_status_history (transaction_id, from_status, to_status, reason, created_at)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, NOW())`,
+		transactionID,
+		from,
+		to,
+		reason,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording transaction status change: %v", err)
+	}
+
+	return nil
+}
+
+// GetTransactionStatusHistory returns the status changes of a transaction,
+// oldest first
+func (s *PostgresStorage) GetTransactionStatusHistory(ctx context.Context, transactionID string) ([]internal.TransactionStatusChange, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT COALESCE(from_status, ''), to_status, reason, created_at
+		 FROM transaction_status_history
+		 WHERE transaction_id = $1
+		 ORDER BY id`,
+		transactionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying transaction status history: %v", err)
+	}
+	defer rows.Close()
+
+	history := []internal.TransactionStatusChange{}
+	for rows.Next() {
+		var change internal.TransactionStatusChange
+		if err := rows.Scan(&change.From, &change.To, &change.Reason, &change.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning transaction status history row: %v", err)
+		}
+		history = append(history, change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transaction status history rows: %v", err)
+	}
+
+	return history, nil
+}
@@ -2,12 +2,17 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -32,35 +37,135 @@ func NewPostgresStorage(connString string) (*PostgresStorage, error) {
 	return &PostgresStorage{pool: pool}, nil
 }
 
-// GetBalance retrieves the current balance for a user
-func (s *PostgresStorage) GetBalance(ctx context.Context, userID uint64) (float64, error) {
-	var balance float64
-	err := s.pool.QueryRow(
+// GetBalance retrieves the balance of each currency held by the user,
+// checking them against the postings of the user's ledger accounts. A user
+// without a wallet has a zero balance in the default currency.
+func (s *PostgresStorage) GetBalance(ctx context.Context, userID uint64) ([]internal.Balance, error) {
+	rows, err := s.pool.Query(
 		ctx,
-		"SELECT balance FROM user_balances WHERE user_id = $1",
+		`SELECT b.currency, b.balance, b.held_balance, COALESCE(SUM(p.amount), 0)
+		 FROM user_balances b
+		 LEFT JOIN ledger_accounts a ON a.code = 'wallet:' || b.user_id || ':' || b.currency
+		 LEFT JOIN ledger_postings p ON p.account_id = a.id
+		 WHERE b.user_id = $1
+		 GROUP BY b.currency, b.balance, b.held_balance
+		 ORDER BY b.currency`,
 		userID,
-	).Scan(&balance)
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting balances: %v", err)
+	}
+	defer rows.Close()
 
-	if err == pgx.ErrNoRows {
+	var balances []internal.Balance
+	for rows.Next() {
+		var currency string
+		var balance, held, ledgerBalance pgtype.Numeric
+		if err := rows.Scan(&currency, &balance, &held, &ledgerBalance); err != nil {
+			return nil, fmt.Errorf("error scanning balance row: %v", err)
+		}
+
+		money, err := moneyFromNumeric(balance, currency)
+		if err != nil {
+			return nil, fmt.Errorf("error reading balance: %v", err)
+		}
+
+		heldMoney, err := moneyFromNumeric(held, currency)
+		if err != nil {
+			return nil, fmt.Errorf("error reading held balance: %v", err)
+		}
+
+		ledgerMoney, err := moneyFromNumeric(ledgerBalance, currency)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ledger balance: %v", err)
+		}
+		if ledgerMoney != money {
+			log.Printf("Ledger mismatch for user %d: balance %s %s, ledger postings %s", userID, money, currency, ledgerMoney)
+		}
+
+		balances = append(balances, internal.Balance{
+			Currency:  currency,
+			Balance:   money,
+			Available: internal.NewMoney(money.MinorUnits-heldMoney.MinorUnits, currency),
+			Held:      heldMoney,
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating balance rows: %v", err)
+	}
+
+	if len(balances) == 0 {
 		// Return 0 if user has no balance record yet
-		return 0, nil
-	} else if err != nil {
-		return 0, fmt.Errorf("error getting balance: %v", err)
+		zero := internal.NewMoney(0, internal.DefaultCurrency)
+		balances = append(balances, internal.Balance{Currency: internal.DefaultCurrency, Balance: zero, Available: zero, Held: zero})
 	}
 
-	return balance, nil
+	return balances, nil
 }
 
-// GetTransactions retrieves transaction history for a user
-func (s *PostgresStorage) GetTransactions(ctx context.Context, userID uint64) ([]internal.Transaction, error) {
+// transactionColumns lists the columns read by scanTransaction, in order
+const transactionColumns = `id, user_id, amount, currency, transaction_type, status,
+	created_at, COALESCE(updated_at, created_at), COALESCE(method, ''),
+	COALESCE(gateway_reference, ''), COALESCE(gateway_name, ''), COALESCE(gateway_response_code, ''),
+	COALESCE(transfer_id::text, ''), COALESCE(original_transaction_id::text, ''), exchange_rate`
+
+// GetTransactions retrieves up to filter.Limit transactions of a user matching
+// filter, newest first. Ties on creation time are broken by ID so the order
+// is stable across pages.
+func (s *PostgresStorage) GetTransactions(ctx context.Context, userID uint64, filter internal.TransactionFilter) ([]internal.Transaction, error) {
+	conditions := []string{"user_id = $1"}
+	args := []any{userID}
+	where := func(condition string, values ...any) {
+		for _, value := range values {
+			args = append(args, value)
+			condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(args)), 1)
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if filter.Currency != "" {
+		where("currency = ?", filter.Currency)
+	}
+	if filter.Type != "" {
+		where("transaction_type = ?", filter.Type)
+	}
+	if filter.Status != "" {
+		where("status = ?", filter.Status)
+	}
+	if filter.MinAmount != nil {
+		amount, err := numericFromMoney(*filter.MinAmount)
+		if err != nil {
+			return nil, fmt.Errorf("error converting amount: %v", err)
+		}
+		where("amount >= ?", amount)
+	}
+	if filter.MaxAmount != nil {
+		amount, err := numericFromMoney(*filter.MaxAmount)
+		if err != nil {
+			return nil, fmt.Errorf("error converting amount: %v", err)
+		}
+		where("amount <= ?", amount)
+	}
+	if filter.From != nil {
+		where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at < ?", *filter.To)
+	}
+	if filter.After != nil {
+		where("(created_at, id) < (?, ?)", filter.After.CreatedAt, filter.After.ID)
+	}
+	args = append(args, filter.Limit)
+
 	rows, err := s.pool.Query(
 		ctx,
-		`SELECT id, user_id, amount, transaction_type, status, created_at 
-		 FROM transactions 
-		 WHERE user_id = $1 
-		 ORDER BY created_at DESC 
-		 LIMIT 100`,
-		userID,
+		`SELECT `+transactionColumns+`
+		 FROM transactions
+		 WHERE `+strings.Join(conditions, " AND ")+`
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT $`+strconv.Itoa(len(args)),
+		args...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error querying transactions: %v", err)
@@ -69,17 +174,9 @@ func (s *PostgresStorage) GetTransactions(ctx context.Context, userID uint64) ([
 
 	var transactions []internal.Transaction
 	for rows.Next() {
-		var t internal.Transaction
-		err := rows.Scan(
-			&t.ID,
-			&t.Amount,
-			&t.Type,
-			&t.Status,
-			&t.CreatedAt,
-		)
+		t, err := scanTransaction(rows)
 		if err != nil {
-			log.Printf("Error scanning transaction row: %v", err)
-			continue
+			return nil, fmt.Errorf("error scanning transaction row: %v", err)
 		}
 		transactions = append(transactions, t)
 	}
@@ -91,18 +188,152 @@ func (s *PostgresStorage) GetTransactions(ctx context.Context, userID uint64) ([
 	return transactions, nil
 }
 
+// GetTransaction returns a transaction of the user. Transactions of other
+// users are reported as not found.
+func (s *PostgresStorage) GetTransaction(ctx context.Context, userID uint64, transactionID string) (internal.Transaction, error) {
+	transaction, err := scanTransaction(s.pool.QueryRow(
+		ctx,
+		`SELECT `+transactionColumns+`
+		 FROM transactions
+		 WHERE id = $1 AND user_id = $2`,
+		transactionID,
+		userID,
+	))
+	if err == pgx.ErrNoRows {
+		return internal.Transaction{}, fmt.Errorf("%w: %s", internal.ErrTransactionNotFound, transactionID)
+	}
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error getting transaction: %v", err)
+	}
+
+	return transaction, nil
+}
+
+// GetTransactionByID returns a transaction of any user
+func (s *PostgresStorage) GetTransactionByID(ctx context.Context, transactionID string) (internal.Transaction, error) {
+	transaction, err := scanTransaction(s.pool.QueryRow(
+		ctx,
+		`SELECT `+transactionColumns+`
+		 FROM transactions
+		 WHERE id = $1`,
+		transactionID,
+	))
+	if err == pgx.ErrNoRows {
+		return internal.Transaction{}, fmt.Errorf("%w: %s", internal.ErrTransactionNotFound, transactionID)
+	}
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error getting transaction: %v", err)
+	}
+
+	return transaction, nil
+}
+
+// GetLinkedTransactions returns the transactions linked to transaction,
+// oldest first: its refunds, the payment it refunds and the other side of
+// its transfer
+func (s *PostgresStorage) GetLinkedTransactions(ctx context.Context, transaction internal.Transaction) ([]internal.Transaction, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT `+transactionColumns+`
+		 FROM transactions
+		 WHERE id <> $1
+		   AND (original_transaction_id = $1
+		        OR id = NULLIF($2, '')::uuid
+		        OR transfer_id = NULLIF($3, '')::uuid)
+		 ORDER BY created_at, id`,
+		transaction.ID,
+		transaction.OriginalTransactionID,
+		transaction.TransferID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying linked transactions: %v", err)
+	}
+	defer rows.Close()
+
+	transactions := []internal.Transaction{}
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning transaction row: %v", err)
+		}
+		transactions = append(transactions, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transaction rows: %v", err)
+	}
+
+	return transactions, nil
+}
+
+// scanTransaction reads a row selected with transactionColumns
+func scanTransaction(row pgx.Row) (internal.Transaction, error) {
+	var t internal.Transaction
+	var amount, exchangeRate pgtype.Numeric
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&amount,
+		&t.Currency,
+		&t.Type,
+		&t.Status,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.Method,
+		&t.GatewayReference,
+		&t.GatewayName,
+		&t.GatewayResponseCode,
+		&t.TransferID,
+		&t.OriginalTransactionID,
+		&exchangeRate,
+	)
+	if err != nil {
+		return internal.Transaction{}, err
+	}
+
+	t.Amount, err = moneyFromNumeric(amount, t.Currency)
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error reading transaction amount: %v", err)
+	}
+	t.ExchangeRate, err = rateFromNumeric(exchangeRate)
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("error reading exchange rate: %v", err)
+	}
+
+	return t, nil
+}
+
 // CreatePaymentRequest creates a new payment request
 func (s *PostgresStorage) CreatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error) {
+	amount, err := numericFromMoney(paymentRequest.Amount)
+	if err != nil {
+		return "", fmt.Errorf("error converting amount: %v", err)
+	}
+
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
 		return "", fmt.Errorf("error beginning transaction: %v", err)
 	}
-	defer func() {
-		err = tx.Rollback(ctx)
-		if err != nil {
-			log.Printf("Error in rollback transaction: %v", err)
-		}
-	}()
+	defer rollback(ctx, tx)
+
+	// Debit the user's balance only if its available part covers the amount.
+	// The row lock taken by the UPDATE serializes concurrent payments for the
+	// same wallet.
+	result, err := tx.Exec(
+		ctx,
+		`UPDATE user_balances
+		 SET balance = balance - $3, updated_at = NOW()
+		 WHERE user_id = $1 AND currency = $2 AND balance - held_balance >= $3`,
+		paymentRequest.UserID,
+		paymentRequest.Amount.Currency,
+		amount,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error updating balance: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return "", internal.ErrNotEnoughBalance
+	}
 
 	// Generate a new transaction ID
 	transactionID := uuid.New().String()
@@ -111,28 +342,25 @@ func (s *PostgresStorage) CreatePaymentRequest(ctx context.Context, paymentReque
 	_, err = tx.Exec(
 		ctx,
 		`INSERT INTO transactions 
-		 (id, user_id, amount, transaction_type, status, created_at)
-		 VALUES ($1, $2, $3, 'payment', 'pending', NOW())`,
+		 (id, user_id, amount, currency, transaction_type, status, method, created_at)
+		 VALUES ($1, $2, $3, $4, 'payment', 'pending', $5, NOW())`,
 		transactionID,
 		paymentRequest.UserID,
-		paymentRequest.Amount,
+		amount,
+		paymentRequest.Amount.Currency,
+		paymentRequest.Method,
 	)
 	if err != nil {
 		return "", fmt.Errorf("error creating transaction: %v", err)
 	}
 
-	// Update the user's balance
-	_, err = tx.Exec(
-		ctx,
-		`INSERT INTO user_balances (user_id, balance)
-		 VALUES ($1, $2)
-		 ON CONFLICT (user_id) 
-		 DO UPDATE SET balance = user_balances.balance - $2`,
-		paymentRequest.UserID,
-		paymentRequest.Amount,
-	)
+	err = postJournalEntry(ctx, tx, internal.NewPaymentEntry(transactionID, paymentRequest.UserID, paymentRequest.Amount))
 	if err != nil {
-		return "", fmt.Errorf("error updating balance: %v", err)
+		return "", fmt.Errorf("error posting payment to ledger: %v", err)
+	}
+
+	if err := recordTransactionCreated(ctx, tx, transactionID, internal.PaymentStatusPending); err != nil {
+		return "", err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -142,32 +370,223 @@ func (s *PostgresStorage) CreatePaymentRequest(ctx context.Context, paymentReque
 	return transactionID, nil
 }
 
-// UpdatePaymentRequest updates the status of a payment request
-func (s *PostgresStorage) UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string) error {
-	_, err := s.pool.Exec(
-		ctx,
-		"UPDATE transactions SET status = $1, updated_at = NOW() WHERE id = $2",
-		status,
-		transactionID,
-	)
+// UpdatePaymentRequest sets the final status of a pending payment request,
+// refunding the balance when it failed. It returns internal.ErrTransactionNotPending
+// if the payment was already finalised.
+func (s *PostgresStorage) UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	amount, err := numericFromMoney(paymentRequest.Amount)
 	if err != nil {
-		return fmt.Errorf("error updating payment request: %v", err)
+		return fmt.Errorf("error converting amount: %v", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	if err := finalizeTransaction(ctx, tx, transactionID, status, gatewayResponse); err != nil {
+		return err
 	}
 
 	if status == internal.PaymentStatusFailed {
-		_, err = s.pool.Exec(
+		_, err = tx.Exec(
 			ctx,
-			`INSERT INTO user_balances (user_id, balance)
-			 VALUES ($1, $2)
-			 ON CONFLICT (user_id) 
-			 DO UPDATE SET balance = user_balances.balance + $2`,
+			`INSERT INTO user_balances (user_id, currency, balance)
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT (user_id, currency)
+			 DO UPDATE SET balance = user_balances.balance + $3`,
 			paymentRequest.UserID,
-			paymentRequest.Amount,
+			paymentRequest.Amount.Currency,
+			amount,
 		)
 		if err != nil {
 			return fmt.Errorf("error updating balance: %v", err)
 		}
+
+		entry := internal.NewPaymentEntry(transactionID, paymentRequest.UserID, paymentRequest.Amount).Reversed("payment reversal")
+		if err := postJournalEntry(ctx, tx, entry); err != nil {
+			return fmt.Errorf("error posting payment reversal to ledger: %v", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// GetStalePendingTransactions retrieves payments, deposits and refunds that
+// are still pending after staleAfter
+func (s *PostgresStorage) GetStalePendingTransactions(ctx context.Context, staleAfter time.Duration, limit int) ([]internal.Transaction, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT `+transactionColumns+`
+		 FROM transactions
+		 WHERE transaction_type IN ('payment', 'deposit', 'refund')
+		   AND status = 'pending'
+		   AND created_at < NOW() - $1 * INTERVAL '1 second'
+		 ORDER BY created_at
+		 LIMIT $2`,
+		staleAfter.Seconds(),
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying stale transactions: %v", err)
+	}
+	defer rows.Close()
+
+	var transactions []internal.Transaction
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning transaction row: %v", err)
+		}
+		transactions = append(transactions, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transaction rows: %v", err)
+	}
+
+	return transactions, nil
+}
+
+// CreateIdempotencyKey reserves an idempotency key for a user. When the key
+// already exists the stored record is returned and created is false, unless
+// it is still in progress for the same request, was locked more than lease
+// ago, e.g. because the process died mid-request, and the request created no
+// transaction or hold yet. The key is then locked again and taken over by the
+// caller.
+func (s *PostgresStorage) CreateIdempotencyKey(ctx context.Context, userID uint64, key string, requestHash string, lease time.Duration) (internal.IdempotencyKey, bool, error) {
+	result, err := s.pool.Exec(
+		ctx,
+		`INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, created_at, locked_at)
+		 VALUES ($1, $2, $3, NOW(), NOW())
+		 ON CONFLICT (user_id, idempotency_key) DO UPDATE
+		 SET locked_at = NOW()
+		 WHERE idempotency_keys.completed_at IS NULL
+		   AND idempotency_keys.request_hash = EXCLUDED.request_hash
+		   AND idempotency_keys.locked_at < NOW() - $4 * INTERVAL '1 second'
+		   AND idempotency_keys.resource_id IS NULL`,
+		userID,
+		key,
+		requestHash,
+		lease.Seconds(),
+	)
+	if err != nil {
+		return internal.IdempotencyKey{}, false, fmt.Errorf("error creating idempotency key: %v", err)
+	}
+	if result.RowsAffected() == 1 {
+		return internal.IdempotencyKey{UserID: userID, Key: key, RequestHash: requestHash}, true, nil
+	}
+
+	idempotencyKey := internal.IdempotencyKey{UserID: userID, Key: key}
+	var statusCode *int
+	err = s.pool.QueryRow(
+		ctx,
+		`SELECT request_hash, status_code, response_body, completed_at IS NOT NULL, COALESCE(resource_id::text, '')
+		 FROM idempotency_keys
+		 WHERE user_id = $1 AND idempotency_key = $2`,
+		userID,
+		key,
+	).Scan(
+		&idempotencyKey.RequestHash,
+		&statusCode,
+		&idempotencyKey.ResponseBody,
+		&idempotencyKey.Completed,
+		&idempotencyKey.ResourceID,
+	)
+	if err != nil {
+		return internal.IdempotencyKey{}, false, fmt.Errorf("error getting idempotency key: %v", err)
+	}
+	if statusCode != nil {
+		idempotencyKey.StatusCode = *statusCode
+	}
+
+	return idempotencyKey, false, nil
+}
+
+// CompleteIdempotencyKey stores the response sent for an idempotency key
+func (s *PostgresStorage) CompleteIdempotencyKey(ctx context.Context, userID uint64, key string, statusCode int, responseBody []byte) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`UPDATE idempotency_keys
+		 SET status_code = $3, response_body = $4, completed_at = NOW()
+		 WHERE user_id = $1 AND idempotency_key = $2`,
+		userID,
+		key,
+		statusCode,
+		responseBody,
+	)
+	if err != nil {
+		return fmt.Errorf("error completing idempotency key: %v", err)
 	}
 
 	return nil
 }
+
+// ReleaseIdempotencyKey deletes a key still in progress so the request can be
+// retried with it
+func (s *PostgresStorage) ReleaseIdempotencyKey(ctx context.Context, userID uint64, key string) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`DELETE FROM idempotency_keys
+		 WHERE user_id = $1 AND idempotency_key = $2 AND completed_at IS NULL`,
+		userID,
+		key,
+	)
+	if err != nil {
+		return fmt.Errorf("error releasing idempotency key: %v", err)
+	}
+
+	return nil
+}
+
+// recordIdempotencyResource ties the idempotency key of the request in ctx, if
+// it has one, to the first transaction or hold it creates within tx
+func recordIdempotencyResource(ctx context.Context, tx pgx.Tx, resourceID string) error {
+	userID, key, ok := internal.IdempotencyKeyFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	_, err := tx.Exec(
+		ctx,
+		`UPDATE idempotency_keys
+		 SET resource_id = COALESCE(resource_id, $3)
+		 WHERE user_id = $1 AND idempotency_key = $2`,
+		userID,
+		key,
+		resourceID,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording idempotency key resource: %v", err)
+	}
+
+	return nil
+}
+
+// finalizeTransaction moves a pending transaction to its final status together
+// with the gateway's response, recording the webhook event in ctx that
+// notified it, if any. It returns internal.ErrTransactionNotPending if the
+// transaction was already finalised.
+func finalizeTransaction(ctx context.Context, tx pgx.Tx, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	err := transitionTransaction(ctx, tx, transactionID, internal.PaymentStatusPending, status, internal.StatusReasonGatewayResponse, gatewayResponse)
+	if errors.Is(err, internal.ErrTransactionStatusChanged) {
+		return internal.ErrTransactionNotPending
+	}
+	if err != nil {
+		return err
+	}
+
+	return recordWebhookEvent(ctx, tx)
+}
+
+// rollback aborts tx unless it was already committed
+func rollback(ctx context.Context, tx pgx.Tx) {
+	if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+		log.Printf("Error in rollback transaction: %v", err)
+	}
+}
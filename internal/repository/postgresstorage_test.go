@@ -0,0 +1,655 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStorage connects to the database in TEST_DATABASE_URL, which must
+// already have migrations/local/init_db.sql applied. Tests are skipped when it
+// is not set.
+func newTestStorage(t *testing.T) (*repository.PostgresStorage, *pgxpool.Pool) {
+	t.Helper()
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping postgres tests")
+	}
+
+	storage, err := repository.NewPostgresStorage(connString)
+	require.NoError(t, err)
+
+	pool, err := pgxpool.New(context.Background(), connString)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	return storage, pool
+}
+
+// newTestWallet creates a wallet with the given balance for a random user
+func newTestWallet(t *testing.T, pool *pgxpool.Pool, balance string) uint64 {
+	t.Helper()
+	ctx := context.Background()
+
+	userID := rand.Uint64N(1<<62) + 1
+	_, err := pool.Exec(ctx, "INSERT INTO user_balances (user_id, balance) VALUES ($1, $2)", userID, balance)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := pool.Exec(ctx, "DELETE FROM user_balances WHERE user_id = $1", userID)
+		assert.NoError(t, err)
+	})
+
+	return userID
+}
+
+// balanceIn returns the user's balance in currency
+func balanceIn(t *testing.T, storage *repository.PostgresStorage, userID uint64, currency string) internal.Balance {
+	t.Helper()
+
+	balances, err := storage.GetBalance(context.Background(), userID)
+	require.NoError(t, err)
+	for _, balance := range balances {
+		if balance.Currency == currency {
+			return balance
+		}
+	}
+	require.Failf(t, "balance not found", "user %d has no %s balance", userID, currency)
+	return internal.Balance{}
+}
+
+func TestPostgresStorage_CreatePaymentRequest_ConcurrentPaymentsNeverOverdraw(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+
+	const payments = 50
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		succeeded  int
+		overdrafts int
+	)
+	for range payments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := storage.CreatePaymentRequest(ctx, internal.PaymentRequest{
+				UserID: userID,
+				Method: "account",
+				Amount: internal.NewMoney(1000, internal.DefaultCurrency),
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case assert.ErrorIs(t, err, internal.ErrNotEnoughBalance):
+				overdrafts++
+			}
+		}()
+	}
+	wg.Wait()
+
+	balance := balanceIn(t, storage, userID, internal.DefaultCurrency)
+	assert.Equal(t, 10, succeeded)
+	assert.Equal(t, payments-10, overdrafts)
+	assert.Equal(t, internal.NewMoney(0, internal.DefaultCurrency), balance.Balance)
+}
+
+func TestPostgresStorage_PaymentsDebitOnlyTheirCurrency(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+
+	deposit := internal.DepositRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(5000, "EUR"),
+	}
+	depositID, err := storage.CreateDepositRequest(ctx, deposit)
+	require.NoError(t, err)
+	err = storage.UpdateDepositRequest(ctx, deposit, depositID, internal.PaymentStatusSuccess, internal.GatewayResponse{Gateway: "mock"})
+	require.NoError(t, err)
+
+	// More euros than the wallet holds, even though the dollar balance covers it
+	_, err = storage.CreatePaymentRequest(ctx, internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(6000, "EUR"),
+	})
+	assert.ErrorIs(t, err, internal.ErrNotEnoughBalance)
+
+	_, err = storage.CreatePaymentRequest(ctx, internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(100, "JPY"),
+	})
+	assert.ErrorIs(t, err, internal.ErrNotEnoughBalance)
+
+	paymentID, err := storage.CreatePaymentRequest(ctx, internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(2000, "EUR"),
+	})
+	require.NoError(t, err)
+
+	balances, err := storage.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, balances, 2)
+	assert.Equal(t, "EUR", balances[0].Currency)
+	assert.Equal(t, internal.NewMoney(3000, "EUR"), balances[0].Balance)
+	assert.Equal(t, "USD", balances[1].Currency)
+	assert.Equal(t, internal.NewMoney(10000, "USD"), balances[1].Balance)
+
+	payment, err := storage.GetTransaction(ctx, userID, paymentID)
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", payment.Currency)
+	assert.Equal(t, internal.NewMoney(2000, "EUR"), payment.Amount)
+}
+
+func TestPostgresStorage_CreateTransfer_OppositeTransfersDoNotDeadlock(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	alice := newTestWallet(t, pool, "100.00")
+	bob := newTestWallet(t, pool, "100.00")
+
+	const transfers = 20
+	var wg sync.WaitGroup
+	for i := range transfers {
+		from, to := alice, bob
+		if i%2 == 1 {
+			from, to = bob, alice
+		}
+		wg.Go(func() {
+			_, err := storage.CreateTransfer(ctx, internal.TransferRequest{
+				FromUserID: from,
+				ToUserID:   to,
+				Amount:     internal.NewMoney(500, internal.DefaultCurrency),
+			})
+			assert.NoError(t, err)
+		})
+	}
+	wg.Wait()
+
+	aliceBalance := balanceIn(t, storage, alice, internal.DefaultCurrency)
+	bobBalance := balanceIn(t, storage, bob, internal.DefaultCurrency)
+	assert.Equal(t, internal.NewMoney(10000, internal.DefaultCurrency), aliceBalance.Balance)
+	assert.Equal(t, internal.NewMoney(10000, internal.DefaultCurrency), bobBalance.Balance)
+}
+
+func TestPostgresStorage_CreateRefundRequest_ConcurrentRefundsNeverExceedPayment(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+
+	paymentID, err := storage.CreatePaymentRequest(ctx, internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(5000, internal.DefaultCurrency),
+	})
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "UPDATE transactions SET status = 'success' WHERE id = $1", paymentID)
+	require.NoError(t, err)
+
+	const refunds = 20
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for range refunds {
+		wg.Go(func() {
+			_, err := storage.CreateRefundRequest(ctx, internal.RefundRequest{
+				UserID:        userID,
+				TransactionID: paymentID,
+				Amount:        internal.NewMoney(1000, internal.DefaultCurrency),
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				succeeded++
+			} else {
+				assert.ErrorIs(t, err, internal.ErrRefundExceedsPayment)
+			}
+		})
+	}
+	wg.Wait()
+
+	assert.Equal(t, 5, succeeded)
+}
+
+func TestPostgresStorage_RefundsKeepTheGatewayOfThePayment(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+
+	paymentRequest := internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(5000, internal.DefaultCurrency),
+	}
+	paymentID, err := storage.CreatePaymentRequest(ctx, paymentRequest)
+	require.NoError(t, err)
+	require.NoError(t, storage.SetTransactionGateway(ctx, paymentID, "acquirer_b"))
+	require.NoError(t, storage.UpdatePaymentRequest(ctx, paymentRequest, paymentID, internal.PaymentStatusSuccess, internal.GatewayResponse{Reference: "gateway-123"}))
+
+	err = storage.SetTransactionGateway(ctx, paymentID, "stub")
+	assert.ErrorIs(t, err, internal.ErrTransactionNotPending)
+
+	refund, err := storage.CreateRefundRequest(ctx, internal.RefundRequest{UserID: userID, TransactionID: paymentID})
+	require.NoError(t, err)
+	assert.Equal(t, "acquirer_b", refund.GatewayName)
+
+	stored, err := storage.GetTransactionByID(ctx, refund.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "acquirer_b", stored.GatewayName)
+}
+
+func TestPostgresStorage_CreateHold_LowersAvailableBalanceOnly(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+
+	hold, err := storage.CreateHold(ctx, internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(6000, internal.DefaultCurrency),
+	}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	balance := balanceIn(t, storage, userID, internal.DefaultCurrency)
+	assert.Equal(t, internal.NewMoney(10000, internal.DefaultCurrency), balance.Balance)
+	assert.Equal(t, internal.NewMoney(4000, internal.DefaultCurrency), balance.Available)
+	assert.Equal(t, internal.NewMoney(6000, internal.DefaultCurrency), balance.Held)
+
+	// Held funds cannot be spent by a regular payment
+	_, err = storage.CreatePaymentRequest(ctx, internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(5000, internal.DefaultCurrency),
+	})
+	assert.ErrorIs(t, err, internal.ErrNotEnoughBalance)
+
+	captured, err := storage.CaptureHold(ctx, internal.CaptureRequest{
+		UserID: userID,
+		HoldID: hold.ID,
+		Amount: internal.NewMoney(2500, internal.DefaultCurrency),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, internal.HoldStatusCaptured, captured.Status)
+	assert.NotEmpty(t, captured.TransactionID)
+
+	balance = balanceIn(t, storage, userID, internal.DefaultCurrency)
+	assert.Equal(t, internal.NewMoney(7500, internal.DefaultCurrency), balance.Balance)
+	assert.Equal(t, internal.NewMoney(7500, internal.DefaultCurrency), balance.Available)
+	assert.Equal(t, internal.NewMoney(0, internal.DefaultCurrency), balance.Held)
+
+	_, err = storage.VoidHold(ctx, userID, hold.ID)
+	assert.ErrorIs(t, err, internal.ErrHoldNotActive)
+
+	rows, err := pool.Query(ctx,
+		`SELECT COALESCE(from_status, ''), to_status, reason
+		 FROM hold_status_history
+		 WHERE hold_id = $1
+		 ORDER BY id`,
+		hold.ID,
+	)
+	require.NoError(t, err)
+	var history []internal.TransactionStatusChange
+	for rows.Next() {
+		var change internal.TransactionStatusChange
+		require.NoError(t, rows.Scan(&change.From, &change.To, &change.Reason))
+		history = append(history, change)
+	}
+	require.NoError(t, rows.Err())
+
+	assert.Equal(t, []internal.TransactionStatusChange{
+		{To: internal.HoldStatusActive, Reason: internal.StatusReasonCreated},
+		{From: internal.HoldStatusActive, To: internal.HoldStatusCaptured, Reason: internal.StatusReasonCapture},
+	}, history)
+}
+
+func TestPostgresStorage_PaymentStatusTransitions(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+	payment := internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(5000, internal.DefaultCurrency),
+	}
+
+	paymentID, err := storage.CreatePaymentRequest(ctx, payment)
+	require.NoError(t, err)
+	err = storage.UpdatePaymentRequest(ctx, payment, paymentID, internal.PaymentStatusSuccess, internal.GatewayResponse{Gateway: "mock"})
+	require.NoError(t, err)
+
+	// A late failure cannot overwrite the final status
+	err = storage.UpdatePaymentRequest(ctx, payment, paymentID, internal.PaymentStatusFailed, internal.GatewayResponse{Gateway: "mock"})
+	assert.ErrorIs(t, err, internal.ErrTransactionNotPending)
+
+	refund := func(minorUnits int64) {
+		refundRequest := internal.RefundRequest{
+			UserID:        userID,
+			TransactionID: paymentID,
+			Amount:        internal.NewMoney(minorUnits, internal.DefaultCurrency),
+		}
+		created, err := storage.CreateRefundRequest(ctx, refundRequest)
+		require.NoError(t, err)
+		err = storage.UpdateRefundRequest(ctx, refundRequest, created.ID, internal.PaymentStatusSuccess, internal.GatewayResponse{Gateway: "mock"})
+		require.NoError(t, err)
+	}
+
+	refund(2000)
+	transaction, err := storage.GetTransaction(ctx, userID, paymentID)
+	require.NoError(t, err)
+	assert.Equal(t, internal.PaymentStatusPartiallyRefunded, transaction.Status)
+
+	refund(3000)
+	transaction, err = storage.GetTransaction(ctx, userID, paymentID)
+	require.NoError(t, err)
+	assert.Equal(t, internal.PaymentStatusRefunded, transaction.Status)
+
+	rows, err := pool.Query(ctx,
+		`SELECT COALESCE(from_status, ''), to_status, reason
+		 FROM transaction_status_history
+		 WHERE transaction_id = $1
+		 ORDER BY id`,
+		paymentID,
+	)
+	require.NoError(t, err)
+	var history []internal.TransactionStatusChange
+	for rows.Next() {
+		var change internal.TransactionStatusChange
+		require.NoError(t, rows.Scan(&change.From, &change.To, &change.Reason))
+		history = append(history, change)
+	}
+	require.NoError(t, rows.Err())
+
+	assert.Equal(t, []internal.TransactionStatusChange{
+		{To: internal.PaymentStatusPending, Reason: internal.StatusReasonCreated},
+		{From: internal.PaymentStatusPending, To: internal.PaymentStatusSuccess, Reason: internal.StatusReasonGatewayResponse},
+		{From: internal.PaymentStatusSuccess, To: internal.PaymentStatusPartiallyRefunded, Reason: internal.StatusReasonRefund},
+		{From: internal.PaymentStatusPartiallyRefunded, To: internal.PaymentStatusRefunded, Reason: internal.StatusReasonRefund},
+	}, history)
+}
+
+func TestPostgresStorage_TransactionDetail(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+	otherUserID := newTestWallet(t, pool, "0.00")
+	payment := internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(5000, internal.DefaultCurrency),
+	}
+
+	paymentID, err := storage.CreatePaymentRequest(ctx, payment)
+	require.NoError(t, err)
+	err = storage.UpdatePaymentRequest(ctx, payment, paymentID, internal.PaymentStatusSuccess, internal.GatewayResponse{Gateway: "mock", Reference: "ref-123"})
+	require.NoError(t, err)
+	refund, err := storage.CreateRefundRequest(ctx, internal.RefundRequest{
+		UserID:        userID,
+		TransactionID: paymentID,
+		Amount:        internal.NewMoney(2000, internal.DefaultCurrency),
+	})
+	require.NoError(t, err)
+
+	transaction, err := storage.GetTransaction(ctx, userID, paymentID)
+	require.NoError(t, err)
+	assert.Equal(t, "card", transaction.Method)
+	assert.Equal(t, "ref-123", transaction.GatewayReference)
+	assert.False(t, transaction.UpdatedAt.Before(transaction.CreatedAt))
+
+	_, err = storage.GetTransaction(ctx, otherUserID, paymentID)
+	assert.ErrorIs(t, err, internal.ErrTransactionNotFound)
+
+	history, err := storage.GetTransactionStatusHistory(ctx, paymentID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, internal.PaymentStatusSuccess, history[1].To)
+
+	linked, err := storage.GetLinkedTransactions(ctx, transaction)
+	require.NoError(t, err)
+	require.Len(t, linked, 1)
+	assert.Equal(t, refund.ID, linked[0].ID)
+	assert.Equal(t, "card", linked[0].Method)
+
+	linked, err = storage.GetLinkedTransactions(ctx, refund)
+	require.NoError(t, err)
+	require.Len(t, linked, 1)
+	assert.Equal(t, paymentID, linked[0].ID)
+
+	transfer, err := storage.CreateTransfer(ctx, internal.TransferRequest{
+		FromUserID: userID,
+		ToUserID:   otherUserID,
+		Amount:     internal.NewMoney(1000, internal.DefaultCurrency),
+	})
+	require.NoError(t, err)
+	incoming, err := storage.GetTransaction(ctx, otherUserID, transfer.IncomingTransactionID)
+	require.NoError(t, err)
+	linked, err = storage.GetLinkedTransactions(ctx, incoming)
+	require.NoError(t, err)
+	require.Len(t, linked, 1)
+	assert.Equal(t, transfer.OutgoingTransactionID, linked[0].ID)
+}
+
+func TestPostgresStorage_GetTransactions_KeysetPagination(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+
+	// Transactions created in the same instant are ordered by ID
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	for i := range 5 {
+		transactionType := internal.TransactionTypeDeposit
+		if i%2 == 0 {
+			transactionType = internal.TransactionTypePayment
+		}
+		_, err := pool.Exec(ctx,
+			`INSERT INTO transactions (id, user_id, amount, transaction_type, status, created_at)
+			 VALUES (gen_random_uuid(), $1, $2, $3, 'success', $4)`,
+			userID, 10+i, transactionType, createdAt,
+		)
+		require.NoError(t, err)
+	}
+
+	var (
+		seen  []string
+		after *internal.TransactionCursor
+	)
+	for {
+		page, err := storage.GetTransactions(ctx, userID, internal.TransactionFilter{After: after, Limit: 2})
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		for _, transaction := range page {
+			seen = append(seen, transaction.ID)
+		}
+		cursor := internal.CursorAfter(page[len(page)-1])
+		after = &cursor
+	}
+	assert.Len(t, seen, 5)
+	assert.IsDecreasing(t, seen)
+
+	minAmount := internal.NewMoney(1100, internal.DefaultCurrency)
+	payments, err := storage.GetTransactions(ctx, userID, internal.TransactionFilter{
+		Type:      internal.TransactionTypePayment,
+		MinAmount: &minAmount,
+		Limit:     10,
+	})
+	require.NoError(t, err)
+	assert.Len(t, payments, 2)
+}
+
+func TestPostgresStorage_ExecuteFXQuote(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "100.00")
+
+	rate, err := storage.GetExchangeRate(ctx, "USD", "EUR")
+	require.NoError(t, err)
+	inverse, err := storage.GetExchangeRate(ctx, "EUR", "USD")
+	require.NoError(t, err)
+	// Only USD to EUR is listed, the other way round uses its inverse
+	assert.InDelta(t, 1e16/float64(rate), float64(inverse), 1)
+	_, err = storage.GetExchangeRate(ctx, "USD", "XOF")
+	assert.ErrorIs(t, err, internal.ErrRateNotFound)
+
+	newQuote := func(sell internal.Money, expiresAt time.Time) internal.FXQuote {
+		buy, err := rate.Convert(sell, "EUR")
+		require.NoError(t, err)
+		quote, err := storage.CreateFXQuote(ctx, internal.FXQuote{
+			UserID:       userID,
+			SellAmount:   sell,
+			SellCurrency: sell.Currency,
+			BuyAmount:    buy,
+			BuyCurrency:  buy.Currency,
+			Rate:         rate,
+			ExpiresAt:    expiresAt,
+		})
+		require.NoError(t, err)
+		return quote
+	}
+
+	quote := newQuote(internal.NewMoney(4000, "USD"), time.Now().Add(time.Minute))
+	executed, err := storage.ExecuteFXQuote(ctx, userID, quote.ID)
+	require.NoError(t, err)
+	require.NotNil(t, executed.ExecutedAt)
+	assert.Equal(t, internal.NewMoney(6000, "USD"), balanceIn(t, storage, userID, "USD").Balance)
+	assert.Equal(t, quote.BuyAmount, balanceIn(t, storage, userID, "EUR").Balance)
+
+	_, err = storage.ExecuteFXQuote(ctx, userID, quote.ID)
+	assert.ErrorIs(t, err, internal.ErrQuoteNotActive)
+
+	_, err = storage.ExecuteFXQuote(ctx, newTestWallet(t, pool, "100.00"), quote.ID)
+	assert.ErrorIs(t, err, internal.ErrQuoteNotFound)
+
+	expired := newQuote(internal.NewMoney(1000, "USD"), time.Now().Add(-time.Second))
+	_, err = storage.ExecuteFXQuote(ctx, userID, expired.ID)
+	assert.ErrorIs(t, err, internal.ErrQuoteNotActive)
+
+	tooLarge := newQuote(internal.NewMoney(7000, "USD"), time.Now().Add(time.Minute))
+	_, err = storage.ExecuteFXQuote(ctx, userID, tooLarge.ID)
+	assert.ErrorIs(t, err, internal.ErrNotEnoughBalance)
+
+	outgoing, err := storage.GetTransaction(ctx, userID, executed.OutgoingTransactionID)
+	require.NoError(t, err)
+	assert.Equal(t, internal.TransactionTypeFXOut, outgoing.Type)
+	assert.Equal(t, rate, outgoing.ExchangeRate)
+	assert.Equal(t, quote.ID, outgoing.TransferID)
+
+	linked, err := storage.GetLinkedTransactions(ctx, outgoing)
+	require.NoError(t, err)
+	require.Len(t, linked, 1)
+	assert.Equal(t, executed.IncomingTransactionID, linked[0].ID)
+	assert.Equal(t, internal.TransactionTypeFXIn, linked[0].Type)
+	assert.Equal(t, quote.BuyAmount, linked[0].Amount)
+}
+
+func TestPostgresStorage_VaultedCardsOnlyResolveForTheirUser(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "0.00")
+
+	created, err := storage.CreateVaultedCard(ctx, internal.VaultedCard{
+		CardToken: internal.CardToken{
+			Token:       fmt.Sprintf("tok_%d", userID),
+			UserID:      userID,
+			Last4:       "4242",
+			ExpiryMonth: 12,
+			ExpiryYear:  2030,
+		},
+		EncryptedCard: []byte{0x01, 0x02, 0x03},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := pool.Exec(ctx, "DELETE FROM card_vault WHERE token = $1", created.Token)
+		assert.NoError(t, err)
+	})
+	assert.False(t, created.CreatedAt.IsZero())
+
+	stored, err := storage.GetVaultedCard(ctx, userID, created.Token)
+	require.NoError(t, err)
+	assert.Equal(t, created.EncryptedCard, stored.EncryptedCard)
+	assert.Equal(t, "4242", stored.Last4)
+
+	_, err = storage.GetVaultedCard(ctx, userID+1, created.Token)
+	assert.ErrorIs(t, err, internal.ErrCardNotFound)
+}
+
+func TestPostgresStorage_IdempotencyKeyLease(t *testing.T) {
+	storage, pool := newTestStorage(t)
+	ctx := context.Background()
+	userID := newTestWallet(t, pool, "10.00")
+	t.Cleanup(func() {
+		_, err := pool.Exec(ctx, "DELETE FROM idempotency_keys WHERE user_id = $1", userID)
+		assert.NoError(t, err)
+	})
+
+	_, created, err := storage.CreateIdempotencyKey(ctx, userID, "key-1", "hash-1", time.Minute)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	// Still locked by the first request
+	_, created, err = storage.CreateIdempotencyKey(ctx, userID, "key-1", "hash-1", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	// Once the lease expires a retry of the same request takes the key over,
+	// a different request still cannot
+	_, err = pool.Exec(ctx, "UPDATE idempotency_keys SET locked_at = NOW() - INTERVAL '2 minutes' WHERE user_id = $1", userID)
+	require.NoError(t, err)
+	_, created, err = storage.CreateIdempotencyKey(ctx, userID, "key-1", "hash-2", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, created)
+	_, created, err = storage.CreateIdempotencyKey(ctx, userID, "key-1", "hash-1", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	// A released key can be used again right away
+	require.NoError(t, storage.ReleaseIdempotencyKey(ctx, userID, "key-1"))
+	_, created, err = storage.CreateIdempotencyKey(ctx, userID, "key-1", "hash-2", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	// Keys of requests that created a transaction are never taken over
+	_, err = storage.CreatePaymentRequest(internal.WithIdempotencyKey(ctx, userID, "key-1"), internal.PaymentRequest{
+		UserID: userID,
+		Method: "card",
+		Amount: internal.NewMoney(1000, "USD"),
+	})
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "UPDATE idempotency_keys SET locked_at = NOW() - INTERVAL '2 minutes' WHERE user_id = $1", userID)
+	require.NoError(t, err)
+	stored, created, err := storage.CreateIdempotencyKey(ctx, userID, "key-1", "hash-2", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.NotEmpty(t, stored.ResourceID)
+
+	// Completed keys are neither taken over nor released
+	require.NoError(t, storage.CompleteIdempotencyKey(ctx, userID, "key-1", 200, []byte(`{}`)))
+	_, err = pool.Exec(ctx, "UPDATE idempotency_keys SET locked_at = NOW() - INTERVAL '2 minutes' WHERE user_id = $1", userID)
+	require.NoError(t, err)
+	require.NoError(t, storage.ReleaseIdempotencyKey(ctx, userID, "key-1"))
+	stored, created, err = storage.CreateIdempotencyKey(ctx, userID, "key-1", "hash-2", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.True(t, stored.Completed)
+	assert.Equal(t, 200, stored.StatusCode)
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// CreateTransfer debits the sender and credits the recipient in a single
+// transaction, recording a transfer_out and a transfer_in row linked by the
+// transfer ID. The recipient's balance in the transfer currency is opened if
+// needed. It returns internal.ErrWalletNotFound if either wallet does not
+// exist and internal.ErrNotEnoughBalance if the sender cannot cover it.
+func (s *PostgresStorage) CreateTransfer(ctx context.Context, transferRequest internal.TransferRequest) (internal.Transfer, error) {
+	amount, err := numericFromMoney(transferRequest.Amount)
+	if err != nil {
+		return internal.Transfer{}, fmt.Errorf("error converting amount: %v", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return internal.Transfer{}, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer rollback(ctx, tx)
+
+	// Lock both wallets in user ID order so two users sending money to each
+	// other at the same time cannot deadlock
+	first, second := transferRequest.FromUserID, transferRequest.ToUserID
+	if second < first {
+		first, second = second, first
+	}
+	currency := transferRequest.Amount.Currency
+	balances := make(map[uint64]pgtype.Numeric, 2)
+	for _, userID := range []uint64{first, second} {
+		balance, err := lockBalance(ctx, tx, userID, currency)
+		if err != nil {
+			return internal.Transfer{}, err
+		}
+		balances[userID] = balance
+	}
+
+	senderBalance, err := moneyFromNumeric(balances[transferRequest.FromUserID], currency)
+	if err != nil {
+		return internal.Transfer{}, fmt.Errorf("error reading balance: %v", err)
+	}
+	if senderBalance.LessThan(transferRequest.Amount) {
+		return internal.Transfer{}, internal.ErrNotEnoughBalance
+	}
+
+	transfer := internal.Transfer{
+		ID:                    uuid.New().String(),
+		OutgoingTransactionID: uuid.New().String(),
+		IncomingTransactionID: uuid.New().String(),
+	}
+
+	sides := []struct {
+		transactionID   string
+		userID          uint64
+		transactionType string
+		delta           string
+	}{
+		{transfer.OutgoingTransactionID, transferRequest.FromUserID, internal.TransactionTypeTransferOut, "balance - $3"},
+		{transfer.IncomingTransactionID, transferRequest.ToUserID, internal.TransactionTypeTransferIn, "balance + $3"},
+	}
+	for _, side := range sides {
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE user_balances
+			 SET balance = `+side.delta+`, updated_at = NOW()
+			 WHERE user_id = $1 AND currency = $2`,
+			side.userID,
+			currency,
+			amount,
+		)
+		if err != nil {
+			return internal.Transfer{}, fmt.Errorf("error updating balance: %v", err)
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO transactions
+			 (id, user_id, amount, currency, transaction_type, status, transfer_id, created_at)
+			 VALUES ($1, $2, $3, $4, $5, 'success', $6, NOW())`,
+			side.transactionID,
+			side.userID,
+			amount,
+			currency,
+			side.transactionType,
+			transfer.ID,
+		)
+		if err != nil {
+			return internal.Transfer{}, fmt.Errorf("error creating transaction: %v", err)
+		}
+
+		if err := recordTransactionCreated(ctx, tx, side.transactionID, internal.PaymentStatusSuccess); err != nil {
+			return internal.Transfer{}, err
+		}
+	}
+
+	entry := internal.NewWalletTransferEntry(transfer.OutgoingTransactionID, transferRequest.FromUserID, transferRequest.ToUserID, transferRequest.Amount)
+	if err := postJournalEntry(ctx, tx, entry); err != nil {
+		return internal.Transfer{}, fmt.Errorf("error posting transfer to ledger: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return internal.Transfer{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return transfer, nil
+}
+
+// lockBalance locks the user's balance in currency until tx ends and returns
+// its available part. A wallet without a balance in currency gets an empty
+// one.
+func lockBalance(ctx context.Context, tx pgx.Tx, userID uint64, currency string) (pgtype.Numeric, error) {
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO user_balances (user_id, currency, balance)
+		 SELECT $1, $2, 0
+		 WHERE EXISTS (SELECT 1 FROM user_balances WHERE user_id = $1)
+		 ON CONFLICT (user_id, currency) DO NOTHING`,
+		userID,
+		currency,
+	)
+	if err != nil {
+		return pgtype.Numeric{}, fmt.Errorf("error opening balance: %v", err)
+	}
+
+	var balance pgtype.Numeric
+	err = tx.QueryRow(
+		ctx,
+		`SELECT balance - held_balance FROM user_balances WHERE user_id = $1 AND currency = $2 FOR UPDATE`,
+		userID,
+		currency,
+	).Scan(&balance)
+	if err == pgx.ErrNoRows {
+		return pgtype.Numeric{}, fmt.Errorf("%w: user %d", internal.ErrWalletNotFound, userID)
+	}
+	if err != nil {
+		return pgtype.Numeric{}, fmt.Errorf("error locking balance: %v", err)
+	}
+
+	return balance, nil
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/jackc/pgx/v5"
+)
+
+// recordWebhookEvent stores the gateway webhook event in ctx, if it carries
+// one, within the tx that applies it. It returns
+// internal.ErrWebhookEventDuplicate if the event was already recorded.
+func recordWebhookEvent(ctx context.Context, tx pgx.Tx) error {
+	event, ok := internal.WebhookEventFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	result, err := tx.Exec(
+		ctx,
+		`INSERT INTO gateway_webhook_events (event_id, transaction_id, status, received_at)
+		 VALUES ($1, $2, $3, NOW())
+		 ON CONFLICT (event_id) DO NOTHING`,
+		event.ID,
+		event.TransactionID,
+		event.Status,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording webhook event: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", internal.ErrWebhookEventDuplicate, event.ID)
+	}
+
+	return nil
+}
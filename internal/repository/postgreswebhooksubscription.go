@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/jackc/pgx/v5"
+)
+
+// subscriptionColumns lists the columns read by scanWebhookSubscription, in order
+const subscriptionColumns = `id, url, secret, active, created_at, updated_at`
+
+// CreateWebhookSubscription stores a new webhook subscription
+func (s *PostgresStorage) CreateWebhookSubscription(ctx context.Context, subscription internal.WebhookSubscription) (internal.WebhookSubscription, error) {
+	subscription, err := scanWebhookSubscription(s.pool.QueryRow(
+		ctx,
+		`INSERT INTO webhook_subscriptions (id, url, secret, active, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, NOW(), NOW())
+		 RETURNING `+subscriptionColumns,
+		subscription.ID,
+		subscription.URL,
+		subscription.Secret,
+		subscription.Active,
+	))
+	if err != nil {
+		return internal.WebhookSubscription{}, fmt.Errorf("error creating webhook subscription: %v", err)
+	}
+
+	return subscription, nil
+}
+
+// GetWebhookSubscriptions lists every webhook subscription
+func (s *PostgresStorage) GetWebhookSubscriptions(ctx context.Context) ([]internal.WebhookSubscription, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT `+subscriptionColumns+`
+		 FROM webhook_subscriptions
+		 ORDER BY created_at`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhook subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []internal.WebhookSubscription
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook subscription row: %v", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook subscription rows: %v", err)
+	}
+
+	return subscriptions, nil
+}
+
+// GetWebhookSubscription returns a webhook subscription or
+// internal.ErrSubscriptionNotFound
+func (s *PostgresStorage) GetWebhookSubscription(ctx context.Context, subscriptionID string) (internal.WebhookSubscription, error) {
+	subscription, err := scanWebhookSubscription(s.pool.QueryRow(
+		ctx,
+		`SELECT `+subscriptionColumns+`
+		 FROM webhook_subscriptions
+		 WHERE id = $1`,
+		subscriptionID,
+	))
+	if err == pgx.ErrNoRows {
+		return internal.WebhookSubscription{}, fmt.Errorf("%w: %s", internal.ErrSubscriptionNotFound, subscriptionID)
+	}
+	if err != nil {
+		return internal.WebhookSubscription{}, fmt.Errorf("error getting webhook subscription: %v", err)
+	}
+
+	return subscription, nil
+}
+
+// UpdateWebhookSubscription changes the URL and active flag of a webhook
+// subscription. Its secret is kept.
+func (s *PostgresStorage) UpdateWebhookSubscription(ctx context.Context, subscription internal.WebhookSubscription) (internal.WebhookSubscription, error) {
+	updated, err := scanWebhookSubscription(s.pool.QueryRow(
+		ctx,
+		`UPDATE webhook_subscriptions
+		 SET url = $2, active = $3, updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+subscriptionColumns,
+		subscription.ID,
+		subscription.URL,
+		subscription.Active,
+	))
+	if err == pgx.ErrNoRows {
+		return internal.WebhookSubscription{}, fmt.Errorf("%w: %s", internal.ErrSubscriptionNotFound, subscription.ID)
+	}
+	if err != nil {
+		return internal.WebhookSubscription{}, fmt.Errorf("error updating webhook subscription: %v", err)
+	}
+
+	return updated, nil
+}
+
+// DeleteWebhookSubscription removes a webhook subscription together with its
+// deliveries
+func (s *PostgresStorage) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	result, err := s.pool.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, subscriptionID)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook subscription: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", internal.ErrSubscriptionNotFound, subscriptionID)
+	}
+
+	return nil
+}
+
+// scanWebhookSubscription reads a row selected with subscriptionColumns
+func scanWebhookSubscription(row pgx.Row) (internal.WebhookSubscription, error) {
+	var subscription internal.WebhookSubscription
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.URL,
+		&subscription.Secret,
+		&subscription.Active,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	)
+	return subscription, err
+}
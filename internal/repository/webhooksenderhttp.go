@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+)
+
+// maxWebhookResponseSize caps how much of a subscriber's answer is kept for
+// the delivery's last error
+const maxWebhookResponseSize = 1 << 10
+
+// WebhookSenderHTTP posts webhook deliveries to their subscription URL. The
+// body is the event as JSON, signed like the gateway signs its webhooks: the
+// hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the subscription secret.
+type WebhookSenderHTTP struct {
+	httpClient *http.Client
+}
+
+func NewWebhookSenderHTTP(timeout time.Duration) *WebhookSenderHTTP {
+	return &WebhookSenderHTTP{
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Send delivers the event of delivery, failing unless the subscriber answers
+// with a 2xx status
+func (w *WebhookSenderHTTP) Send(ctx context.Context, delivery internal.WebhookDelivery) error {
+	payload, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook event: %v", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(internal.WebhookIDHeader, strconv.FormatInt(delivery.Event.ID, 10))
+	request.Header.Set(internal.WebhookTimestampHeader, timestamp)
+	request.Header.Set(internal.WebhookSignatureHeader, hex.EncodeToString(internal.SignWebhook(delivery.Secret, timestamp, payload)))
+
+	response, err := w.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error sending webhook: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, maxWebhookResponseSize))
+		return fmt.Errorf("webhook answered with status %d: %s", response.StatusCode, body)
+	}
+
+	return nil
+}
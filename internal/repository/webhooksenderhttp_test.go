@@ -0,0 +1,60 @@
+package repository_test
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSenderHTTP_Send(t *testing.T) {
+	event := internal.WebhookEvent{
+		ID:   42,
+		Type: internal.WebhookEventTransactionUpdated,
+		Data: json.RawMessage(`{"id":"payment-123","status":"success"}`),
+	}
+
+	t.Run("signed delivery", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			timestamp := r.Header.Get(internal.WebhookTimestampHeader)
+			expected := hex.EncodeToString(internal.SignWebhook("whsec_test", timestamp, body))
+			assert.Equal(t, expected, r.Header.Get(internal.WebhookSignatureHeader))
+			assert.Equal(t, "42", r.Header.Get(internal.WebhookIDHeader))
+
+			var received internal.WebhookEvent
+			require.NoError(t, json.Unmarshal(body, &received))
+			assert.Equal(t, event.Type, received.Type)
+			assert.JSONEq(t, string(event.Data), string(received.Data))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		t.Cleanup(server.Close)
+
+		sender := repository.NewWebhookSenderHTTP(time.Second)
+		err := sender.Send(context.Background(), internal.WebhookDelivery{URL: server.URL, Secret: "whsec_test", Event: event})
+		assert.NoError(t, err)
+	})
+
+	t.Run("non 2xx answer fails", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("boom"))
+		}))
+		t.Cleanup(server.Close)
+
+		sender := repository.NewWebhookSenderHTTP(time.Second)
+		err := sender.Send(context.Background(), internal.WebhookDelivery{URL: server.URL, Secret: "whsec_test", Event: event})
+		assert.ErrorContains(t, err, "status 500: boom")
+	})
+}
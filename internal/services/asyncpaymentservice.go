@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+type paymentJob struct {
+	transactionID  string
+	paymentRequest internal.PaymentRequest
+}
+
+// AsyncPaymentService records payments as pending and returns right away,
+// leaving the gateway call and the status update to a pool of workers.
+// Payments still queued when the process stops stay pending until the
+// reconciler settles them.
+type AsyncPaymentService struct {
+	payments *PaymentService
+	cfg      config.PaymentConfig
+	queue    chan paymentJob
+}
+
+func NewAsyncPaymentService(payments *PaymentService, cfg config.PaymentConfig) *AsyncPaymentService {
+	return &AsyncPaymentService{
+		payments: payments,
+		cfg:      cfg,
+		queue:    make(chan paymentJob, cfg.QueueSize),
+	}
+}
+
+// CreatePayment debits the wallet and queues the payment for the gateway,
+// returning the ID of the pending transaction. When the queue is full the
+// payment is charged right away and its actual status returned.
+func (s *AsyncPaymentService) CreatePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (string, string, error) {
+	transactionID, err := s.payments.createPaymentRequest(ctx, paymentRequest)
+	if err != nil {
+		return "", "", err
+	}
+
+	job := paymentJob{transactionID: transactionID, paymentRequest: paymentRequest}
+	select {
+	case s.queue <- job:
+		return transactionID, internal.PaymentStatusPending, nil
+	default:
+	}
+
+	// The workers cannot keep up, charge it while the client waits rather
+	// than dropping an already debited payment
+	slog.WarnContext(ctx, "Payment queue full, charging synchronously", "transaction_id", transactionID)
+	status, err := s.payments.charge(ctx, paymentRequest, transactionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return transactionID, status, nil
+}
+
+// Run sends queued payments to the gateway with cfg.Workers workers until ctx
+// is cancelled
+func (s *AsyncPaymentService) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for range s.cfg.Workers {
+		workers.Go(func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.queue:
+					s.process(ctx, job)
+				}
+			}
+		})
+	}
+	workers.Wait()
+}
+
+// process charges a queued payment. Shutting down does not interrupt a
+// payment already sent to the gateway, which would otherwise be marked
+// failed while the gateway may have charged it.
+func (s *AsyncPaymentService) process(ctx context.Context, job paymentJob) {
+	_, err := s.payments.charge(context.WithoutCancel(ctx), job.paymentRequest, job.transactionID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Async payment failed", "transaction_id", job.transactionID, "error", err.Error())
+		return
+	}
+	slog.InfoContext(ctx, "Async payment processed", "transaction_id", job.transactionID)
+}
@@ -0,0 +1,98 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAsyncPaymentService_CreatePayment(t *testing.T) {
+	request := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: usd(10050)}
+	approved := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123", Status: internal.PaymentStatusSuccess}
+
+	t.Run("returns before the gateway call, which a worker runs", func(t *testing.T) {
+		storage := new(mockPaymentStorage)
+		gatewayClient := new(mockGatewayClient)
+		updated := make(chan struct{})
+		storage.On("CreatePaymentRequest", mock.Anything, request).Return("payment-123", nil)
+		gatewayClient.On("CreatePayment", mock.Anything, "payment-123", request).Return(approved, nil)
+		storage.On("UpdatePaymentRequest", mock.Anything, request, "payment-123", internal.PaymentStatusSuccess, approved).
+			Return(nil).
+			Run(func(mock.Arguments) { close(updated) })
+		service := services.NewAsyncPaymentService(services.NewPaymentService(storage, gatewayClient), config.PaymentConfig{Workers: 2, QueueSize: 10})
+
+		transactionID, status, err := service.CreatePayment(context.Background(), request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "payment-123", transactionID)
+		assert.Equal(t, internal.PaymentStatusPending, status)
+		gatewayClient.AssertNotCalled(t, "CreatePayment", mock.Anything, mock.Anything, mock.Anything)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			service.Run(ctx)
+			close(stopped)
+		}()
+		select {
+		case <-updated:
+		case <-time.After(time.Second):
+			t.Fatal("payment was not processed")
+		}
+		cancel()
+		<-stopped
+		storage.AssertExpectations(t)
+		gatewayClient.AssertExpectations(t)
+	})
+
+	t.Run("charges synchronously when the queue is full", func(t *testing.T) {
+		storage := new(mockPaymentStorage)
+		gatewayClient := new(mockGatewayClient)
+		storage.On("CreatePaymentRequest", mock.Anything, request).Return("payment-123", nil)
+		gatewayClient.On("CreatePayment", mock.Anything, "payment-123", request).Return(approved, nil)
+		storage.On("UpdatePaymentRequest", mock.Anything, request, "payment-123", internal.PaymentStatusSuccess, approved).Return(nil)
+		service := services.NewAsyncPaymentService(services.NewPaymentService(storage, gatewayClient), config.PaymentConfig{Workers: 1, QueueSize: 0})
+
+		transactionID, status, err := service.CreatePayment(context.Background(), request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "payment-123", transactionID)
+		assert.Equal(t, internal.PaymentStatusSuccess, status)
+		storage.AssertExpectations(t)
+		gatewayClient.AssertExpectations(t)
+	})
+
+	t.Run("declined synchronous charge is returned as an error", func(t *testing.T) {
+		storage := new(mockPaymentStorage)
+		gatewayClient := new(mockGatewayClient)
+		declined := internal.GatewayResponse{Gateway: "mock", ResponseCode: "insufficient_funds", Status: internal.PaymentStatusFailed}
+		storage.On("CreatePaymentRequest", mock.Anything, request).Return("payment-123", nil)
+		gatewayClient.On("CreatePayment", mock.Anything, "payment-123", request).Return(declined, internal.ErrGatewayDeclined)
+		storage.On("UpdatePaymentRequest", mock.Anything, request, "payment-123", internal.PaymentStatusFailed, declined).Return(nil)
+		service := services.NewAsyncPaymentService(services.NewPaymentService(storage, gatewayClient), config.PaymentConfig{Workers: 1, QueueSize: 0})
+
+		_, _, err := service.CreatePayment(context.Background(), request)
+
+		assert.ErrorIs(t, err, services.ErrPaymentGateway)
+		storage.AssertExpectations(t)
+		gatewayClient.AssertExpectations(t)
+	})
+
+	t.Run("not enough balance is not queued", func(t *testing.T) {
+		storage := new(mockPaymentStorage)
+		gatewayClient := new(mockGatewayClient)
+		storage.On("CreatePaymentRequest", mock.Anything, request).Return("", internal.ErrNotEnoughBalance)
+		service := services.NewAsyncPaymentService(services.NewPaymentService(storage, gatewayClient), config.PaymentConfig{Workers: 1, QueueSize: 10})
+
+		_, _, err := service.CreatePayment(context.Background(), request)
+
+		assert.ErrorIs(t, err, services.ErrNotEnoughBalance)
+		gatewayClient.AssertNotCalled(t, "CreatePayment", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
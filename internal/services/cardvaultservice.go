@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+var (
+	ErrTokenizingCard = errors.New("error tokenizing card")
+	ErrResolvingCard  = errors.New("error resolving card")
+)
+
+type CardVaultStorage interface {
+	CreateVaultedCard(ctx context.Context, card internal.VaultedCard) (internal.VaultedCard, error)
+	GetVaultedCard(ctx context.Context, userID uint64, token string) (internal.VaultedCard, error)
+}
+
+// vaultedCardData is the part of a card the vault encrypts
+type vaultedCardData struct {
+	Number      string `json:"number"`
+	ExpiryMonth int    `json:"expiry_month"`
+	ExpiryYear  int    `json:"expiry_year"`
+	HolderName  string `json:"holder_name"`
+}
+
+// CardVaultService swaps cards for opaque tokens. Cards are encrypted with
+// AES-GCM before being stored, bound to their token and user so a stored
+// card cannot be moved to another one. Only gateway clients resolve tokens
+// back into cards.
+type CardVaultService struct {
+	storage CardVaultStorage
+	// aead is nil when no key is configured
+	aead cipher.AEAD
+}
+
+// NewCardVaultService fails if the configured key is not a valid AES key
+func NewCardVaultService(storage CardVaultStorage, cfg config.CardVaultConfig) (*CardVaultService, error) {
+	s := &CardVaultService{storage: storage}
+	if cfg.Key == nil {
+		return s, nil
+	}
+
+	block, err := aes.NewCipher(cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid card vault key: %v", err)
+	}
+	s.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid card vault key: %v", err)
+	}
+
+	return s, nil
+}
+
+// Tokenize validates a card and stores it encrypted, returning its token
+func (s *CardVaultService) Tokenize(ctx context.Context, userID uint64, card internal.Card) (internal.CardToken, error) {
+	if s.aead == nil {
+		return internal.CardToken{}, internal.ErrCardVaultDisabled
+	}
+	if err := card.Validate(time.Now()); err != nil {
+		return internal.CardToken{}, err
+	}
+
+	plaintext, err := json.Marshal(vaultedCardData{
+		Number:      card.Number,
+		ExpiryMonth: card.ExpiryMonth,
+		ExpiryYear:  card.ExpiryYear,
+		HolderName:  card.HolderName,
+	})
+	if err != nil {
+		return internal.CardToken{}, fmt.Errorf("%w: %s", ErrTokenizingCard, err.Error())
+	}
+
+	token := "tok_" + rand.Text()
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return internal.CardToken{}, fmt.Errorf("%w: %s", ErrTokenizingCard, err.Error())
+	}
+
+	vaulted, err := s.storage.CreateVaultedCard(ctx, internal.VaultedCard{
+		CardToken: internal.CardToken{
+			Token:       token,
+			UserID:      userID,
+			Last4:       card.Last4(),
+			ExpiryMonth: card.ExpiryMonth,
+			ExpiryYear:  card.ExpiryYear,
+		},
+		// The nonce is stored in front of the ciphertext
+		EncryptedCard: s.aead.Seal(nonce, nonce, plaintext, additionalData(userID, token)),
+	})
+	if err != nil {
+		return internal.CardToken{}, fmt.Errorf("%w: %s", ErrTokenizingCard, err.Error())
+	}
+
+	return vaulted.CardToken, nil
+}
+
+// ResolveCard returns the card of a token of the user, failing with
+// internal.ErrCardNotFound for unknown tokens or tokens of other users
+func (s *CardVaultService) ResolveCard(ctx context.Context, userID uint64, token string) (internal.Card, error) {
+	if s.aead == nil {
+		return internal.Card{}, internal.ErrCardVaultDisabled
+	}
+
+	vaulted, err := s.storage.GetVaultedCard(ctx, userID, token)
+	if errors.Is(err, internal.ErrCardNotFound) {
+		return internal.Card{}, err
+	}
+	if err != nil {
+		return internal.Card{}, fmt.Errorf("%w: %s", ErrResolvingCard, err.Error())
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(vaulted.EncryptedCard) < nonceSize {
+		return internal.Card{}, fmt.Errorf("%w: encrypted card too short", ErrResolvingCard)
+	}
+	nonce, ciphertext := vaulted.EncryptedCard[:nonceSize], vaulted.EncryptedCard[nonceSize:]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, additionalData(userID, token))
+	if err != nil {
+		return internal.Card{}, fmt.Errorf("%w: %s", ErrResolvingCard, err.Error())
+	}
+
+	var data vaultedCardData
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		return internal.Card{}, fmt.Errorf("%w: %s", ErrResolvingCard, err.Error())
+	}
+
+	return internal.Card(data), nil
+}
+
+// CheckCard fails with internal.ErrCardNotFound unless token is a card of the
+// user, without decrypting it
+func (s *CardVaultService) CheckCard(ctx context.Context, userID uint64, token string) error {
+	if s.aead == nil {
+		return internal.ErrCardVaultDisabled
+	}
+
+	_, err := s.storage.GetVaultedCard(ctx, userID, token)
+	if errors.Is(err, internal.ErrCardNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrResolvingCard, err.Error())
+	}
+
+	return nil
+}
+
+// additionalData binds an encrypted card to its user and token
+func additionalData(userID uint64, token string) []byte {
+	return []byte(strconv.FormatUint(userID, 10) + ":" + token)
+}
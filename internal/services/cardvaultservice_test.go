@@ -0,0 +1,180 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockCardVaultStorage struct {
+	mock.Mock
+}
+
+func (m *mockCardVaultStorage) CreateVaultedCard(ctx context.Context, card internal.VaultedCard) (internal.VaultedCard, error) {
+	args := m.Called(ctx, card)
+	return args.Get(0).(internal.VaultedCard), args.Error(1)
+}
+
+func (m *mockCardVaultStorage) GetVaultedCard(ctx context.Context, userID uint64, token string) (internal.VaultedCard, error) {
+	args := m.Called(ctx, userID, token)
+	return args.Get(0).(internal.VaultedCard), args.Error(1)
+}
+
+var testCardVaultKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestCardVaultService_TokenizeAndResolve(t *testing.T) {
+	ctx := context.Background()
+	card := internal.Card{Number: "4242424242424242", ExpiryMonth: 12, ExpiryYear: time.Now().Year() + 2, HolderName: "Ada Lovelace"}
+
+	var stored internal.VaultedCard
+	mockStorage := new(mockCardVaultStorage)
+	mockStorage.On("CreateVaultedCard", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(internal.VaultedCard) }).
+		Return(internal.VaultedCard{}, nil)
+	vault, err := services.NewCardVaultService(mockStorage, config.CardVaultConfig{Key: testCardVaultKey})
+	require.NoError(t, err)
+
+	_, err = vault.Tokenize(ctx, 1234, card)
+	require.NoError(t, err)
+	cardToken := stored.CardToken
+	assert.Regexp(t, `^tok_[A-Z2-7]+$`, cardToken.Token)
+	assert.Equal(t, uint64(1234), cardToken.UserID)
+	assert.Equal(t, "4242", cardToken.Last4)
+	assert.Equal(t, card.ExpiryYear, cardToken.ExpiryYear)
+	assert.False(t, bytes.Contains(stored.EncryptedCard, []byte(card.Number)), "card number stored in clear")
+	assert.False(t, bytes.Contains(stored.EncryptedCard, []byte(card.HolderName)), "holder name stored in clear")
+
+	mockStorage.On("GetVaultedCard", mock.Anything, mock.Anything, mock.Anything).Return(stored, nil)
+
+	resolved, err := vault.ResolveCard(ctx, 1234, cardToken.Token)
+	require.NoError(t, err)
+	assert.Equal(t, card, resolved)
+
+	// The encrypted card is bound to its user and token
+	_, err = vault.ResolveCard(ctx, 5678, cardToken.Token)
+	assert.ErrorIs(t, err, services.ErrResolvingCard)
+	_, err = vault.ResolveCard(ctx, 1234, "tok_OTHER")
+	assert.ErrorIs(t, err, services.ErrResolvingCard)
+
+	// Another key cannot decrypt it
+	otherVault, err := services.NewCardVaultService(mockStorage, config.CardVaultConfig{Key: []byte("fedcba9876543210fedcba9876543210")})
+	require.NoError(t, err)
+	_, err = otherVault.ResolveCard(ctx, 1234, cardToken.Token)
+	assert.ErrorIs(t, err, services.ErrResolvingCard)
+}
+
+func TestCardVaultService_Tokenize(t *testing.T) {
+	expired := internal.Card{Number: "4242424242424242", ExpiryMonth: 1, ExpiryYear: 2020, HolderName: "Ada Lovelace"}
+
+	tests := []struct {
+		name          string
+		key           []byte
+		expectedError error
+	}{
+		{
+			name:          "invalid card is not stored",
+			key:           testCardVaultKey,
+			expectedError: internal.ErrInvalidCard,
+		},
+		{
+			name:          "vault without key",
+			expectedError: internal.ErrCardVaultDisabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockCardVaultStorage)
+			vault, err := services.NewCardVaultService(mockStorage, config.CardVaultConfig{Key: tt.key})
+			require.NoError(t, err)
+
+			_, err = vault.Tokenize(context.Background(), 1234, expired)
+
+			assert.ErrorIs(t, err, tt.expectedError)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCardVaultService_ResolveCard(t *testing.T) {
+	mockStorage := new(mockCardVaultStorage)
+	mockStorage.On("GetVaultedCard", mock.Anything, uint64(1234), "tok_UNKNOWN").Return(internal.VaultedCard{}, internal.ErrCardNotFound)
+	vault, err := services.NewCardVaultService(mockStorage, config.CardVaultConfig{Key: testCardVaultKey})
+	require.NoError(t, err)
+
+	_, err = vault.ResolveCard(context.Background(), 1234, "tok_UNKNOWN")
+
+	assert.ErrorIs(t, err, internal.ErrCardNotFound)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestCardVaultService_CheckCard(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           []byte
+		setupMocks    func(*mockCardVaultStorage)
+		expectedError error
+	}{
+		{
+			name: "card of the user",
+			key:  testCardVaultKey,
+			setupMocks: func(cs *mockCardVaultStorage) {
+				cs.On("GetVaultedCard", mock.Anything, uint64(1234), "tok_4F9A8B7C").Return(internal.VaultedCard{}, nil)
+			},
+		},
+		{
+			name: "unknown card",
+			key:  testCardVaultKey,
+			setupMocks: func(cs *mockCardVaultStorage) {
+				cs.On("GetVaultedCard", mock.Anything, uint64(1234), "tok_4F9A8B7C").Return(internal.VaultedCard{}, internal.ErrCardNotFound)
+			},
+			expectedError: internal.ErrCardNotFound,
+		},
+		{
+			name: "storage error",
+			key:  testCardVaultKey,
+			setupMocks: func(cs *mockCardVaultStorage) {
+				cs.On("GetVaultedCard", mock.Anything, uint64(1234), "tok_4F9A8B7C").Return(internal.VaultedCard{}, errors.New("database error"))
+			},
+			expectedError: services.ErrResolvingCard,
+		},
+		{
+			name:          "vault without key",
+			setupMocks:    func(cs *mockCardVaultStorage) {},
+			expectedError: internal.ErrCardVaultDisabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockCardVaultStorage)
+			tt.setupMocks(mockStorage)
+			vault, err := services.NewCardVaultService(mockStorage, config.CardVaultConfig{Key: tt.key})
+			require.NoError(t, err)
+
+			err = vault.CheckCard(context.Background(), 1234, "tok_4F9A8B7C")
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestNewCardVaultService_InvalidKey(t *testing.T) {
+	_, err := services.NewCardVaultService(new(mockCardVaultStorage), config.CardVaultConfig{Key: []byte("too short")})
+
+	assert.Error(t, err)
+}
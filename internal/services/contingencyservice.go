@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+var (
+	ErrGettingContingencies = errors.New("error getting payment contingencies")
+)
+
+type ContingencyStorage interface {
+	TransactionUpdater
+	ClaimDuePaymentContingencies(ctx context.Context, limit int, lease time.Duration) ([]internal.PaymentContingency, error)
+	GetStuckPaymentContingencies(ctx context.Context, minAttempts int) ([]internal.PaymentContingency, error)
+	ResolvePaymentContingency(ctx context.Context, contingencyID int64) error
+	ReschedulePaymentContingency(ctx context.Context, contingencyID int64, nextAttemptAt time.Time, lastError string) error
+}
+
+// ContingencyService retries the payment, deposit and refund status updates
+// that PaymentService could not record after calling the gateway
+type ContingencyService struct {
+	storage ContingencyStorage
+	cfg     config.ContingencyConfig
+}
+
+func NewContingencyService(storage ContingencyStorage, cfg config.ContingencyConfig) *ContingencyService {
+	return &ContingencyService{
+		storage: storage,
+		cfg:     cfg,
+	}
+}
+
+// Run processes due contingencies every poll interval until ctx is cancelled
+func (s *ContingencyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ProcessDue(ctx)
+		}
+	}
+}
+
+// ProcessDue retries one batch of due contingencies
+func (s *ContingencyService) ProcessDue(ctx context.Context) {
+	// Claimed entries stay hidden for MaxBackoff in case this process dies mid-retry
+	contingencies, err := s.storage.ClaimDuePaymentContingencies(ctx, s.cfg.BatchSize, s.cfg.MaxBackoff)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not claim payment contingencies", "error", err.Error())
+		return
+	}
+
+	for _, contingency := range contingencies {
+		s.retry(ctx, contingency)
+	}
+}
+
+// GetStuckContingencies lists the contingencies that keep failing
+func (s *ContingencyService) GetStuckContingencies(ctx context.Context) ([]internal.PaymentContingency, error) {
+	contingencies, err := s.storage.GetStuckPaymentContingencies(ctx, s.cfg.StuckAfterAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrGettingContingencies, err.Error())
+	}
+
+	return contingencies, nil
+}
+
+func (s *ContingencyService) retry(ctx context.Context, contingency internal.PaymentContingency) {
+	err := updateTransaction(ctx, s.storage, contingency.TransactionType, contingency.PaymentRequest, contingency.TransactionID, contingency.IntendedStatus, contingency.GatewayResponse)
+	if err != nil && !errors.Is(err, internal.ErrTransactionNotPending) {
+		nextAttemptAt := time.Now().Add(s.backoff(contingency.Attempts))
+		slog.WarnContext(ctx, "Payment contingency retry failed",
+			"contingency_id", contingency.ID,
+			"transaction_id", contingency.TransactionID,
+			"transaction_type", contingency.TransactionType,
+			"attempts", contingency.Attempts+1,
+			"next_attempt_at", nextAttemptAt,
+			"error", err.Error(),
+		)
+		if err := s.storage.ReschedulePaymentContingency(ctx, contingency.ID, nextAttemptAt, err.Error()); err != nil {
+			slog.ErrorContext(ctx, "Could not reschedule payment contingency", "contingency_id", contingency.ID, "error", err.Error())
+		}
+		return
+	}
+
+	// A payment that is no longer pending was already finalised elsewhere
+	if err := s.storage.ResolvePaymentContingency(ctx, contingency.ID); err != nil {
+		slog.ErrorContext(ctx, "Could not resolve payment contingency", "contingency_id", contingency.ID, "error", err.Error())
+		return
+	}
+	slog.InfoContext(ctx, "Payment contingency resolved",
+		"contingency_id", contingency.ID,
+		"transaction_id", contingency.TransactionID,
+		"status", contingency.IntendedStatus,
+	)
+}
+
+// backoff doubles the wait after each failed attempt, capped at MaxBackoff
+func (s *ContingencyService) backoff(attempts int) time.Duration {
+	delay := s.cfg.BaseBackoff
+	for range attempts {
+		delay *= 2
+		if delay >= s.cfg.MaxBackoff {
+			return s.cfg.MaxBackoff
+		}
+	}
+
+	return min(delay, s.cfg.MaxBackoff)
+}
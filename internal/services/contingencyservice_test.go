@@ -0,0 +1,193 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockContingencyStorage struct {
+	mock.Mock
+}
+
+func (m *mockContingencyStorage) UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, paymentRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockContingencyStorage) UpdateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, depositRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockContingencyStorage) UpdateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, refundRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockContingencyStorage) ClaimDuePaymentContingencies(ctx context.Context, limit int, lease time.Duration) ([]internal.PaymentContingency, error) {
+	args := m.Called(ctx, limit, lease)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.PaymentContingency), args.Error(1)
+}
+
+func (m *mockContingencyStorage) GetStuckPaymentContingencies(ctx context.Context, minAttempts int) ([]internal.PaymentContingency, error) {
+	args := m.Called(ctx, minAttempts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.PaymentContingency), args.Error(1)
+}
+
+func (m *mockContingencyStorage) ResolvePaymentContingency(ctx context.Context, contingencyID int64) error {
+	args := m.Called(ctx, contingencyID)
+	return args.Error(0)
+}
+
+func (m *mockContingencyStorage) ReschedulePaymentContingency(ctx context.Context, contingencyID int64, nextAttemptAt time.Time, lastError string) error {
+	args := m.Called(ctx, contingencyID, nextAttemptAt, lastError)
+	return args.Error(0)
+}
+
+var testContingencyConfig = config.ContingencyConfig{
+	PollInterval:       time.Second,
+	BaseBackoff:        time.Second,
+	MaxBackoff:         time.Minute,
+	BatchSize:          10,
+	StuckAfterAttempts: 3,
+}
+
+func TestContingencyService_ProcessDue(t *testing.T) {
+	contingency := internal.PaymentContingency{
+		ID:              7,
+		TransactionID:   "payment-123",
+		TransactionType: internal.TransactionTypePayment,
+		PaymentRequest: internal.PaymentRequest{
+			UserID: 1234,
+			Method: "card",
+			Amount: usd(10050),
+		},
+		IntendedStatus:  internal.PaymentStatusSuccess,
+		GatewayResponse: internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123"},
+		Attempts:        2,
+	}
+
+	tests := []struct {
+		name      string
+		setupMock func(*mockContingencyStorage)
+	}{
+		{
+			name: "successful retry resolves the contingency",
+			setupMock: func(m *mockContingencyStorage) {
+				m.On("ClaimDuePaymentContingencies", mock.Anything, 10, time.Minute).
+					Return([]internal.PaymentContingency{contingency}, nil)
+				m.On("UpdatePaymentRequest", mock.Anything, contingency.PaymentRequest, "payment-123", internal.PaymentStatusSuccess, contingency.GatewayResponse).
+					Return(nil)
+				m.On("ResolvePaymentContingency", mock.Anything, int64(7)).Return(nil)
+			},
+		},
+		{
+			name: "payment finalised elsewhere resolves the contingency",
+			setupMock: func(m *mockContingencyStorage) {
+				m.On("ClaimDuePaymentContingencies", mock.Anything, 10, time.Minute).
+					Return([]internal.PaymentContingency{contingency}, nil)
+				m.On("UpdatePaymentRequest", mock.Anything, mock.Anything, "payment-123", mock.Anything, mock.Anything).
+					Return(internal.ErrTransactionNotPending)
+				m.On("ResolvePaymentContingency", mock.Anything, int64(7)).Return(nil)
+			},
+		},
+		{
+			name: "failed retry is rescheduled with backoff",
+			setupMock: func(m *mockContingencyStorage) {
+				m.On("ClaimDuePaymentContingencies", mock.Anything, 10, time.Minute).
+					Return([]internal.PaymentContingency{contingency}, nil)
+				m.On("UpdatePaymentRequest", mock.Anything, mock.Anything, "payment-123", mock.Anything, mock.Anything).
+					Return(errors.New("database error"))
+				m.On("ReschedulePaymentContingency", mock.Anything, int64(7), mock.MatchedBy(func(nextAttemptAt time.Time) bool {
+					// Two previous attempts: 1s doubled twice
+					delay := time.Until(nextAttemptAt)
+					return delay > 3*time.Second && delay <= 4*time.Second
+				}), "database error").Return(nil)
+			},
+		},
+		{
+			name: "deposit contingency credits the wallet",
+			setupMock: func(m *mockContingencyStorage) {
+				deposit := contingency
+				deposit.TransactionType = internal.TransactionTypeDeposit
+				m.On("ClaimDuePaymentContingencies", mock.Anything, 10, time.Minute).
+					Return([]internal.PaymentContingency{deposit}, nil)
+				m.On("UpdateDepositRequest", mock.Anything, internal.DepositRequest(contingency.PaymentRequest), "payment-123", internal.PaymentStatusSuccess, contingency.GatewayResponse).
+					Return(nil)
+				m.On("ResolvePaymentContingency", mock.Anything, int64(7)).Return(nil)
+			},
+		},
+		{
+			name: "refund contingency credits the wallet",
+			setupMock: func(m *mockContingencyStorage) {
+				refund := contingency
+				refund.TransactionType = internal.TransactionTypeRefund
+				m.On("ClaimDuePaymentContingencies", mock.Anything, 10, time.Minute).
+					Return([]internal.PaymentContingency{refund}, nil)
+				m.On("UpdateRefundRequest", mock.Anything, internal.RefundRequest{UserID: 1234, Amount: usd(10050)}, "payment-123", internal.PaymentStatusSuccess, contingency.GatewayResponse).
+					Return(nil)
+				m.On("ResolvePaymentContingency", mock.Anything, int64(7)).Return(nil)
+			},
+		},
+		{
+			name: "claim error skips the batch",
+			setupMock: func(m *mockContingencyStorage) {
+				m.On("ClaimDuePaymentContingencies", mock.Anything, 10, time.Minute).
+					Return(nil, errors.New("database error"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockContingencyStorage)
+			tt.setupMock(mockStorage)
+
+			service := services.NewContingencyService(mockStorage, testContingencyConfig)
+			service.ProcessDue(context.Background())
+
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContingencyService_GetStuckContingencies(t *testing.T) {
+	t.Run("lists contingencies over the attempts threshold", func(t *testing.T) {
+		mockStorage := new(mockContingencyStorage)
+		stuck := []internal.PaymentContingency{{ID: 7, TransactionID: "payment-123", Attempts: 5}}
+		mockStorage.On("GetStuckPaymentContingencies", mock.Anything, 3).Return(stuck, nil)
+
+		service := services.NewContingencyService(mockStorage, testContingencyConfig)
+		contingencies, err := service.GetStuckContingencies(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, stuck, contingencies)
+		mockStorage.AssertExpectations(t)
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		mockStorage := new(mockContingencyStorage)
+		mockStorage.On("GetStuckPaymentContingencies", mock.Anything, 3).Return(nil, errors.New("database error"))
+
+		service := services.NewContingencyService(mockStorage, testContingencyConfig)
+		contingencies, err := service.GetStuckContingencies(context.Background())
+
+		assert.ErrorIs(t, err, services.ErrGettingContingencies)
+		assert.Nil(t, contingencies)
+		mockStorage.AssertExpectations(t)
+	})
+}
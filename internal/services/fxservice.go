@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+var (
+	ErrCreatingQuote  = errors.New("error creating fx quote")
+	ErrExecutingQuote = errors.New("error executing fx quote")
+)
+
+// RatesProvider gives the current rate to buy quote currency with base
+// currency, failing with internal.ErrRateNotFound for pairs it does not know
+type RatesProvider interface {
+	GetExchangeRate(ctx context.Context, base string, quote string) (internal.Rate, error)
+}
+
+type FXStorage interface {
+	CreateFXQuote(ctx context.Context, quote internal.FXQuote) (internal.FXQuote, error)
+	ExecuteFXQuote(ctx context.Context, userID uint64, quoteID string) (internal.FXQuote, error)
+}
+
+// FXService exchanges money between the currency balances of a wallet. A
+// quote locks the rate for a while and executing it moves the money.
+type FXService struct {
+	storage FXStorage
+	rates   RatesProvider
+	cfg     config.FXConfig
+}
+
+func NewFXService(storage FXStorage, rates RatesProvider, cfg config.FXConfig) *FXService {
+	return &FXService{
+		storage: storage,
+		rates:   rates,
+		cfg:     cfg,
+	}
+}
+
+// CreateQuote prices the sale of the requested amount at the current rate
+// and locks that rate for the configured TTL
+func (s *FXService) CreateQuote(ctx context.Context, quoteRequest internal.FXQuoteRequest) (internal.FXQuote, error) {
+	sellAmount := quoteRequest.SellAmount
+	rate, err := s.rates.GetExchangeRate(ctx, sellAmount.Currency, quoteRequest.BuyCurrency)
+	if errors.Is(err, internal.ErrRateNotFound) {
+		return internal.FXQuote{}, err
+	}
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("%w: %s", ErrCreatingQuote, err.Error())
+	}
+
+	buyAmount, err := rate.Convert(sellAmount, quoteRequest.BuyCurrency)
+	if err != nil {
+		return internal.FXQuote{}, err
+	}
+	if !buyAmount.IsPositive() {
+		return internal.FXQuote{}, fmt.Errorf("%w: %s %s buys less than the smallest %s amount", internal.ErrInvalidAmount, sellAmount, sellAmount.Currency, buyAmount.Currency)
+	}
+
+	quote, err := s.storage.CreateFXQuote(ctx, internal.FXQuote{
+		UserID:       quoteRequest.UserID,
+		SellAmount:   sellAmount,
+		SellCurrency: sellAmount.Currency,
+		BuyAmount:    buyAmount,
+		BuyCurrency:  buyAmount.Currency,
+		Rate:         rate,
+		ExpiresAt:    time.Now().Add(s.cfg.QuoteTTL),
+	})
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("%w: %s", ErrCreatingQuote, err.Error())
+	}
+
+	return quote, nil
+}
+
+// ExecuteQuote exchanges the amounts of a quote at its locked rate. Both
+// balances are updated atomically by the storage.
+func (s *FXService) ExecuteQuote(ctx context.Context, userID uint64, quoteID string) (internal.FXQuote, error) {
+	quote, err := s.storage.ExecuteFXQuote(ctx, userID, quoteID)
+	if errors.Is(err, internal.ErrQuoteNotFound) || errors.Is(err, internal.ErrQuoteNotActive) ||
+		errors.Is(err, ErrNotEnoughBalance) || errors.Is(err, ErrWalletNotFound) {
+		return internal.FXQuote{}, err
+	}
+	if err != nil {
+		return internal.FXQuote{}, fmt.Errorf("%w: %s", ErrExecutingQuote, err.Error())
+	}
+
+	return quote, nil
+}
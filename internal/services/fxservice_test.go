@@ -0,0 +1,200 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockFXStorage struct {
+	mock.Mock
+}
+
+func (m *mockFXStorage) CreateFXQuote(ctx context.Context, quote internal.FXQuote) (internal.FXQuote, error) {
+	args := m.Called(ctx, quote)
+	return args.Get(0).(internal.FXQuote), args.Error(1)
+}
+
+func (m *mockFXStorage) ExecuteFXQuote(ctx context.Context, userID uint64, quoteID string) (internal.FXQuote, error) {
+	args := m.Called(ctx, userID, quoteID)
+	return args.Get(0).(internal.FXQuote), args.Error(1)
+}
+
+type mockRatesProvider struct {
+	mock.Mock
+}
+
+func (m *mockRatesProvider) GetExchangeRate(ctx context.Context, base string, quote string) (internal.Rate, error) {
+	args := m.Called(ctx, base, quote)
+	return args.Get(0).(internal.Rate), args.Error(1)
+}
+
+var testFXConfig = config.FXConfig{
+	QuoteTTL: 30 * time.Second,
+}
+
+func TestFXService_CreateQuote(t *testing.T) {
+	request := internal.FXQuoteRequest{UserID: 1234, SellAmount: usd(10000), BuyCurrency: "EUR"}
+	rate := internal.Rate(92000000)
+	quote := internal.FXQuote{
+		ID:           "quote-123",
+		UserID:       1234,
+		SellAmount:   usd(10000),
+		SellCurrency: "USD",
+		BuyAmount:    internal.NewMoney(9200, "EUR"),
+		BuyCurrency:  "EUR",
+		Rate:         rate,
+	}
+	lockedQuote := mock.MatchedBy(func(q internal.FXQuote) bool {
+		ttl := time.Until(q.ExpiresAt)
+		q.ID, q.ExpiresAt = quote.ID, time.Time{}
+		return q == quote && ttl > 29*time.Second && ttl <= 30*time.Second
+	})
+
+	tests := []struct {
+		name          string
+		request       internal.FXQuoteRequest
+		rate          internal.Rate
+		rateError     error
+		storageQuote  internal.FXQuote
+		storageError  error
+		expectedQuote internal.FXQuote
+		expectedError error
+	}{
+		{
+			name:          "quote locks the rate for the configured ttl",
+			request:       request,
+			rate:          rate,
+			storageQuote:  quote,
+			expectedQuote: quote,
+		},
+		{
+			name:          "unknown currency pair",
+			request:       request,
+			rateError:     internal.ErrRateNotFound,
+			expectedError: internal.ErrRateNotFound,
+		},
+		{
+			name:          "rates provider error",
+			request:       request,
+			rateError:     errors.New("database error"),
+			expectedError: services.ErrCreatingQuote,
+		},
+		{
+			name:          "amount too small to buy anything",
+			request:       internal.FXQuoteRequest{UserID: 1234, SellAmount: usd(1), BuyCurrency: "JPY"},
+			rate:          internal.Rate(50000000),
+			expectedError: internal.ErrInvalidAmount,
+		},
+		{
+			name:          "storage error",
+			request:       request,
+			rate:          rate,
+			storageError:  errors.New("database error"),
+			expectedError: services.ErrCreatingQuote,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockFXStorage)
+			mockRates := new(mockRatesProvider)
+			mockRates.On("GetExchangeRate", mock.Anything, tt.request.SellAmount.Currency, tt.request.BuyCurrency).Return(tt.rate, tt.rateError)
+			if tt.storageQuote.ID != "" || tt.storageError != nil {
+				mockStorage.On("CreateFXQuote", mock.Anything, lockedQuote).Return(tt.storageQuote, tt.storageError)
+			}
+
+			service := services.NewFXService(mockStorage, mockRates, testFXConfig)
+			result, err := service.CreateQuote(context.Background(), tt.request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedQuote, result)
+			mockStorage.AssertExpectations(t)
+			mockRates.AssertExpectations(t)
+		})
+	}
+}
+
+func TestFXService_ExecuteQuote(t *testing.T) {
+	executedAt := time.Now()
+	quote := internal.FXQuote{
+		ID:                    "quote-123",
+		UserID:                1234,
+		SellAmount:            usd(10000),
+		SellCurrency:          "USD",
+		BuyAmount:             internal.NewMoney(9200, "EUR"),
+		BuyCurrency:           "EUR",
+		Rate:                  internal.Rate(92000000),
+		OutgoingTransactionID: "fx-out-123",
+		IncomingTransactionID: "fx-in-123",
+		ExecutedAt:            &executedAt,
+	}
+
+	tests := []struct {
+		name          string
+		storageQuote  internal.FXQuote
+		storageError  error
+		expectedQuote internal.FXQuote
+		expectedError error
+	}{
+		{
+			name:          "successful exchange",
+			storageQuote:  quote,
+			expectedQuote: quote,
+		},
+		{
+			name:          "quote not found",
+			storageError:  internal.ErrQuoteNotFound,
+			expectedError: internal.ErrQuoteNotFound,
+		},
+		{
+			name:          "quote expired",
+			storageError:  internal.ErrQuoteNotActive,
+			expectedError: internal.ErrQuoteNotActive,
+		},
+		{
+			name:          "insufficient balance",
+			storageError:  internal.ErrNotEnoughBalance,
+			expectedError: services.ErrNotEnoughBalance,
+		},
+		{
+			name:          "wallet not found",
+			storageError:  internal.ErrWalletNotFound,
+			expectedError: services.ErrWalletNotFound,
+		},
+		{
+			name:          "storage error",
+			storageError:  errors.New("database error"),
+			expectedError: services.ErrExecutingQuote,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockFXStorage)
+			mockStorage.On("ExecuteFXQuote", mock.Anything, uint64(1234), "quote-123").Return(tt.storageQuote, tt.storageError)
+
+			service := services.NewFXService(mockStorage, new(mockRatesProvider), testFXConfig)
+			result, err := service.ExecuteQuote(context.Background(), 1234, "quote-123")
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedQuote, result)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+// RetryingGatewayClient decorates a GatewayClient, retrying the calls that
+// failed because the gateway was unavailable. Retries reuse the transaction
+// ID, which the gateway uses to deduplicate them. A circuit breaker makes
+// calls fail fast with internal.ErrGatewayCircuitOpen while the gateway stays
+// down.
+type RetryingGatewayClient struct {
+	next GatewayClient
+	cfg  config.GatewayRetryConfig
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// probing is set while the single trial call of a half open breaker runs
+	probing bool
+}
+
+func NewRetryingGatewayClient(next GatewayClient, cfg config.GatewayRetryConfig) *RetryingGatewayClient {
+	return &RetryingGatewayClient{
+		next:  next,
+		cfg:   cfg,
+		state: internal.CircuitBreakerClosed,
+	}
+}
+
+func (g *RetryingGatewayClient) CreatePayment(ctx context.Context, transactionID string, paymentRequest internal.PaymentRequest) (internal.GatewayResponse, error) {
+	return g.call(ctx, "payment", transactionID, func(ctx context.Context) (internal.GatewayResponse, error) {
+		return g.next.CreatePayment(ctx, transactionID, paymentRequest)
+	})
+}
+
+func (g *RetryingGatewayClient) CreateDeposit(ctx context.Context, transactionID string, depositRequest internal.DepositRequest) (internal.GatewayResponse, error) {
+	return g.call(ctx, "deposit", transactionID, func(ctx context.Context) (internal.GatewayResponse, error) {
+		return g.next.CreateDeposit(ctx, transactionID, depositRequest)
+	})
+}
+
+func (g *RetryingGatewayClient) Refund(ctx context.Context, transactionID string, refundRequest internal.RefundRequest) (internal.GatewayResponse, error) {
+	return g.call(ctx, "refund", transactionID, func(ctx context.Context) (internal.GatewayResponse, error) {
+		return g.next.Refund(ctx, transactionID, refundRequest)
+	})
+}
+
+func (g *RetryingGatewayClient) GetPaymentStatus(ctx context.Context, transaction internal.Transaction) (internal.GatewayResponse, error) {
+	return g.call(ctx, "status", transaction.ID, func(ctx context.Context) (internal.GatewayResponse, error) {
+		return g.next.GetPaymentStatus(ctx, transaction)
+	})
+}
+
+// CircuitBreakerStatus reports the current state of the circuit breaker
+func (g *RetryingGatewayClient) CircuitBreakerStatus() internal.CircuitBreakerStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	status := internal.CircuitBreakerStatus{
+		State:               g.state,
+		ConsecutiveFailures: g.failures,
+	}
+	if g.state != internal.CircuitBreakerClosed {
+		openedAt := g.openedAt
+		halfOpenAt := g.openedAt.Add(g.cfg.BreakerCooldown)
+		status.OpenedAt = &openedAt
+		status.HalfOpenAt = &halfOpenAt
+		// The breaker only moves to half open on the next call
+		if g.state == internal.CircuitBreakerOpen && !time.Now().Before(halfOpenAt) {
+			status.State = internal.CircuitBreakerHalfOpen
+		}
+	}
+
+	return status
+}
+
+// call runs attempt until it succeeds, fails for a reason other than the
+// gateway being unavailable, runs out of attempts or the context deadline
+// leaves no time for another one. The last response and error are returned,
+// unless the last attempt never reached the gateway but an earlier one may
+// have, whose error is returned instead.
+func (g *RetryingGatewayClient) call(ctx context.Context, operation string, transactionID string, attempt func(context.Context) (internal.GatewayResponse, error)) (internal.GatewayResponse, error) {
+	var (
+		response internal.GatewayResponse
+		err      error
+		// reachedErr is the error of the last attempt that may have reached
+		// the gateway
+		reachedErr error
+	)
+	result := func() (internal.GatewayResponse, error) {
+		if reachedErr != nil && neverReachedGateway(err) {
+			return response, reachedErr
+		}
+		return response, err
+	}
+	for attempts := 1; ; attempts++ {
+		allowed, probe := g.allow()
+		if !allowed {
+			if err == nil {
+				err = internal.ErrGatewayCircuitOpen
+			}
+			return result()
+		}
+
+		response, err = attempt(ctx)
+		g.record(ctx, err, probe)
+		if err != nil && !neverReachedGateway(err) {
+			reachedErr = err
+		}
+		if !errors.Is(err, internal.ErrGatewayUnavailable) || attempts >= g.cfg.MaxAttempts {
+			return result()
+		}
+
+		delay := g.backoff(attempts)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return result()
+		}
+		slog.WarnContext(ctx, "Retrying gateway call",
+			"operation", operation,
+			"transaction_id", transactionID,
+			"attempts", attempts,
+			"delay", delay,
+			"error", err.Error(),
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result()
+		case <-timer.C:
+		}
+	}
+}
+
+// neverReachedGateway reports whether err proves a call never reached the
+// gateway, so it cannot have charged anything: the circuit breaker refused it
+// or the connection could not be opened
+func neverReachedGateway(err error) bool {
+	return errors.Is(err, internal.ErrGatewayCircuitOpen) || errors.Is(err, internal.ErrGatewayNotReached)
+}
+
+// allow reports whether a call may reach the gateway, moving an open breaker
+// to half open once the cooldown passed. probe is true for the single call let
+// through while half open, which must hand it back to record.
+func (g *RetryingGatewayClient) allow() (allowed bool, probe bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch g.state {
+	case internal.CircuitBreakerOpen:
+		if time.Since(g.openedAt) < g.cfg.BreakerCooldown {
+			return false, false
+		}
+		g.state = internal.CircuitBreakerHalfOpen
+		g.probing = true
+		return true, true
+	case internal.CircuitBreakerHalfOpen:
+		if g.probing {
+			return false, false
+		}
+		g.probing = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// record updates the breaker with the outcome of a call. Any answer from the
+// gateway, declines included, shows it is up. Calls abandoned by the caller
+// and cards that could not be resolved say nothing about the gateway. Only the
+// probe lets another call through while half open; calls started before the
+// breaker opened may still finish meanwhile.
+func (g *RetryingGatewayClient) record(ctx context.Context, err error, probe bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if probe {
+		g.probing = false
+	}
+	switch {
+	case ctx.Err() != nil, errors.Is(err, internal.ErrCardUnresolved):
+		return
+	case !errors.Is(err, internal.ErrGatewayUnavailable):
+		if g.state != internal.CircuitBreakerClosed {
+			slog.InfoContext(ctx, "Gateway circuit breaker closed")
+		}
+		g.state = internal.CircuitBreakerClosed
+		g.failures = 0
+	default:
+		g.failures++
+		if g.state == internal.CircuitBreakerHalfOpen || (g.state == internal.CircuitBreakerClosed && g.failures >= g.cfg.BreakerThreshold) {
+			g.state = internal.CircuitBreakerOpen
+			g.openedAt = time.Now()
+			slog.WarnContext(ctx, "Gateway circuit breaker opened",
+				"consecutive_failures", g.failures,
+				"cooldown", g.cfg.BreakerCooldown,
+			)
+		}
+	}
+}
+
+// backoff doubles the wait after each failed attempt, capped at MaxBackoff,
+// and picks a random point in its upper half so retries spread out
+func (g *RetryingGatewayClient) backoff(attempts int) time.Duration {
+	delay := g.cfg.BaseBackoff
+	for range attempts - 1 {
+		delay *= 2
+		if delay >= g.cfg.MaxBackoff {
+			break
+		}
+	}
+	delay = min(delay, g.cfg.MaxBackoff)
+
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,234 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testRetryConfig = config.GatewayRetryConfig{
+	MaxAttempts:      3,
+	BaseBackoff:      time.Millisecond,
+	MaxBackoff:       5 * time.Millisecond,
+	BreakerThreshold: 5,
+	BreakerCooldown:  time.Hour,
+}
+
+func TestRetryingGatewayClient_CreatePayment(t *testing.T) {
+	request := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: usd(10050)}
+	approved := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123", Status: internal.PaymentStatusSuccess}
+	unavailable := fmt.Errorf("%w: status 503", internal.ErrGatewayUnavailable)
+
+	tests := []struct {
+		name             string
+		ctx              func() (context.Context, context.CancelFunc)
+		setupMocks       func(*mockGatewayClient)
+		expectedResponse internal.GatewayResponse
+		expectedError    error
+		expectedCalls    int
+	}{
+		{
+			name: "unavailable gateway is retried until it answers",
+			setupMocks: func(gc *mockGatewayClient) {
+				gc.On("CreatePayment", mock.Anything, "payment-123", request).Return(internal.GatewayResponse{Gateway: "mock"}, unavailable).Twice()
+				gc.On("CreatePayment", mock.Anything, "payment-123", request).Return(approved, nil).Once()
+			},
+			expectedResponse: approved,
+			expectedCalls:    3,
+		},
+		{
+			name: "declines are not retried",
+			setupMocks: func(gc *mockGatewayClient) {
+				gc.On("CreatePayment", mock.Anything, "payment-123", request).
+					Return(internal.GatewayResponse{Gateway: "mock", ResponseCode: "insufficient_funds"}, internal.ErrGatewayDeclined).Once()
+			},
+			expectedResponse: internal.GatewayResponse{Gateway: "mock", ResponseCode: "insufficient_funds"},
+			expectedError:    internal.ErrGatewayDeclined,
+			expectedCalls:    1,
+		},
+		{
+			name: "gives up after max attempts",
+			setupMocks: func(gc *mockGatewayClient) {
+				gc.On("CreatePayment", mock.Anything, "payment-123", request).Return(internal.GatewayResponse{Gateway: "mock"}, unavailable)
+			},
+			expectedResponse: internal.GatewayResponse{Gateway: "mock"},
+			expectedError:    internal.ErrGatewayUnavailable,
+			expectedCalls:    3,
+		},
+		{
+			name: "no retry when the deadline leaves no time for it",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 500*time.Microsecond)
+			},
+			setupMocks: func(gc *mockGatewayClient) {
+				gc.On("CreatePayment", mock.Anything, "payment-123", request).Return(internal.GatewayResponse{Gateway: "mock"}, unavailable)
+			},
+			expectedResponse: internal.GatewayResponse{Gateway: "mock"},
+			expectedError:    internal.ErrGatewayUnavailable,
+			expectedCalls:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+			gatewayClient := new(mockGatewayClient)
+			tt.setupMocks(gatewayClient)
+
+			response, err := services.NewRetryingGatewayClient(gatewayClient, testRetryConfig).CreatePayment(ctx, "payment-123", request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedResponse, response)
+			gatewayClient.AssertNumberOfCalls(t, "CreatePayment", tt.expectedCalls)
+		})
+	}
+}
+
+func TestRetryingGatewayClient_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	transaction := internal.Transaction{ID: "payment-123"}
+	cfg := testRetryConfig
+	cfg.MaxAttempts = 1
+	cfg.BreakerThreshold = 2
+	cfg.BreakerCooldown = 20 * time.Millisecond
+
+	gatewayClient := new(mockGatewayClient)
+	gatewayClient.On("GetPaymentStatus", mock.Anything, transaction).Return(internal.GatewayResponse{}, internal.ErrGatewayUnavailable).Times(3)
+	gatewayClient.On("GetPaymentStatus", mock.Anything, transaction).Return(internal.GatewayResponse{Status: internal.PaymentStatusSuccess}, nil).Once()
+	client := services.NewRetryingGatewayClient(gatewayClient, cfg)
+	assert.Equal(t, internal.CircuitBreakerClosed, client.CircuitBreakerStatus().State)
+
+	// Opens after the threshold and fails fast without calling the gateway
+	for range 2 {
+		_, err := client.GetPaymentStatus(ctx, transaction)
+		assert.ErrorIs(t, err, internal.ErrGatewayUnavailable)
+	}
+	status := client.CircuitBreakerStatus()
+	assert.Equal(t, internal.CircuitBreakerOpen, status.State)
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.NotNil(t, status.OpenedAt)
+	_, err := client.GetPaymentStatus(ctx, transaction)
+	assert.ErrorIs(t, err, internal.ErrGatewayCircuitOpen)
+	gatewayClient.AssertNumberOfCalls(t, "GetPaymentStatus", 2)
+
+	// A failed trial call opens it again
+	time.Sleep(cfg.BreakerCooldown)
+	assert.Equal(t, internal.CircuitBreakerHalfOpen, client.CircuitBreakerStatus().State)
+	_, err = client.GetPaymentStatus(ctx, transaction)
+	assert.ErrorIs(t, err, internal.ErrGatewayUnavailable)
+	assert.Equal(t, internal.CircuitBreakerOpen, client.CircuitBreakerStatus().State)
+
+	// A successful trial call closes it
+	time.Sleep(cfg.BreakerCooldown)
+	response, err := client.GetPaymentStatus(ctx, transaction)
+	assert.NoError(t, err)
+	assert.Equal(t, internal.PaymentStatusSuccess, response.Status)
+	assert.Equal(t, internal.CircuitBreakerStatus{State: internal.CircuitBreakerClosed}, client.CircuitBreakerStatus())
+	gatewayClient.AssertNumberOfCalls(t, "GetPaymentStatus", 4)
+}
+
+func TestRetryingGatewayClient_AbandonedCallsDoNotOpenBreaker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg := testRetryConfig
+	cfg.BreakerThreshold = 1
+
+	gatewayClient := new(mockGatewayClient)
+	gatewayClient.On("Refund", mock.Anything, "refund-123", mock.Anything).
+		Return(internal.GatewayResponse{}, errors.Join(internal.ErrGatewayUnavailable, context.Canceled))
+	client := services.NewRetryingGatewayClient(gatewayClient, cfg)
+
+	_, err := client.Refund(ctx, "refund-123", internal.RefundRequest{UserID: 1234, TransactionID: "payment-123", Amount: usd(100)})
+
+	assert.ErrorIs(t, err, internal.ErrGatewayUnavailable)
+	assert.Equal(t, internal.CircuitBreakerClosed, client.CircuitBreakerStatus().State)
+	gatewayClient.AssertNumberOfCalls(t, "Refund", 1)
+}
+
+func TestRetryingGatewayClient_UnreachedRetryDoesNotHideEarlierAttempt(t *testing.T) {
+	request := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: usd(10050)}
+	timedOut := fmt.Errorf("%w: %w", internal.ErrGatewayUnavailable, context.DeadlineExceeded)
+	refused := fmt.Errorf("%w: %w: connection refused", internal.ErrGatewayUnavailable, internal.ErrGatewayNotReached)
+
+	gatewayClient := new(mockGatewayClient)
+	gatewayClient.On("CreatePayment", mock.Anything, "payment-123", request).Return(internal.GatewayResponse{Gateway: "mock"}, timedOut).Once()
+	gatewayClient.On("CreatePayment", mock.Anything, "payment-123", request).Return(internal.GatewayResponse{Gateway: "mock"}, refused)
+
+	_, err := services.NewRetryingGatewayClient(gatewayClient, testRetryConfig).CreatePayment(context.Background(), "payment-123", request)
+
+	// The first attempt may have charged the payment
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, internal.ErrGatewayNotReached)
+	gatewayClient.AssertNumberOfCalls(t, "CreatePayment", 3)
+}
+
+func TestRetryingGatewayClient_OnlyTheProbeEndsHalfOpenTrial(t *testing.T) {
+	cfg := testRetryConfig
+	cfg.MaxAttempts = 1
+	cfg.BreakerThreshold = 1
+	cfg.BreakerCooldown = 20 * time.Millisecond
+	stale := internal.Transaction{ID: "payment-stale"}
+	failing := internal.Transaction{ID: "payment-failing"}
+	probe := internal.Transaction{ID: "payment-probe"}
+	other := internal.Transaction{ID: "payment-other"}
+
+	staleStarted, probeStarted, releaseProbe := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	gatewayClient := new(mockGatewayClient)
+	gatewayClient.On("GetPaymentStatus", mock.Anything, stale).Run(func(args mock.Arguments) {
+		close(staleStarted)
+		<-args.Get(0).(context.Context).Done()
+	}).Return(internal.GatewayResponse{}, errors.Join(internal.ErrGatewayUnavailable, context.Canceled))
+	gatewayClient.On("GetPaymentStatus", mock.Anything, failing).Return(internal.GatewayResponse{}, internal.ErrGatewayUnavailable)
+	gatewayClient.On("GetPaymentStatus", mock.Anything, probe).Run(func(args mock.Arguments) {
+		close(probeStarted)
+		<-releaseProbe
+	}).Return(internal.GatewayResponse{Status: internal.PaymentStatusSuccess}, nil)
+	client := services.NewRetryingGatewayClient(gatewayClient, cfg)
+
+	// A call started while closed is still running when the breaker opens
+	staleCtx, cancelStale := context.WithCancel(context.Background())
+	staleDone := make(chan struct{})
+	go func() {
+		defer close(staleDone)
+		_, _ = client.GetPaymentStatus(staleCtx, stale)
+	}()
+	<-staleStarted
+	_, err := client.GetPaymentStatus(context.Background(), failing)
+	assert.ErrorIs(t, err, internal.ErrGatewayUnavailable)
+	assert.Equal(t, internal.CircuitBreakerOpen, client.CircuitBreakerStatus().State)
+
+	time.Sleep(cfg.BreakerCooldown)
+	probeDone := make(chan struct{})
+	go func() {
+		defer close(probeDone)
+		_, _ = client.GetPaymentStatus(context.Background(), probe)
+	}()
+	<-probeStarted
+
+	// The stale call ending does not let a second trial call through
+	cancelStale()
+	<-staleDone
+	_, err = client.GetPaymentStatus(context.Background(), other)
+	assert.ErrorIs(t, err, internal.ErrGatewayCircuitOpen)
+
+	close(releaseProbe)
+	<-probeDone
+	assert.Equal(t, internal.CircuitBreakerClosed, client.CircuitBreakerStatus().State)
+	gatewayClient.AssertNotCalled(t, "GetPaymentStatus", mock.Anything, other)
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+var (
+	ErrCreatingHold = errors.New("error creating hold")
+	ErrVoidingHold  = errors.New("error voiding hold")
+)
+
+type HoldStorage interface {
+	CreateHold(ctx context.Context, paymentRequest internal.PaymentRequest, expiresAt time.Time) (internal.Hold, error)
+	VoidHold(ctx context.Context, userID uint64, holdID string) (internal.Hold, error)
+	ReleaseExpiredHolds(ctx context.Context, limit int) ([]internal.Hold, error)
+}
+
+// HoldService places and voids authorization holds, and releases the ones
+// that expire without being captured. Captures go through PaymentService
+// since they charge the gateway.
+type HoldService struct {
+	storage HoldStorage
+	cfg     config.HoldConfig
+}
+
+func NewHoldService(storage HoldStorage, cfg config.HoldConfig) *HoldService {
+	return &HoldService{
+		storage: storage,
+		cfg:     cfg,
+	}
+}
+
+// AuthorizePayment reserves the amount of the request without charging it
+func (s *HoldService) AuthorizePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (internal.Hold, error) {
+	hold, err := s.storage.CreateHold(ctx, paymentRequest, time.Now().Add(s.cfg.Expiry))
+	if errors.Is(err, ErrNotEnoughBalance) {
+		return internal.Hold{}, ErrNotEnoughBalance
+	}
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("%w: %s", ErrCreatingHold, err.Error())
+	}
+
+	return hold, nil
+}
+
+// VoidHold releases an active hold without charging it
+func (s *HoldService) VoidHold(ctx context.Context, userID uint64, holdID string) (internal.Hold, error) {
+	hold, err := s.storage.VoidHold(ctx, userID, holdID)
+	if errors.Is(err, internal.ErrHoldNotFound) || errors.Is(err, internal.ErrHoldNotActive) {
+		return internal.Hold{}, err
+	}
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("%w: %s", ErrVoidingHold, err.Error())
+	}
+
+	return hold, nil
+}
+
+// Run releases expired holds every sweep interval until ctx is cancelled
+func (s *HoldService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ReleaseExpired(ctx)
+		}
+	}
+}
+
+// ReleaseExpired releases one batch of expired holds
+func (s *HoldService) ReleaseExpired(ctx context.Context) {
+	holds, err := s.storage.ReleaseExpiredHolds(ctx, s.cfg.BatchSize)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not release expired holds", "error", err.Error())
+		return
+	}
+
+	for _, hold := range holds {
+		slog.InfoContext(ctx, "Released expired hold",
+			"hold_id", hold.ID,
+			"user_id", hold.UserID,
+			"amount", hold.Amount.String(),
+			"expires_at", hold.ExpiresAt,
+		)
+	}
+}
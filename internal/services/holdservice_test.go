@@ -0,0 +1,165 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockHoldStorage struct {
+	mock.Mock
+}
+
+func (m *mockHoldStorage) CreateHold(ctx context.Context, paymentRequest internal.PaymentRequest, expiresAt time.Time) (internal.Hold, error) {
+	args := m.Called(ctx, paymentRequest, expiresAt)
+	return args.Get(0).(internal.Hold), args.Error(1)
+}
+
+func (m *mockHoldStorage) VoidHold(ctx context.Context, userID uint64, holdID string) (internal.Hold, error) {
+	args := m.Called(ctx, userID, holdID)
+	return args.Get(0).(internal.Hold), args.Error(1)
+}
+
+func (m *mockHoldStorage) ReleaseExpiredHolds(ctx context.Context, limit int) ([]internal.Hold, error) {
+	args := m.Called(ctx, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.Hold), args.Error(1)
+}
+
+var testHoldConfig = config.HoldConfig{
+	Expiry:        time.Hour,
+	SweepInterval: time.Second,
+	BatchSize:     10,
+}
+
+func TestHoldService_AuthorizePayment(t *testing.T) {
+	request := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: usd(10050)}
+	hold := internal.Hold{ID: "hold-123", UserID: 1234, Method: "card", Amount: usd(10050), Status: internal.HoldStatusActive}
+	expiresInAnHour := mock.MatchedBy(func(expiresAt time.Time) bool {
+		delay := time.Until(expiresAt)
+		return delay > 59*time.Minute && delay <= time.Hour
+	})
+
+	tests := []struct {
+		name          string
+		storageHold   internal.Hold
+		storageError  error
+		expectedHold  internal.Hold
+		expectedError error
+	}{
+		{
+			name:         "hold expires after the configured expiry",
+			storageHold:  hold,
+			expectedHold: hold,
+		},
+		{
+			name:          "insufficient available balance",
+			storageError:  internal.ErrNotEnoughBalance,
+			expectedError: services.ErrNotEnoughBalance,
+		},
+		{
+			name:          "storage error",
+			storageError:  errors.New("database error"),
+			expectedError: services.ErrCreatingHold,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockHoldStorage)
+			mockStorage.On("CreateHold", mock.Anything, request, expiresInAnHour).Return(tt.storageHold, tt.storageError)
+
+			service := services.NewHoldService(mockStorage, testHoldConfig)
+			result, err := service.AuthorizePayment(context.Background(), request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedHold, result)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHoldService_VoidHold(t *testing.T) {
+	voided := internal.Hold{ID: "hold-123", UserID: 1234, Amount: usd(10050), Status: internal.HoldStatusVoided}
+
+	tests := []struct {
+		name          string
+		storageHold   internal.Hold
+		storageError  error
+		expectedError error
+	}{
+		{
+			name:        "active hold is voided",
+			storageHold: voided,
+		},
+		{
+			name:          "hold of another user is not found",
+			storageError:  internal.ErrHoldNotFound,
+			expectedError: internal.ErrHoldNotFound,
+		},
+		{
+			name:          "captured hold cannot be voided",
+			storageError:  internal.ErrHoldNotActive,
+			expectedError: internal.ErrHoldNotActive,
+		},
+		{
+			name:          "storage error",
+			storageError:  errors.New("database error"),
+			expectedError: services.ErrVoidingHold,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockHoldStorage)
+			mockStorage.On("VoidHold", mock.Anything, uint64(1234), "hold-123").Return(tt.storageHold, tt.storageError)
+
+			service := services.NewHoldService(mockStorage, testHoldConfig)
+			result, err := service.VoidHold(context.Background(), 1234, "hold-123")
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.storageHold, result)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHoldService_ReleaseExpired(t *testing.T) {
+	t.Run("releases a batch of expired holds", func(t *testing.T) {
+		mockStorage := new(mockHoldStorage)
+		mockStorage.On("ReleaseExpiredHolds", mock.Anything, 10).
+			Return([]internal.Hold{{ID: "hold-123", Status: internal.HoldStatusExpired}}, nil)
+
+		service := services.NewHoldService(mockStorage, testHoldConfig)
+		service.ReleaseExpired(context.Background())
+
+		mockStorage.AssertExpectations(t)
+	})
+
+	t.Run("storage error skips the batch", func(t *testing.T) {
+		mockStorage := new(mockHoldStorage)
+		mockStorage.On("ReleaseExpiredHolds", mock.Anything, 10).Return(nil, errors.New("database error"))
+
+		service := services.NewHoldService(mockStorage, testHoldConfig)
+		service.ReleaseExpired(context.Background())
+
+		mockStorage.AssertExpectations(t)
+	})
+}
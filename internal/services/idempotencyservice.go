@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+var (
+	ErrIdempotencyStorage = errors.New("error storing idempotency key")
+)
+
+type IdempotencyStorage interface {
+	CreateIdempotencyKey(ctx context.Context, userID uint64, key string, requestHash string, lease time.Duration) (internal.IdempotencyKey, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, userID uint64, key string, statusCode int, responseBody []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID uint64, key string) error
+}
+
+type IdempotencyService struct {
+	storage IdempotencyStorage
+	cfg     config.IdempotencyConfig
+}
+
+func NewIdempotencyService(storage IdempotencyStorage, cfg config.IdempotencyConfig) *IdempotencyService {
+	return &IdempotencyService{
+		storage: storage,
+		cfg:     cfg,
+	}
+}
+
+// Begin reserves the key for a request. It returns nil when the request must
+// be processed, or the stored record when a completed response should be
+// replayed instead. A key left in progress for longer than the configured
+// lease is handed over to the new request, unless the request it was left by
+// already created a transaction or hold, which running it again would
+// duplicate.
+func (s *IdempotencyService) Begin(ctx context.Context, userID uint64, key string, requestHash string) (*internal.IdempotencyKey, error) {
+	idempotencyKey, created, err := s.storage.CreateIdempotencyKey(ctx, userID, key, requestHash, s.cfg.Lease)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyStorage, err.Error())
+	}
+
+	if created {
+		return nil, nil
+	}
+
+	if idempotencyKey.RequestHash != requestHash {
+		return nil, internal.ErrIdempotencyKeyReused
+	}
+
+	if !idempotencyKey.Completed && idempotencyKey.ResourceID != "" {
+		return nil, fmt.Errorf("%w: it created %s", internal.ErrIdempotencyKeyInProgress, idempotencyKey.ResourceID)
+	}
+	if !idempotencyKey.Completed {
+		return nil, internal.ErrIdempotencyKeyInProgress
+	}
+
+	return &idempotencyKey, nil
+}
+
+// Complete stores the response so later retries with the same key replay it
+func (s *IdempotencyService) Complete(ctx context.Context, userID uint64, key string, statusCode int, responseBody []byte) error {
+	if err := s.storage.CompleteIdempotencyKey(ctx, userID, key, statusCode, responseBody); err != nil {
+		return fmt.Errorf("%w: %s", ErrIdempotencyStorage, err.Error())
+	}
+
+	return nil
+}
+
+// Release frees a key whose request failed before changing anything, so a
+// retry with the same key runs again instead of replaying the failure
+func (s *IdempotencyService) Release(ctx context.Context, userID uint64, key string) error {
+	if err := s.storage.ReleaseIdempotencyKey(ctx, userID, key); err != nil {
+		return fmt.Errorf("%w: %s", ErrIdempotencyStorage, err.Error())
+	}
+
+	return nil
+}
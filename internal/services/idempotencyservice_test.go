@@ -0,0 +1,163 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockIdempotencyStorage struct {
+	mock.Mock
+}
+
+func (m *mockIdempotencyStorage) CreateIdempotencyKey(ctx context.Context, userID uint64, key string, requestHash string, lease time.Duration) (internal.IdempotencyKey, bool, error) {
+	args := m.Called(ctx, userID, key, requestHash, lease)
+	return args.Get(0).(internal.IdempotencyKey), args.Bool(1), args.Error(2)
+}
+
+func (m *mockIdempotencyStorage) CompleteIdempotencyKey(ctx context.Context, userID uint64, key string, statusCode int, responseBody []byte) error {
+	args := m.Called(ctx, userID, key, statusCode, responseBody)
+	return args.Error(0)
+}
+
+func (m *mockIdempotencyStorage) ReleaseIdempotencyKey(ctx context.Context, userID uint64, key string) error {
+	args := m.Called(ctx, userID, key)
+	return args.Error(0)
+}
+
+var testIdempotencyConfig = config.IdempotencyConfig{Lease: time.Minute}
+
+func TestIdempotencyService_Begin(t *testing.T) {
+	completedKey := internal.IdempotencyKey{
+		UserID:       1234,
+		Key:          "key-1",
+		RequestHash:  "hash-1",
+		StatusCode:   200,
+		ResponseBody: []byte(`{"status":"success","transaction_id":"payment-123"}`),
+		Completed:    true,
+	}
+
+	tests := []struct {
+		name          string
+		requestHash   string
+		setupMock     func(*mockIdempotencyStorage)
+		expected      *internal.IdempotencyKey
+		expectedError error
+	}{
+		{
+			name:        "new key is processed",
+			requestHash: "hash-1",
+			setupMock: func(m *mockIdempotencyStorage) {
+				m.On("CreateIdempotencyKey", mock.Anything, uint64(1234), "key-1", "hash-1", time.Minute).
+					Return(internal.IdempotencyKey{UserID: 1234, Key: "key-1", RequestHash: "hash-1"}, true, nil)
+			},
+		},
+		{
+			name:        "completed key with same body is replayed",
+			requestHash: "hash-1",
+			setupMock: func(m *mockIdempotencyStorage) {
+				m.On("CreateIdempotencyKey", mock.Anything, uint64(1234), "key-1", "hash-1", time.Minute).
+					Return(completedKey, false, nil)
+			},
+			expected: &completedKey,
+		},
+		{
+			name:        "key reused with a different body",
+			requestHash: "hash-2",
+			setupMock: func(m *mockIdempotencyStorage) {
+				m.On("CreateIdempotencyKey", mock.Anything, uint64(1234), "key-1", "hash-2", time.Minute).
+					Return(completedKey, false, nil)
+			},
+			expectedError: internal.ErrIdempotencyKeyReused,
+		},
+		{
+			name:        "key still in progress",
+			requestHash: "hash-1",
+			setupMock: func(m *mockIdempotencyStorage) {
+				m.On("CreateIdempotencyKey", mock.Anything, uint64(1234), "key-1", "hash-1", time.Minute).
+					Return(internal.IdempotencyKey{UserID: 1234, Key: "key-1", RequestHash: "hash-1"}, false, nil)
+			},
+			expectedError: internal.ErrIdempotencyKeyInProgress,
+		},
+		{
+			name:        "key left in progress after creating a transaction",
+			requestHash: "hash-1",
+			setupMock: func(m *mockIdempotencyStorage) {
+				m.On("CreateIdempotencyKey", mock.Anything, uint64(1234), "key-1", "hash-1", time.Minute).
+					Return(internal.IdempotencyKey{UserID: 1234, Key: "key-1", RequestHash: "hash-1", ResourceID: "payment-123"}, false, nil)
+			},
+			expectedError: internal.ErrIdempotencyKeyInProgress,
+		},
+		{
+			name:        "storage error",
+			requestHash: "hash-1",
+			setupMock: func(m *mockIdempotencyStorage) {
+				m.On("CreateIdempotencyKey", mock.Anything, uint64(1234), "key-1", "hash-1", time.Minute).
+					Return(internal.IdempotencyKey{}, false, errors.New("database error"))
+			},
+			expectedError: services.ErrIdempotencyStorage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockIdempotencyStorage)
+			tt.setupMock(mockStorage)
+
+			service := services.NewIdempotencyService(mockStorage, testIdempotencyConfig)
+			stored, err := service.Begin(context.Background(), 1234, "key-1", tt.requestHash)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.Nil(t, stored)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, stored)
+			}
+
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIdempotencyService_Release(t *testing.T) {
+	tests := []struct {
+		name          string
+		storageError  error
+		expectedError error
+	}{
+		{
+			name: "key is released",
+		},
+		{
+			name:          "storage error",
+			storageError:  errors.New("database error"),
+			expectedError: services.ErrIdempotencyStorage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockIdempotencyStorage)
+			mockStorage.On("ReleaseIdempotencyKey", mock.Anything, uint64(1234), "key-1").Return(tt.storageError)
+
+			service := services.NewIdempotencyService(mockStorage, testIdempotencyConfig)
+			err := service.Release(context.Background(), 1234, "key-1")
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
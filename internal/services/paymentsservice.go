@@ -4,26 +4,51 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
 )
 
 var (
-	ErrNotEnoughBalance       = errors.New("not enough balance")
-	ErrGettingBalance         = errors.New("error getting balance")
+	ErrNotEnoughBalance       = internal.ErrNotEnoughBalance
 	ErrPaymentGateway         = errors.New("payment gateway failed")
 	ErrCreatingPaymentRequest = errors.New("error creating payment request")
 	ErrUpdatingPaymentRequest = errors.New("error updating payment request")
+	ErrDepositGateway         = errors.New("deposit gateway failed")
+	ErrCreatingDepositRequest = errors.New("error creating deposit request")
+	ErrUpdatingDepositRequest = errors.New("error updating deposit request")
+	ErrRefundGateway          = errors.New("refund gateway failed")
+	ErrCreatingRefundRequest  = errors.New("error creating refund request")
+	ErrUpdatingRefundRequest  = errors.New("error updating refund request")
+	ErrCapturingHold          = errors.New("error capturing hold")
 )
 
+// TransactionUpdater records the final status of pending payments, deposits
+// and refunds
+type TransactionUpdater interface {
+	UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error
+	UpdateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error
+	UpdateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error
+}
+
 type PaymentStorage interface {
-	GetBalance(ctx context.Context, userID uint64) (float64, error)
+	TransactionUpdater
 	CreatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error)
-	UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string) error
+	CreateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest) (string, error)
+	CreateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest) (internal.Transaction, error)
+	CaptureHold(ctx context.Context, captureRequest internal.CaptureRequest) (internal.Hold, error)
+	CreatePaymentContingency(ctx context.Context, contingency internal.PaymentContingency) error
+	SetPendingGatewayResponse(ctx context.Context, transactionID string, gatewayResponse internal.GatewayResponse) error
 }
 
+// GatewayClient sends payments to a payment gateway. Our transaction ID is
+// sent along so the gateway can deduplicate retries and later report the
+// outcome of a payment whose response we never recorded.
 type GatewayClient interface {
-	CreatePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error)
+	CreatePayment(ctx context.Context, transactionID string, paymentRequest internal.PaymentRequest) (internal.GatewayResponse, error)
+	CreateDeposit(ctx context.Context, transactionID string, depositRequest internal.DepositRequest) (internal.GatewayResponse, error)
+	Refund(ctx context.Context, transactionID string, refundRequest internal.RefundRequest) (internal.GatewayResponse, error)
+	GetPaymentStatus(ctx context.Context, transaction internal.Transaction) (internal.GatewayResponse, error)
 }
 
 type PaymentService struct {
@@ -38,39 +63,289 @@ func NewPaymentService(storage PaymentStorage, gatewayClient GatewayClient) *Pay
 	}
 }
 
-func (s *PaymentService) CreatePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error) {
-	balance, err := s.storage.GetBalance(ctx, paymentRequest.UserID)
+// CreatePayment charges a wallet, returning the ID of the transaction and its
+// status, pending when the gateway has not decided yet
+func (s *PaymentService) CreatePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (string, string, error) {
+	transactionID, err := s.createPaymentRequest(ctx, paymentRequest)
 	if err != nil {
-		return "", fmt.Errorf("%w: %s", ErrGettingBalance, err.Error())
+		return "", "", err
 	}
 
-	if balance < paymentRequest.Amount {
-		return "", ErrNotEnoughBalance
+	status, err := s.charge(ctx, paymentRequest, transactionID)
+	if err != nil {
+		return "", "", err
 	}
 
-	// Create payment request in internal storage
+	return transactionID, status, nil
+}
+
+// createPaymentRequest records a pending payment, the balance is checked and
+// debited atomically by the storage
+func (s *PaymentService) createPaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error) {
 	transactionID, err := s.storage.CreatePaymentRequest(ctx, paymentRequest)
+	if errors.Is(err, ErrNotEnoughBalance) {
+		return "", ErrNotEnoughBalance
+	}
 	if err != nil {
 		return "", fmt.Errorf("%w: %s", ErrCreatingPaymentRequest, err.Error())
 	}
 
+	return transactionID, nil
+}
+
+// CapturePayment charges part or all of an active hold. The storage turns the
+// hold into a pending payment, which is then sent to the gateway like any
+// other payment.
+func (s *PaymentService) CapturePayment(ctx context.Context, captureRequest internal.CaptureRequest) (internal.Hold, error) {
+	hold, err := s.storage.CaptureHold(ctx, captureRequest)
+	if errors.Is(err, internal.ErrHoldNotFound) ||
+		errors.Is(err, internal.ErrHoldNotActive) ||
+		errors.Is(err, internal.ErrCaptureExceedsHold) ||
+		errors.Is(err, internal.ErrCurrencyMismatch) {
+		return internal.Hold{}, err
+	}
+	if err != nil {
+		return internal.Hold{}, fmt.Errorf("%w: %s", ErrCapturingHold, err.Error())
+	}
+
+	paymentRequest := internal.PaymentRequest{
+		UserID:  hold.UserID,
+		Method:  hold.Method,
+		Amount:  hold.CapturedAmount,
+		Details: hold.Details,
+	}
+	if _, err := s.charge(ctx, paymentRequest, hold.TransactionID); err != nil {
+		return internal.Hold{}, err
+	}
+
+	return hold, nil
+}
+
+// charge sends a pending payment, already debited from the wallet, to the
+// gateway and records its outcome, returning the status of the payment
+func (s *PaymentService) charge(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string) (string, error) {
 	// Send payment request to gateway
-	if _, err := s.gatewayClient.CreatePayment(ctx, paymentRequest); err != nil {
+	gatewayResponse, err := s.gatewayClient.CreatePayment(ctx, transactionID, paymentRequest)
+	if err != nil && !failedAtGateway(err) {
+		// The gateway may have charged it, the reconciler settles it later
+		slog.WarnContext(ctx, "Payment left pending after gateway error", "transaction_id", transactionID, "error", err.Error())
+		return internal.PaymentStatusPending, nil
+	}
+	if err != nil {
 		// Update transaction failed
-		errUpdate := s.storage.UpdatePaymentRequest(ctx, paymentRequest, transactionID, internal.PaymentStatusFailed)
+		errUpdate := s.storage.UpdatePaymentRequest(ctx, paymentRequest, transactionID, internal.PaymentStatusFailed, gatewayResponse)
 		if errUpdate != nil {
-			// TODO: send to contingency plan
-			return "", fmt.Errorf("%w: %s", ErrUpdatingPaymentRequest, errUpdate.Error())
+			errUpdate = s.sendToContingency(ctx, internal.TransactionTypePayment, paymentRequest, transactionID, internal.PaymentStatusFailed, gatewayResponse, errUpdate)
+			if errUpdate != nil {
+				return "", fmt.Errorf("%w: %s", ErrUpdatingPaymentRequest, errUpdate.Error())
+			}
 		}
-		return "", fmt.Errorf("%w: %s", ErrPaymentGateway, err.Error())
+		return "", fmt.Errorf("%w: %w", ErrPaymentGateway, err)
+	}
+
+	if gatewayResponse.Status == internal.PaymentStatusPending {
+		// The gateway has not decided yet, the reconciler settles it later
+		slog.InfoContext(ctx, "Payment left pending by gateway", "transaction_id", transactionID)
+		s.setPendingGatewayResponse(ctx, transactionID, gatewayResponse)
+		return internal.PaymentStatusPending, nil
 	}
 
 	// Update transaction success
-	err = s.storage.UpdatePaymentRequest(ctx, paymentRequest, transactionID, internal.PaymentStatusSuccess)
+	err = s.storage.UpdatePaymentRequest(ctx, paymentRequest, transactionID, internal.PaymentStatusSuccess, gatewayResponse)
 	if err != nil {
-		// TODO: send to contingency plan
-		return "", fmt.Errorf("%w: %s", ErrUpdatingPaymentRequest, err.Error())
+		// The gateway already charged the payment, once the contingency is
+		// recorded the status update is guaranteed to be retried
+		err = s.sendToContingency(ctx, internal.TransactionTypePayment, paymentRequest, transactionID, internal.PaymentStatusSuccess, gatewayResponse, err)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrUpdatingPaymentRequest, err.Error())
+		}
 	}
 
-	return transactionID, nil
+	return internal.PaymentStatusSuccess, nil
+}
+
+// CreateDeposit collects funds through the gateway and credits them to the
+// wallet, returning the ID of the transaction and its status
+func (s *PaymentService) CreateDeposit(ctx context.Context, depositRequest internal.DepositRequest) (string, string, error) {
+	transactionID, err := s.storage.CreateDepositRequest(ctx, depositRequest)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrCreatingDepositRequest, err.Error())
+	}
+
+	// Collect the funds through the gateway
+	gatewayResponse, err := s.gatewayClient.CreateDeposit(ctx, transactionID, depositRequest)
+	if err != nil && !failedAtGateway(err) {
+		slog.WarnContext(ctx, "Deposit left pending after gateway error", "transaction_id", transactionID, "error", err.Error())
+		return transactionID, internal.PaymentStatusPending, nil
+	}
+	if err != nil {
+		errUpdate := s.storage.UpdateDepositRequest(ctx, depositRequest, transactionID, internal.PaymentStatusFailed, gatewayResponse)
+		if errUpdate != nil {
+			errUpdate = s.sendToContingency(ctx, internal.TransactionTypeDeposit, internal.PaymentRequest(depositRequest), transactionID, internal.PaymentStatusFailed, gatewayResponse, errUpdate)
+			if errUpdate != nil {
+				return "", "", fmt.Errorf("%w: %s", ErrUpdatingDepositRequest, errUpdate.Error())
+			}
+		}
+		return "", "", fmt.Errorf("%w: %w", ErrDepositGateway, err)
+	}
+
+	if gatewayResponse.Status == internal.PaymentStatusPending {
+		slog.InfoContext(ctx, "Deposit left pending by gateway", "transaction_id", transactionID)
+		s.setPendingGatewayResponse(ctx, transactionID, gatewayResponse)
+		return transactionID, internal.PaymentStatusPending, nil
+	}
+
+	// Credit the wallet
+	err = s.storage.UpdateDepositRequest(ctx, depositRequest, transactionID, internal.PaymentStatusSuccess, gatewayResponse)
+	if err != nil {
+		// The gateway already collected the funds, the contingency makes sure
+		// they are eventually credited
+		err = s.sendToContingency(ctx, internal.TransactionTypeDeposit, internal.PaymentRequest(depositRequest), transactionID, internal.PaymentStatusSuccess, gatewayResponse, err)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %s", ErrUpdatingDepositRequest, err.Error())
+		}
+	}
+
+	return transactionID, internal.PaymentStatusSuccess, nil
+}
+
+// CreateRefund returns part or all of a successful payment to the wallet. The
+// refund is reserved by the storage before calling the gateway so concurrent
+// refunds cannot exceed the payment.
+func (s *PaymentService) CreateRefund(ctx context.Context, refundRequest internal.RefundRequest) (internal.Transaction, error) {
+	refund, err := s.storage.CreateRefundRequest(ctx, refundRequest)
+	if errors.Is(err, internal.ErrTransactionNotFound) ||
+		errors.Is(err, internal.ErrPaymentNotRefundable) ||
+		errors.Is(err, internal.ErrRefundExceedsPayment) ||
+		errors.Is(err, internal.ErrCurrencyMismatch) {
+		return internal.Transaction{}, err
+	}
+	if err != nil {
+		return internal.Transaction{}, fmt.Errorf("%w: %s", ErrCreatingRefundRequest, err.Error())
+	}
+
+	// A full refund is resolved to the amount left by the storage, which also
+	// tells the payment method and gateway so the refund reaches the gateway
+	// that charged it
+	refundRequest.Amount = refund.Amount
+	refundRequest.Method = refund.Method
+	refundRequest.Gateway = refund.GatewayName
+	contingencyRequest := internal.PaymentRequest{UserID: refundRequest.UserID, Amount: refundRequest.Amount}
+
+	gatewayResponse, err := s.gatewayClient.Refund(ctx, refund.ID, refundRequest)
+	if err != nil && !failedAtGateway(err) {
+		// The reserved amount stays reserved until the reconciler settles it
+		slog.WarnContext(ctx, "Refund left pending after gateway error", "transaction_id", refund.ID, "error", err.Error())
+		return refund, nil
+	}
+	if err != nil {
+		// Releases the reserved amount
+		errUpdate := s.storage.UpdateRefundRequest(ctx, refundRequest, refund.ID, internal.PaymentStatusFailed, gatewayResponse)
+		if errUpdate != nil {
+			errUpdate = s.sendToContingency(ctx, internal.TransactionTypeRefund, contingencyRequest, refund.ID, internal.PaymentStatusFailed, gatewayResponse, errUpdate)
+			if errUpdate != nil {
+				return internal.Transaction{}, fmt.Errorf("%w: %s", ErrUpdatingRefundRequest, errUpdate.Error())
+			}
+		}
+		return internal.Transaction{}, fmt.Errorf("%w: %w", ErrRefundGateway, err)
+	}
+
+	if gatewayResponse.Status == internal.PaymentStatusPending {
+		slog.InfoContext(ctx, "Refund left pending by gateway", "transaction_id", refund.ID)
+		s.setPendingGatewayResponse(ctx, refund.ID, gatewayResponse)
+		refund.GatewayReference = gatewayResponse.Reference
+		refund.GatewayResponseCode = gatewayResponse.ResponseCode
+		if gatewayResponse.Gateway != "" {
+			refund.GatewayName = gatewayResponse.Gateway
+		}
+		return refund, nil
+	}
+
+	// Credit the wallet
+	err = s.storage.UpdateRefundRequest(ctx, refundRequest, refund.ID, internal.PaymentStatusSuccess, gatewayResponse)
+	if err != nil {
+		err = s.sendToContingency(ctx, internal.TransactionTypeRefund, contingencyRequest, refund.ID, internal.PaymentStatusSuccess, gatewayResponse, err)
+		if err != nil {
+			return internal.Transaction{}, fmt.Errorf("%w: %s", ErrUpdatingRefundRequest, err.Error())
+		}
+	}
+
+	refund.Status = internal.PaymentStatusSuccess
+	refund.GatewayReference = gatewayResponse.Reference
+	refund.GatewayName = gatewayResponse.Gateway
+	refund.GatewayResponseCode = gatewayResponse.ResponseCode
+	return refund, nil
+}
+
+// setPendingGatewayResponse keeps the reference the gateway gave a transaction
+// it left pending. The transaction is pending either way, so a failure is only
+// logged: the reconciler still finds it by its ID.
+func (s *PaymentService) setPendingGatewayResponse(ctx context.Context, transactionID string, gatewayResponse internal.GatewayResponse) {
+	err := s.storage.SetPendingGatewayResponse(ctx, transactionID, gatewayResponse)
+	if errors.Is(err, internal.ErrTransactionNotPending) {
+		// Already settled, e.g. by a webhook that arrived first
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not record gateway response of pending transaction",
+			"transaction_id", transactionID,
+			"gateway_reference", gatewayResponse.Reference,
+			"error", err.Error(),
+		)
+	}
+}
+
+// failedAtGateway reports whether a gateway error proves the transaction was
+// not processed: the gateway declined or rejected it, the card could not be
+// resolved or the call was never made. Other errors, such as timeouts, may hide a transaction the gateway
+// processed, so it is left pending for the reconciler to ask about it.
+func failedAtGateway(err error) bool {
+	return errors.Is(err, internal.ErrGatewayDeclined) ||
+		errors.Is(err, internal.ErrGatewayRejected) ||
+		errors.Is(err, internal.ErrGatewayPaymentNotFound) ||
+		errors.Is(err, internal.ErrCardUnresolved) ||
+		neverReachedGateway(err)
+}
+
+// sendToContingency records a status update that could not be stored so the
+// ContingencyService retries it. It returns an error only if the contingency
+// itself could not be recorded.
+func (s *PaymentService) sendToContingency(ctx context.Context, transactionType string, paymentRequest internal.PaymentRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse, updateErr error) error {
+	// The update may have failed because the request was cancelled, the
+	// contingency must be stored regardless
+	ctx = context.WithoutCancel(ctx)
+	contingency := internal.PaymentContingency{
+		TransactionID:   transactionID,
+		TransactionType: transactionType,
+		PaymentRequest:  paymentRequest,
+		IntendedStatus:  status,
+		GatewayResponse: gatewayResponse,
+		LastError:       updateErr.Error(),
+	}
+	if err := s.storage.CreatePaymentContingency(ctx, contingency); err != nil {
+		slog.ErrorContext(ctx, "Could not record payment contingency",
+			"transaction_id", transactionID,
+			"status", status,
+			"update_error", updateErr.Error(),
+			"error", err.Error(),
+		)
+		return fmt.Errorf("%s: %w", updateErr.Error(), err)
+	}
+
+	slog.WarnContext(ctx, "Payment sent to contingency", "transaction_id", transactionID, "status", status, "error", updateErr.Error())
+	return nil
+}
+
+// updateTransaction records the final status of a pending payment, deposit or
+// refund
+func updateTransaction(ctx context.Context, storage TransactionUpdater, transactionType string, paymentRequest internal.PaymentRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	switch transactionType {
+	case internal.TransactionTypeDeposit:
+		return storage.UpdateDepositRequest(ctx, internal.DepositRequest(paymentRequest), transactionID, status, gatewayResponse)
+	case internal.TransactionTypeRefund:
+		refundRequest := internal.RefundRequest{UserID: paymentRequest.UserID, Amount: paymentRequest.Amount}
+		return storage.UpdateRefundRequest(ctx, refundRequest, transactionID, status, gatewayResponse)
+	default:
+		return storage.UpdatePaymentRequest(ctx, paymentRequest, transactionID, status, gatewayResponse)
+	}
 }
@@ -3,6 +3,7 @@ package services_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
@@ -16,18 +17,48 @@ type mockPaymentStorage struct {
 	mock.Mock
 }
 
-func (m *mockPaymentStorage) GetBalance(ctx context.Context, userID uint64) (float64, error) {
-	args := m.Called(ctx, userID)
-	return args.Get(0).(float64), args.Error(1)
-}
-
 func (m *mockPaymentStorage) CreatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error) {
 	args := m.Called(ctx, paymentRequest)
 	return args.String(0), args.Error(1)
 }
 
-func (m *mockPaymentStorage) UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string) error {
-	args := m.Called(ctx, paymentRequest, transactionID, status)
+func (m *mockPaymentStorage) UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, paymentRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockPaymentStorage) CreateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest) (string, error) {
+	args := m.Called(ctx, depositRequest)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockPaymentStorage) UpdateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, depositRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockPaymentStorage) CreateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest) (internal.Transaction, error) {
+	args := m.Called(ctx, refundRequest)
+	return args.Get(0).(internal.Transaction), args.Error(1)
+}
+
+func (m *mockPaymentStorage) CaptureHold(ctx context.Context, captureRequest internal.CaptureRequest) (internal.Hold, error) {
+	args := m.Called(ctx, captureRequest)
+	return args.Get(0).(internal.Hold), args.Error(1)
+}
+
+func (m *mockPaymentStorage) UpdateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, refundRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockPaymentStorage) SetPendingGatewayResponse(ctx context.Context, transactionID string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, transactionID, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockPaymentStorage) CreatePaymentContingency(ctx context.Context, contingency internal.PaymentContingency) error {
+	args := m.Called(ctx, contingency)
 	return args.Error(0)
 }
 
@@ -35,41 +66,60 @@ type mockGatewayClient struct {
 	mock.Mock
 }
 
-func (m *mockGatewayClient) CreatePayment(ctx context.Context, paymentRequest internal.PaymentRequest) (string, error) {
-	args := m.Called(ctx, paymentRequest)
-	return args.String(0), args.Error(1)
+func (m *mockGatewayClient) CreatePayment(ctx context.Context, transactionID string, paymentRequest internal.PaymentRequest) (internal.GatewayResponse, error) {
+	args := m.Called(ctx, transactionID, paymentRequest)
+	return args.Get(0).(internal.GatewayResponse), args.Error(1)
 }
 
-// ... (previous imports and mock implementations remain the same)
+func (m *mockGatewayClient) CreateDeposit(ctx context.Context, transactionID string, depositRequest internal.DepositRequest) (internal.GatewayResponse, error) {
+	args := m.Called(ctx, transactionID, depositRequest)
+	return args.Get(0).(internal.GatewayResponse), args.Error(1)
+}
+
+func (m *mockGatewayClient) Refund(ctx context.Context, transactionID string, refundRequest internal.RefundRequest) (internal.GatewayResponse, error) {
+	args := m.Called(ctx, transactionID, refundRequest)
+	return args.Get(0).(internal.GatewayResponse), args.Error(1)
+}
+
+func (m *mockGatewayClient) GetPaymentStatus(ctx context.Context, transaction internal.Transaction) (internal.GatewayResponse, error) {
+	args := m.Called(ctx, transaction)
+	return args.Get(0).(internal.GatewayResponse), args.Error(1)
+}
+
+func usd(minorUnits int64) internal.Money {
+	return internal.NewMoney(minorUnits, "USD")
+}
 
 func TestPaymentService_CreatePayment(t *testing.T) {
 	tests := []struct {
-		name          string
-		request       internal.PaymentRequest
-		setupMocks    func(*mockPaymentStorage, *mockGatewayClient)
-		expectedID    string
-		expectedError error
+		name           string
+		request        internal.PaymentRequest
+		setupMocks     func(*mockPaymentStorage, *mockGatewayClient)
+		expectedID     string
+		expectedStatus string
+		expectedError  error
 	}{
 		{
 			name: "successful payment creation",
 			request: internal.PaymentRequest{
 				UserID: 1234,
-				Amount: 100.50,
+				Amount: usd(10050),
 				Method: "card",
 			},
 			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
-				// Mock getting balance
-				ps.On("GetBalance", mock.Anything, uint64(1234)).Return(1000.0, nil)
-
 				// Mock creating payment request
 				ps.On("CreatePaymentRequest", mock.Anything, mock.MatchedBy(func(pr internal.PaymentRequest) bool {
-					return pr.UserID == 1234 && pr.Amount == 100.50
+					return pr.UserID == 1234 && pr.Amount == usd(10050)
 				})).Return("payment-123", nil)
 
 				// Mock gateway call
-				gc.On("CreatePayment", mock.Anything, mock.MatchedBy(func(pr internal.PaymentRequest) bool {
-					return pr.UserID == 1234 && pr.Amount == 100.50
-				})).Return("gateway-tx-123", nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.MatchedBy(func(pr internal.PaymentRequest) bool {
+					return pr.UserID == 1234 && pr.Amount == usd(10050)
+				})).Return(internal.GatewayResponse{
+					Gateway:      "mock",
+					Reference:    "gateway-tx-123",
+					ResponseCode: "approved",
+				}, nil)
 
 				// Mock updating payment request
 				ps.On("UpdatePaymentRequest",
@@ -79,19 +129,25 @@ func TestPaymentService_CreatePayment(t *testing.T) {
 					}),
 					"payment-123", // Should be the same as CreatePaymentRequest return value
 					internal.PaymentStatusSuccess,
+					internal.GatewayResponse{
+						Gateway:      "mock",
+						Reference:    "gateway-tx-123",
+						ResponseCode: "approved",
+					},
 				).Return(nil)
 			},
-			expectedID: "payment-123",
+			expectedID:     "payment-123",
+			expectedStatus: internal.PaymentStatusSuccess,
 		},
 		{
 			name: "insufficient balance",
 			request: internal.PaymentRequest{
 				UserID: 1234,
-				Amount: 1000.50,
+				Amount: usd(100050),
 				Method: "card",
 			},
 			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
-				ps.On("GetBalance", mock.Anything, uint64(1234)).Return(500.0, nil)
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("", internal.ErrNotEnoughBalance)
 			},
 			expectedError: services.ErrNotEnoughBalance,
 		},
@@ -99,22 +155,156 @@ func TestPaymentService_CreatePayment(t *testing.T) {
 			name: "gateway error",
 			request: internal.PaymentRequest{
 				UserID: 1234,
-				Amount: 100.50,
+				Amount: usd(10050),
 				Method: "card",
 			},
 			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
-				ps.On("GetBalance", mock.Anything, uint64(1234)).Return(1000.0, nil)
 				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("payment-123", nil)
-				gc.On("CreatePayment", mock.Anything, mock.Anything).Return("", errors.New("gateway error"))
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{Gateway: "mock", ResponseCode: "insufficient_funds"}, fmt.Errorf("%w: insufficient_funds", internal.ErrGatewayDeclined))
 				ps.On("UpdatePaymentRequest",
 					mock.Anything,
 					mock.Anything,
 					"payment-123",
 					internal.PaymentStatusFailed,
+					internal.GatewayResponse{Gateway: "mock", ResponseCode: "insufficient_funds"},
 				).Return(nil)
 			},
 			expectedError: services.ErrPaymentGateway,
 		},
+		{
+			name: "pending gateway response is left for the reconciler",
+			request: internal.PaymentRequest{
+				UserID: 1234,
+				Amount: usd(10050),
+				Method: "card",
+			},
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("payment-123", nil)
+				pending := internal.GatewayResponse{Gateway: "stub", Reference: "gateway-tx-123", Status: internal.PaymentStatusPending}
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.Anything).Return(pending, nil)
+				ps.On("SetPendingGatewayResponse", mock.Anything, "payment-123", pending).Return(nil)
+			},
+			expectedID:     "payment-123",
+			expectedStatus: internal.PaymentStatusPending,
+		},
+		{
+			name: "storage error creating payment request",
+			request: internal.PaymentRequest{
+				UserID: 1234,
+				Amount: usd(10050),
+				Method: "card",
+			},
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("", errors.New("database error"))
+			},
+			expectedError: services.ErrCreatingPaymentRequest,
+		},
+		{
+			name: "success update failure is sent to contingency",
+			request: internal.PaymentRequest{
+				UserID: 1234,
+				Amount: usd(10050),
+				Method: "card",
+			},
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("payment-123", nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123"}, nil)
+				ps.On("UpdatePaymentRequest", mock.Anything, mock.Anything, "payment-123", internal.PaymentStatusSuccess, mock.Anything).
+					Return(errors.New("database error"))
+				ps.On("CreatePaymentContingency", mock.Anything, mock.MatchedBy(func(pc internal.PaymentContingency) bool {
+					return pc.TransactionID == "payment-123" &&
+						pc.TransactionType == internal.TransactionTypePayment &&
+						pc.IntendedStatus == internal.PaymentStatusSuccess &&
+						pc.GatewayResponse.Reference == "gateway-tx-123" &&
+						pc.LastError == "database error"
+				})).Return(nil)
+			},
+			expectedID:     "payment-123",
+			expectedStatus: internal.PaymentStatusSuccess,
+		},
+		{
+			name: "gateway timeout leaves the payment pending",
+			request: internal.PaymentRequest{
+				UserID: 1234,
+				Amount: usd(10050),
+				Method: "card",
+			},
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("payment-123", nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{Gateway: "mock"}, fmt.Errorf("%w: timed out", internal.ErrGatewayUnavailable))
+			},
+			expectedID:     "payment-123",
+			expectedStatus: internal.PaymentStatusPending,
+		},
+		{
+			name: "unreadable gateway response leaves the payment pending",
+			request: internal.PaymentRequest{
+				UserID: 1234,
+				Amount: usd(10050),
+				Method: "card",
+			},
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("payment-123", nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{Gateway: "mock"}, internal.ErrGatewayInvalidResponse)
+			},
+			expectedID:     "payment-123",
+			expectedStatus: internal.PaymentStatusPending,
+		},
+		{
+			name: "open circuit breaker fails the payment",
+			request: internal.PaymentRequest{
+				UserID: 1234,
+				Amount: usd(10050),
+				Method: "card",
+			},
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("payment-123", nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{}, internal.ErrGatewayCircuitOpen)
+				ps.On("UpdatePaymentRequest", mock.Anything, mock.Anything, "payment-123", internal.PaymentStatusFailed, internal.GatewayResponse{}).Return(nil)
+			},
+			expectedError: services.ErrPaymentGateway,
+		},
+		{
+			name: "failed update is sent to contingency after gateway error",
+			request: internal.PaymentRequest{
+				UserID: 1234,
+				Amount: usd(10050),
+				Method: "card",
+			},
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("payment-123", nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{}, internal.ErrGatewayRejected)
+				ps.On("UpdatePaymentRequest", mock.Anything, mock.Anything, "payment-123", internal.PaymentStatusFailed, mock.Anything).
+					Return(errors.New("database error"))
+				ps.On("CreatePaymentContingency", mock.Anything, mock.MatchedBy(func(pc internal.PaymentContingency) bool {
+					return pc.TransactionID == "payment-123" && pc.IntendedStatus == internal.PaymentStatusFailed
+				})).Return(nil)
+			},
+			expectedError: services.ErrPaymentGateway,
+		},
+		{
+			name: "contingency cannot be recorded",
+			request: internal.PaymentRequest{
+				UserID: 1234,
+				Amount: usd(10050),
+				Method: "card",
+			},
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreatePaymentRequest", mock.Anything, mock.Anything).Return("payment-123", nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123"}, nil)
+				ps.On("UpdatePaymentRequest", mock.Anything, mock.Anything, "payment-123", internal.PaymentStatusSuccess, mock.Anything).
+					Return(errors.New("database error"))
+				ps.On("CreatePaymentContingency", mock.Anything, mock.Anything).Return(errors.New("database error"))
+			},
+			expectedError: services.ErrUpdatingPaymentRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -127,7 +317,7 @@ func TestPaymentService_CreatePayment(t *testing.T) {
 			}
 
 			service := services.NewPaymentService(mockStorage, mockGateway)
-			paymentID, err := service.CreatePayment(context.Background(), tt.request)
+			paymentID, status, err := service.CreatePayment(context.Background(), tt.request)
 
 			if tt.expectedError != nil {
 				assert.ErrorIs(t, err, tt.expectedError)
@@ -135,6 +325,353 @@ func TestPaymentService_CreatePayment(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectedID, paymentID)
+				assert.Equal(t, tt.expectedStatus, status)
+			}
+
+			mockStorage.AssertExpectations(t)
+			mockGateway.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPaymentService_CreateDeposit(t *testing.T) {
+	request := internal.DepositRequest{
+		UserID: 1234,
+		Amount: usd(10050),
+		Method: "card",
+	}
+	approved := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123", ResponseCode: "approved"}
+
+	tests := []struct {
+		name           string
+		setupMocks     func(*mockPaymentStorage, *mockGatewayClient)
+		expectedID     string
+		expectedStatus string
+		expectedError  error
+	}{
+		{
+			name: "successful deposit credits the wallet",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateDepositRequest", mock.Anything, request).Return("deposit-123", nil)
+				gc.On("CreateDeposit", mock.Anything, "deposit-123", request).Return(approved, nil)
+				ps.On("UpdateDepositRequest", mock.Anything, request, "deposit-123", internal.PaymentStatusSuccess, approved).Return(nil)
+			},
+			expectedID:     "deposit-123",
+			expectedStatus: internal.PaymentStatusSuccess,
+		},
+		{
+			name: "gateway error marks the deposit failed",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				declined := internal.GatewayResponse{Gateway: "mock", ResponseCode: "declined"}
+				ps.On("CreateDepositRequest", mock.Anything, request).Return("deposit-123", nil)
+				gc.On("CreateDeposit", mock.Anything, "deposit-123", request).Return(declined, internal.ErrGatewayDeclined)
+				ps.On("UpdateDepositRequest", mock.Anything, request, "deposit-123", internal.PaymentStatusFailed, declined).Return(nil)
+			},
+			expectedError: services.ErrDepositGateway,
+		},
+		{
+			name: "pending gateway response is left for the reconciler",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				pending := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123", Status: internal.PaymentStatusPending}
+				ps.On("CreateDepositRequest", mock.Anything, request).Return("deposit-123", nil)
+				gc.On("CreateDeposit", mock.Anything, "deposit-123", request).Return(pending, nil)
+				ps.On("SetPendingGatewayResponse", mock.Anything, "deposit-123", pending).Return(nil)
+			},
+			expectedID:     "deposit-123",
+			expectedStatus: internal.PaymentStatusPending,
+		},
+		{
+			name: "unresolved card marks the deposit failed",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateDepositRequest", mock.Anything, request).Return("deposit-123", nil)
+				gc.On("CreateDeposit", mock.Anything, "deposit-123", request).
+					Return(internal.GatewayResponse{Gateway: "acme"}, fmt.Errorf("%w: %w", internal.ErrCardUnresolved, internal.ErrCardNotFound))
+				ps.On("UpdateDepositRequest", mock.Anything, request, "deposit-123", internal.PaymentStatusFailed, internal.GatewayResponse{Gateway: "acme"}).Return(nil)
+			},
+			expectedError: internal.ErrCardUnresolved,
+		},
+		{
+			name: "gateway timeout leaves the deposit pending",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateDepositRequest", mock.Anything, request).Return("deposit-123", nil)
+				gc.On("CreateDeposit", mock.Anything, "deposit-123", request).
+					Return(internal.GatewayResponse{Gateway: "mock"}, fmt.Errorf("%w: timed out", internal.ErrGatewayUnavailable))
+			},
+			expectedID:     "deposit-123",
+			expectedStatus: internal.PaymentStatusPending,
+		},
+		{
+			name: "storage error creating deposit request",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateDepositRequest", mock.Anything, request).Return("", errors.New("database error"))
+			},
+			expectedError: services.ErrCreatingDepositRequest,
+		},
+		{
+			name: "credit failure is sent to contingency",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateDepositRequest", mock.Anything, request).Return("deposit-123", nil)
+				gc.On("CreateDeposit", mock.Anything, "deposit-123", request).Return(approved, nil)
+				ps.On("UpdateDepositRequest", mock.Anything, request, "deposit-123", internal.PaymentStatusSuccess, approved).
+					Return(errors.New("database error"))
+				ps.On("CreatePaymentContingency", mock.Anything, mock.MatchedBy(func(pc internal.PaymentContingency) bool {
+					return pc.TransactionID == "deposit-123" &&
+						pc.TransactionType == internal.TransactionTypeDeposit &&
+						pc.IntendedStatus == internal.PaymentStatusSuccess
+				})).Return(nil)
+			},
+			expectedID:     "deposit-123",
+			expectedStatus: internal.PaymentStatusSuccess,
+		},
+		{
+			name: "contingency cannot be recorded",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateDepositRequest", mock.Anything, request).Return("deposit-123", nil)
+				gc.On("CreateDeposit", mock.Anything, "deposit-123", request).Return(approved, nil)
+				ps.On("UpdateDepositRequest", mock.Anything, request, "deposit-123", internal.PaymentStatusSuccess, approved).
+					Return(errors.New("database error"))
+				ps.On("CreatePaymentContingency", mock.Anything, mock.Anything).Return(errors.New("database error"))
+			},
+			expectedError: services.ErrUpdatingDepositRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockPaymentStorage)
+			mockGateway := new(mockGatewayClient)
+			tt.setupMocks(mockStorage, mockGateway)
+
+			service := services.NewPaymentService(mockStorage, mockGateway)
+			transactionID, status, err := service.CreateDeposit(context.Background(), request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.Empty(t, transactionID)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedID, transactionID)
+				assert.Equal(t, tt.expectedStatus, status)
+			}
+
+			mockStorage.AssertExpectations(t)
+			mockGateway.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPaymentService_CreateRefund(t *testing.T) {
+	fullRefund := internal.RefundRequest{UserID: 1234, TransactionID: "payment-123"}
+	// The storage resolves the amount, method and gateway of the refunded payment
+	resolved := internal.RefundRequest{UserID: 1234, TransactionID: "payment-123", Amount: usd(10050), Method: "card", Gateway: "mock"}
+	pending := internal.Transaction{
+		ID:                    "refund-123",
+		UserID:                1234,
+		Amount:                usd(10050),
+		Type:                  internal.TransactionTypeRefund,
+		Status:                internal.PaymentStatusPending,
+		Method:                "card",
+		GatewayName:           "mock",
+		OriginalTransactionID: "payment-123",
+	}
+	approved := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-refund-123", ResponseCode: "approved"}
+
+	tests := []struct {
+		name           string
+		setupMocks     func(*mockPaymentStorage, *mockGatewayClient)
+		expectedStatus string
+		expectedError  error
+	}{
+		{
+			name: "full refund credits what is left of the payment",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(pending, nil)
+				gc.On("Refund", mock.Anything, "refund-123", resolved).Return(approved, nil)
+				ps.On("UpdateRefundRequest", mock.Anything, resolved, "refund-123", internal.PaymentStatusSuccess, approved).Return(nil)
+			},
+			expectedStatus: internal.PaymentStatusSuccess,
+		},
+		{
+			name: "refund over the amount left is rejected",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(internal.Transaction{}, internal.ErrRefundExceedsPayment)
+			},
+			expectedError: internal.ErrRefundExceedsPayment,
+		},
+		{
+			name: "refund in another currency than the payment is rejected",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).
+					Return(internal.Transaction{}, fmt.Errorf("%w: payment is in EUR, refund in USD", internal.ErrCurrencyMismatch))
+			},
+			expectedError: internal.ErrCurrencyMismatch,
+		},
+		{
+			name: "payment of another user is not found",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(internal.Transaction{}, internal.ErrTransactionNotFound)
+			},
+			expectedError: internal.ErrTransactionNotFound,
+		},
+		{
+			name: "pending payment cannot be refunded",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(internal.Transaction{}, internal.ErrPaymentNotRefundable)
+			},
+			expectedError: internal.ErrPaymentNotRefundable,
+		},
+		{
+			name: "storage error creating refund request",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(internal.Transaction{}, errors.New("database error"))
+			},
+			expectedError: services.ErrCreatingRefundRequest,
+		},
+		{
+			name: "gateway error releases the reserved amount",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(pending, nil)
+				gc.On("Refund", mock.Anything, "refund-123", resolved).Return(internal.GatewayResponse{Gateway: "mock"}, internal.ErrGatewayRejected)
+				ps.On("UpdateRefundRequest", mock.Anything, resolved, "refund-123", internal.PaymentStatusFailed, internal.GatewayResponse{Gateway: "mock"}).Return(nil)
+			},
+			expectedError: services.ErrRefundGateway,
+		},
+		{
+			name: "pending gateway response keeps its reference",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				inProgress := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-refund-123", Status: internal.PaymentStatusPending}
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(pending, nil)
+				gc.On("Refund", mock.Anything, "refund-123", resolved).Return(inProgress, nil)
+				ps.On("SetPendingGatewayResponse", mock.Anything, "refund-123", inProgress).Return(errors.New("database error"))
+			},
+			expectedStatus: internal.PaymentStatusPending,
+		},
+		{
+			name: "gateway timeout keeps the amount reserved",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(pending, nil)
+				gc.On("Refund", mock.Anything, "refund-123", resolved).
+					Return(internal.GatewayResponse{Gateway: "mock"}, fmt.Errorf("%w: timed out", internal.ErrGatewayUnavailable))
+			},
+			expectedStatus: internal.PaymentStatusPending,
+		},
+		{
+			name: "credit failure is sent to contingency",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CreateRefundRequest", mock.Anything, fullRefund).Return(pending, nil)
+				gc.On("Refund", mock.Anything, "refund-123", resolved).Return(approved, nil)
+				ps.On("UpdateRefundRequest", mock.Anything, resolved, "refund-123", internal.PaymentStatusSuccess, approved).
+					Return(errors.New("database error"))
+				ps.On("CreatePaymentContingency", mock.Anything, mock.MatchedBy(func(pc internal.PaymentContingency) bool {
+					return pc.TransactionID == "refund-123" &&
+						pc.TransactionType == internal.TransactionTypeRefund &&
+						pc.PaymentRequest.Amount == usd(10050) &&
+						pc.IntendedStatus == internal.PaymentStatusSuccess
+				})).Return(nil)
+			},
+			expectedStatus: internal.PaymentStatusSuccess,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockPaymentStorage)
+			mockGateway := new(mockGatewayClient)
+			tt.setupMocks(mockStorage, mockGateway)
+
+			service := services.NewPaymentService(mockStorage, mockGateway)
+			refund, err := service.CreateRefund(context.Background(), fullRefund)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.Empty(t, refund.ID)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "refund-123", refund.ID)
+				assert.Equal(t, usd(10050), refund.Amount)
+				assert.Equal(t, tt.expectedStatus, refund.Status)
+			}
+
+			mockStorage.AssertExpectations(t)
+			mockGateway.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPaymentService_CapturePayment(t *testing.T) {
+	request := internal.CaptureRequest{UserID: 1234, HoldID: "hold-123", Amount: usd(4000)}
+	captured := internal.Hold{
+		ID:             "hold-123",
+		UserID:         1234,
+		Method:         "card",
+		Amount:         usd(10050),
+		CapturedAmount: usd(4000),
+		Status:         internal.HoldStatusCaptured,
+		TransactionID:  "payment-123",
+	}
+	expectedPayment := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: usd(4000)}
+	approved := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123", ResponseCode: "approved"}
+
+	tests := []struct {
+		name          string
+		setupMocks    func(*mockPaymentStorage, *mockGatewayClient)
+		expectedError error
+	}{
+		{
+			name: "captured amount is charged through the gateway",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CaptureHold", mock.Anything, request).Return(captured, nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", expectedPayment).Return(approved, nil)
+				ps.On("UpdatePaymentRequest", mock.Anything, expectedPayment, "payment-123", internal.PaymentStatusSuccess, approved).Return(nil)
+			},
+		},
+		{
+			name: "gateway error fails the captured payment",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CaptureHold", mock.Anything, request).Return(captured, nil)
+				gc.On("CreatePayment", mock.Anything, "payment-123", expectedPayment).Return(internal.GatewayResponse{}, internal.ErrGatewayDeclined)
+				ps.On("UpdatePaymentRequest", mock.Anything, expectedPayment, "payment-123", internal.PaymentStatusFailed, internal.GatewayResponse{}).Return(nil)
+			},
+			expectedError: services.ErrPaymentGateway,
+		},
+		{
+			name: "expired hold cannot be captured",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CaptureHold", mock.Anything, request).Return(internal.Hold{}, internal.ErrHoldNotActive)
+			},
+			expectedError: internal.ErrHoldNotActive,
+		},
+		{
+			name: "capture over the held amount is rejected",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CaptureHold", mock.Anything, request).Return(internal.Hold{}, internal.ErrCaptureExceedsHold)
+			},
+			expectedError: internal.ErrCaptureExceedsHold,
+		},
+		{
+			name: "storage error capturing hold",
+			setupMocks: func(ps *mockPaymentStorage, gc *mockGatewayClient) {
+				ps.On("CaptureHold", mock.Anything, request).Return(internal.Hold{}, errors.New("database error"))
+			},
+			expectedError: services.ErrCapturingHold,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockPaymentStorage)
+			mockGateway := new(mockGatewayClient)
+			tt.setupMocks(mockStorage, mockGateway)
+
+			service := services.NewPaymentService(mockStorage, mockGateway)
+			hold, err := service.CapturePayment(context.Background(), request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.Empty(t, hold.ID)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, captured, hold)
 			}
 
 			mockStorage.AssertExpectations(t)
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+type ReconcilerStorage interface {
+	TransactionUpdater
+	GetStalePendingTransactions(ctx context.Context, staleAfter time.Duration, limit int) ([]internal.Transaction, error)
+}
+
+// ReconcilerService settles payments, deposits and refunds left pending by
+// asking the gateway for their real outcome
+type ReconcilerService struct {
+	storage       ReconcilerStorage
+	gatewayClient GatewayClient
+	cfg           config.ReconcilerConfig
+}
+
+func NewReconcilerService(storage ReconcilerStorage, gatewayClient GatewayClient, cfg config.ReconcilerConfig) *ReconcilerService {
+	return &ReconcilerService{
+		storage:       storage,
+		gatewayClient: gatewayClient,
+		cfg:           cfg,
+	}
+}
+
+// Run reconciles stale transactions every poll interval until ctx is cancelled
+func (s *ReconcilerService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ReconcileStale(ctx)
+		}
+	}
+}
+
+// ReconcileStale settles one batch of stale pending transactions
+func (s *ReconcilerService) ReconcileStale(ctx context.Context) {
+	transactions, err := s.storage.GetStalePendingTransactions(ctx, s.cfg.StaleAfter, s.cfg.BatchSize)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not get stale pending transactions", "error", err.Error())
+		return
+	}
+
+	for _, transaction := range transactions {
+		s.reconcile(ctx, transaction)
+	}
+}
+
+func (s *ReconcilerService) reconcile(ctx context.Context, transaction internal.Transaction) {
+	logger := slog.With(
+		"transaction_id", transaction.ID,
+		"transaction_type", transaction.Type,
+		"user_id", transaction.UserID,
+		"amount", transaction.Amount.String(),
+		"created_at", transaction.CreatedAt,
+	)
+
+	gatewayResponse, err := s.gatewayClient.GetPaymentStatus(ctx, transaction)
+	switch {
+	case errors.Is(err, internal.ErrGatewayPaymentNotFound):
+		if age := time.Since(transaction.CreatedAt); age < s.cfg.NotFoundGrace {
+			logger.WarnContext(ctx, "Reconciler left transaction unknown to the gateway pending", "age", age.String())
+			return
+		}
+		// The gateway still does not know the transaction after the grace
+		// period, so it never reached it and no money moved
+		logger.WarnContext(ctx, "Reconciler failing transaction unknown to the gateway")
+		gatewayResponse.Status = internal.PaymentStatusFailed
+	case err != nil:
+		logger.ErrorContext(ctx, "Reconciler could not query gateway, transaction left pending", "error", err.Error())
+		return
+	}
+
+	if gatewayResponse.Status != internal.PaymentStatusSuccess && gatewayResponse.Status != internal.PaymentStatusFailed {
+		logger.InfoContext(ctx, "Reconciler left transaction pending", "gateway_status", gatewayResponse.Status)
+		return
+	}
+
+	paymentRequest := internal.PaymentRequest{
+		UserID: transaction.UserID,
+		Amount: transaction.Amount,
+	}
+	err = updateTransaction(ctx, s.storage, transaction.Type, paymentRequest, transaction.ID, gatewayResponse.Status, gatewayResponse)
+	if errors.Is(err, internal.ErrTransactionNotPending) {
+		logger.InfoContext(ctx, "Reconciler skipped transaction already finalised")
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Reconciler could not update transaction", "status", gatewayResponse.Status, "error", err.Error())
+		return
+	}
+
+	logger.InfoContext(ctx, "Reconciler finalised stale transaction",
+		"status", gatewayResponse.Status,
+		"gateway", gatewayResponse.Gateway,
+		"gateway_reference", gatewayResponse.Reference,
+		"balance_updated", (transaction.Type == internal.TransactionTypePayment) == (gatewayResponse.Status == internal.PaymentStatusFailed),
+	)
+}
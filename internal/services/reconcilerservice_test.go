@@ -0,0 +1,185 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/repository"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockReconcilerStorage struct {
+	mock.Mock
+}
+
+func (m *mockReconcilerStorage) GetStalePendingTransactions(ctx context.Context, staleAfter time.Duration, limit int) ([]internal.Transaction, error) {
+	args := m.Called(ctx, staleAfter, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.Transaction), args.Error(1)
+}
+
+func (m *mockReconcilerStorage) UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, paymentRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockReconcilerStorage) UpdateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, depositRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockReconcilerStorage) UpdateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, refundRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+var testReconcilerConfig = config.ReconcilerConfig{
+	PollInterval:  time.Second,
+	StaleAfter:    5 * time.Minute,
+	BatchSize:     10,
+	NotFoundGrace: time.Hour,
+}
+
+func TestReconcilerService_ReconcileStale(t *testing.T) {
+	stale := internal.Transaction{
+		ID:     "payment-123",
+		UserID: 1234,
+		Amount: usd(10050),
+		Type:   "payment",
+		Status: internal.PaymentStatusPending,
+	}
+	expectedRequest := internal.PaymentRequest{UserID: 1234, Amount: usd(10050)}
+	staleDeposit := stale
+	staleDeposit.ID = "deposit-123"
+	staleDeposit.Type = internal.TransactionTypeDeposit
+
+	tests := []struct {
+		name       string
+		setupMocks func(*mockReconcilerStorage, *mockGatewayClient)
+	}{
+		{
+			name: "payment charged by the gateway is marked successful",
+			setupMocks: func(rs *mockReconcilerStorage, gc *mockGatewayClient) {
+				rs.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return([]internal.Transaction{stale}, nil)
+				response := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-123", Status: internal.PaymentStatusSuccess}
+				gc.On("GetPaymentStatus", mock.Anything, stale).Return(response, nil)
+				rs.On("UpdatePaymentRequest", mock.Anything, expectedRequest, "payment-123", internal.PaymentStatusSuccess, response).Return(nil)
+			},
+		},
+		{
+			name: "payment declined by the gateway is marked failed",
+			setupMocks: func(rs *mockReconcilerStorage, gc *mockGatewayClient) {
+				rs.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return([]internal.Transaction{stale}, nil)
+				response := internal.GatewayResponse{Gateway: "mock", ResponseCode: "declined", Status: internal.PaymentStatusFailed}
+				gc.On("GetPaymentStatus", mock.Anything, stale).Return(response, nil)
+				rs.On("UpdatePaymentRequest", mock.Anything, expectedRequest, "payment-123", internal.PaymentStatusFailed, response).Return(nil)
+			},
+		},
+		{
+			name: "payment unknown to the gateway after the grace period is marked failed",
+			setupMocks: func(rs *mockReconcilerStorage, gc *mockGatewayClient) {
+				rs.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return([]internal.Transaction{stale}, nil)
+				gc.On("GetPaymentStatus", mock.Anything, stale).
+					Return(internal.GatewayResponse{Gateway: "mock"}, internal.ErrGatewayPaymentNotFound)
+				rs.On("UpdatePaymentRequest", mock.Anything, expectedRequest, "payment-123", internal.PaymentStatusFailed,
+					internal.GatewayResponse{Gateway: "mock", Status: internal.PaymentStatusFailed}).Return(nil)
+			},
+		},
+		{
+			name: "payment unknown to the gateway within the grace period is left pending",
+			setupMocks: func(rs *mockReconcilerStorage, gc *mockGatewayClient) {
+				recent := stale
+				recent.CreatedAt = time.Now().Add(-10 * time.Minute)
+				rs.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return([]internal.Transaction{recent}, nil)
+				gc.On("GetPaymentStatus", mock.Anything, recent).
+					Return(internal.GatewayResponse{Gateway: "mock"}, internal.ErrGatewayPaymentNotFound)
+			},
+		},
+		{
+			name: "deposit collected by the gateway credits the wallet",
+			setupMocks: func(rs *mockReconcilerStorage, gc *mockGatewayClient) {
+				rs.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return([]internal.Transaction{staleDeposit}, nil)
+				response := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-tx-456", Status: internal.PaymentStatusSuccess}
+				gc.On("GetPaymentStatus", mock.Anything, staleDeposit).Return(response, nil)
+				rs.On("UpdateDepositRequest", mock.Anything, internal.DepositRequest(expectedRequest), "deposit-123", internal.PaymentStatusSuccess, response).Return(nil)
+			},
+		},
+		{
+			name: "payment still pending in the gateway is left untouched",
+			setupMocks: func(rs *mockReconcilerStorage, gc *mockGatewayClient) {
+				rs.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return([]internal.Transaction{stale}, nil)
+				gc.On("GetPaymentStatus", mock.Anything, stale).
+					Return(internal.GatewayResponse{Gateway: "mock", Status: internal.PaymentStatusPending}, nil)
+			},
+		},
+		{
+			name: "gateway error leaves the payment pending",
+			setupMocks: func(rs *mockReconcilerStorage, gc *mockGatewayClient) {
+				rs.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return([]internal.Transaction{stale}, nil)
+				gc.On("GetPaymentStatus", mock.Anything, stale).Return(internal.GatewayResponse{}, errors.New("gateway error"))
+			},
+		},
+		{
+			name: "storage error skips the batch",
+			setupMocks: func(rs *mockReconcilerStorage, gc *mockGatewayClient) {
+				rs.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return(nil, errors.New("database error"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockReconcilerStorage)
+			mockGateway := new(mockGatewayClient)
+			tt.setupMocks(mockStorage, mockGateway)
+
+			service := services.NewReconcilerService(mockStorage, mockGateway, testReconcilerConfig)
+			service.ReconcileStale(context.Background())
+
+			mockStorage.AssertExpectations(t)
+			mockGateway.AssertExpectations(t)
+		})
+	}
+}
+
+// A payment the gateway charged but whose answer was lost must stay pending,
+// with the wallet still debited, until the reconciler learns it succeeded
+func TestReconcilerService_SettlesPaymentsWhoseGatewayResponseWasLost(t *testing.T) {
+	ctx := context.Background()
+	request := internal.PaymentRequest{UserID: 1234, Method: "card", Amount: usd(10050)}
+	gateway := services.NewRetryingGatewayClient(
+		repository.NewFaultyGatewayClient(config.GatewayFaultConfig{LostResponseRate: 1}, 10*time.Millisecond),
+		testRetryConfig,
+	)
+
+	paymentStorage := new(mockPaymentStorage)
+	paymentStorage.On("CreatePaymentRequest", mock.Anything, request).Return("payment-123", nil)
+
+	transactionID, status, err := services.NewPaymentService(paymentStorage, gateway).CreatePayment(ctx, request)
+
+	require.NoError(t, err)
+	assert.Equal(t, "payment-123", transactionID)
+	assert.Equal(t, internal.PaymentStatusPending, status)
+	paymentStorage.AssertExpectations(t)
+	paymentStorage.AssertNotCalled(t, "UpdatePaymentRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	stale := internal.Transaction{ID: transactionID, UserID: 1234, Amount: usd(10050), Type: internal.TransactionTypePayment, Status: internal.PaymentStatusPending}
+	reconcilerStorage := new(mockReconcilerStorage)
+	reconcilerStorage.On("GetStalePendingTransactions", mock.Anything, 5*time.Minute, 10).Return([]internal.Transaction{stale}, nil)
+	reconcilerStorage.On("UpdatePaymentRequest", mock.Anything, internal.PaymentRequest{UserID: 1234, Amount: usd(10050)}, "payment-123", internal.PaymentStatusSuccess,
+		mock.MatchedBy(func(response internal.GatewayResponse) bool { return response.Reference != "" }),
+	).Return(nil)
+
+	services.NewReconcilerService(reconcilerStorage, gateway, testReconcilerConfig).ReconcileStale(ctx)
+
+	reconcilerStorage.AssertExpectations(t)
+}
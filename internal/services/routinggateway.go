@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+)
+
+var (
+	ErrRecordingGateway = errors.New("error recording transaction gateway")
+)
+
+// GatewayRoutingStorage records the gateway each transaction is sent to
+type GatewayRoutingStorage interface {
+	SetTransactionGateway(ctx context.Context, transactionID string, gateway string) error
+}
+
+// WeightedGateway is a gateway of a route and its share of the route's
+// payments
+type WeightedGateway struct {
+	Name   string
+	Weight int
+}
+
+// GatewayRoute sends the payments and deposits it matches to one of its
+// Gateways, picked by weight, and to Failover when a call never reached it.
+// Empty conditions match everything. The amount band, from MinAmount
+// inclusive to MaxAmount exclusive, is in the route's Currency.
+type GatewayRoute struct {
+	Method    string
+	Currency  string
+	MinAmount *internal.Money
+	MaxAmount *internal.Money
+	Gateways  []WeightedGateway
+	Failover  string
+}
+
+// matches reports whether a payment or deposit meets every condition of the
+// route
+func (r GatewayRoute) matches(method string, amount internal.Money) bool {
+	switch {
+	case r.Method != "" && r.Method != method:
+		return false
+	case r.Currency != "" && r.Currency != amount.Currency:
+		return false
+	case r.MinAmount != nil && amount.LessThan(*r.MinAmount):
+		return false
+	case r.MaxAmount != nil && !amount.LessThan(*r.MaxAmount):
+		return false
+	}
+	return true
+}
+
+// pick chooses one of the route's gateways with a probability proportional to
+// its weight. The choice hashes the transaction ID, so it is the same every
+// time the transaction is routed.
+func (r GatewayRoute) pick(transactionID string) string {
+	total := 0
+	for _, gateway := range r.Gateways {
+		total += gateway.Weight
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(transactionID))
+	point := int(hash.Sum32() % uint32(total))
+	for _, gateway := range r.Gateways {
+		if point < gateway.Weight {
+			return gateway.Name
+		}
+		point -= gateway.Weight
+	}
+	return r.Gateways[len(r.Gateways)-1].Name
+}
+
+// RoutingGatewayClient spreads payments and deposits across several gateways.
+// Each one goes to the gateway picked by the first route matching it, or else
+// to the gateway of its payment method, and fails over to the route's
+// Failover when the call never reached that gateway. The chosen gateway is
+// recorded on the transaction before calling it, so refunds and status
+// queries, which never fail over, go back to the gateway that processed the
+// payment even if the process dies mid-call. A failover overwrites it safely,
+// since the gateway it replaces cannot have processed the payment.
+type RoutingGatewayClient struct {
+	storage        GatewayRoutingStorage
+	paymentMethods *internal.PaymentMethodRegistry
+	gateways       map[string]GatewayClient
+	routes         []GatewayRoute
+	defaultGateway string
+}
+
+func NewRoutingGatewayClient(storage GatewayRoutingStorage, paymentMethods *internal.PaymentMethodRegistry, gateways map[string]GatewayClient, routes []GatewayRoute, defaultGateway string) *RoutingGatewayClient {
+	return &RoutingGatewayClient{
+		storage:        storage,
+		paymentMethods: paymentMethods,
+		gateways:       gateways,
+		routes:         routes,
+		defaultGateway: defaultGateway,
+	}
+}
+
+func (g *RoutingGatewayClient) CreatePayment(ctx context.Context, transactionID string, paymentRequest internal.PaymentRequest) (internal.GatewayResponse, error) {
+	return g.route(ctx, transactionID, paymentRequest.Method, paymentRequest.Amount, func(gateway GatewayClient) (internal.GatewayResponse, error) {
+		return gateway.CreatePayment(ctx, transactionID, paymentRequest)
+	})
+}
+
+func (g *RoutingGatewayClient) CreateDeposit(ctx context.Context, transactionID string, depositRequest internal.DepositRequest) (internal.GatewayResponse, error) {
+	return g.route(ctx, transactionID, depositRequest.Method, depositRequest.Amount, func(gateway GatewayClient) (internal.GatewayResponse, error) {
+		return gateway.CreateDeposit(ctx, transactionID, depositRequest)
+	})
+}
+
+func (g *RoutingGatewayClient) Refund(ctx context.Context, transactionID string, refundRequest internal.RefundRequest) (internal.GatewayResponse, error) {
+	name := g.recordedGateway(refundRequest.Gateway, refundRequest.Method)
+	response, err := g.gateways[name].Refund(ctx, transactionID, refundRequest)
+	response.Gateway = name
+	return response, err
+}
+
+func (g *RoutingGatewayClient) GetPaymentStatus(ctx context.Context, transaction internal.Transaction) (internal.GatewayResponse, error) {
+	name := g.recordedGateway(transaction.GatewayName, transaction.Method)
+	response, err := g.gateways[name].GetPaymentStatus(ctx, transaction)
+	response.Gateway = name
+	return response, err
+}
+
+// route calls the gateway chosen for a payment or deposit and then, if the
+// call never reached it, its failover. Any other error, timeouts included,
+// may hide a charge, so the transaction stays with the gateway that may have
+// made it. Responses carry the name the gateway is routed by, which is what
+// gets stored on the transaction.
+func (g *RoutingGatewayClient) route(ctx context.Context, transactionID string, method string, amount internal.Money, call func(GatewayClient) (internal.GatewayResponse, error)) (internal.GatewayResponse, error) {
+	var (
+		response internal.GatewayResponse
+		err      error
+	)
+	for i, name := range g.candidates(transactionID, method, amount) {
+		if i > 0 {
+			if ctx.Err() != nil {
+				break
+			}
+			slog.WarnContext(ctx, "Failing over to secondary gateway",
+				"transaction_id", transactionID,
+				"gateway", name,
+				"error", err.Error(),
+			)
+		}
+
+		if errRecord := g.storage.SetTransactionGateway(ctx, transactionID, name); errRecord != nil {
+			return response, fmt.Errorf("%w: %s", ErrRecordingGateway, errRecord.Error())
+		}
+
+		response, err = call(g.gateways[name])
+		response.Gateway = name
+		if !neverReachedGateway(err) {
+			break
+		}
+	}
+
+	return response, err
+}
+
+// candidates returns the gateway picked for a payment or deposit, followed by
+// the failover of its route if it has one
+func (g *RoutingGatewayClient) candidates(transactionID string, method string, amount internal.Money) []string {
+	for _, route := range g.routes {
+		if !route.matches(method, amount) {
+			continue
+		}
+
+		primary := route.pick(transactionID)
+		if route.Failover == "" || route.Failover == primary {
+			return []string{primary}
+		}
+		return []string{primary, route.Failover}
+	}
+
+	return []string{g.methodGateway(method)}
+}
+
+// recordedGateway returns the gateway recorded on a transaction, or the
+// gateway of its payment method for transactions recorded without a known one
+func (g *RoutingGatewayClient) recordedGateway(name string, method string) string {
+	if _, ok := g.gateways[name]; ok {
+		return name
+	}
+	return g.methodGateway(method)
+}
+
+// methodGateway returns the gateway that processes a payment method, or the
+// default gateway for methods no longer enabled
+func (g *RoutingGatewayClient) methodGateway(method string) string {
+	paymentMethod, ok := g.paymentMethods.Get(method)
+	if !ok {
+		return g.defaultGateway
+	}
+	if _, ok := g.gateways[paymentMethod.Gateway]; !ok {
+		return g.defaultGateway
+	}
+	return paymentMethod.Gateway
+}
@@ -0,0 +1,334 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/repository"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockGatewayRoutingStorage struct {
+	mock.Mock
+}
+
+func (m *mockGatewayRoutingStorage) SetTransactionGateway(ctx context.Context, transactionID string, gateway string) error {
+	args := m.Called(ctx, transactionID, gateway)
+	return args.Error(0)
+}
+
+func TestRoutingGatewayClient(t *testing.T) {
+	card := internal.PaymentMethodDefinitions[internal.PaymentMethodCard]
+	card.Gateway = "cards"
+	account := internal.PaymentMethodDefinitions[internal.PaymentMethodAccount]
+	account.Gateway = "banks"
+	registry := internal.NewPaymentMethodRegistry(card, account)
+	largeAmount := usd(500000)
+	routes := []services.GatewayRoute{
+		{Currency: "EUR", Gateways: []services.WeightedGateway{{Name: "eu", Weight: 1}}, Failover: "eu_backup"},
+		{Method: "card", Currency: "USD", MinAmount: &largeAmount, Gateways: []services.WeightedGateway{{Name: "large", Weight: 1}}},
+	}
+	approved := internal.GatewayResponse{Gateway: "mock", Reference: "gateway-123", Status: internal.PaymentStatusSuccess}
+	eur := internal.NewMoney(1000, "EUR")
+
+	tests := []struct {
+		name            string
+		call            func(context.Context, *services.RoutingGatewayClient) (internal.GatewayResponse, error)
+		setupMocks      func(*mockGatewayRoutingStorage, map[string]*mockGatewayClient)
+		expectedGateway string
+		expectedError   error
+	}{
+		{
+			name: "payments matching no route go to the gateway of their method",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: usd(1000)})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "cards").Return(nil)
+				gateways["cards"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "cards",
+		},
+		{
+			name: "deposits matching no route go to the gateway of their method",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreateDeposit(ctx, "deposit-123", internal.DepositRequest{Method: "account", Amount: usd(1000)})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "deposit-123", "banks").Return(nil)
+				gateways["banks"].On("CreateDeposit", mock.Anything, "deposit-123", mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "banks",
+		},
+		{
+			name: "payments are routed by currency",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "eu").Return(nil)
+				gateways["eu"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "eu",
+		},
+		{
+			name: "payments are routed by amount band",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: largeAmount})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "large").Return(nil)
+				gateways["large"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "large",
+		},
+		{
+			name: "unreachable gateway fails over to the secondary",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "eu").Return(nil).Once()
+				gateways["eu"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{}, fmt.Errorf("%w: %w: connection refused", internal.ErrGatewayUnavailable, internal.ErrGatewayNotReached))
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "eu_backup").Return(nil).Once()
+				gateways["eu_backup"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "eu_backup",
+		},
+		{
+			name: "open circuit breaker fails over to the secondary",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreateDeposit(ctx, "deposit-123", internal.DepositRequest{Method: "card", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "deposit-123", "eu").Return(nil).Once()
+				gateways["eu"].On("CreateDeposit", mock.Anything, "deposit-123", mock.Anything).Return(internal.GatewayResponse{}, internal.ErrGatewayCircuitOpen)
+				rs.On("SetTransactionGateway", mock.Anything, "deposit-123", "eu_backup").Return(nil).Once()
+				gateways["eu_backup"].On("CreateDeposit", mock.Anything, "deposit-123", mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "eu_backup",
+		},
+		{
+			name: "server error does not fail over",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "eu").Return(nil).Once()
+				gateways["eu"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{}, fmt.Errorf("%w: status 503", internal.ErrGatewayUnavailable))
+			},
+			expectedGateway: "eu",
+			expectedError:   internal.ErrGatewayUnavailable,
+		},
+		{
+			name: "timeout does not fail over",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreateDeposit(ctx, "deposit-123", internal.DepositRequest{Method: "card", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "deposit-123", "eu").Return(nil).Once()
+				gateways["eu"].On("CreateDeposit", mock.Anything, "deposit-123", mock.Anything).
+					Return(internal.GatewayResponse{}, fmt.Errorf("%w: %w", internal.ErrGatewayUnavailable, context.DeadlineExceeded))
+			},
+			expectedGateway: "eu",
+			expectedError:   internal.ErrGatewayUnavailable,
+		},
+		{
+			name: "declined payment does not fail over",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "eu").Return(nil)
+				gateways["eu"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{ResponseCode: "do_not_honor"}, internal.ErrGatewayDeclined)
+			},
+			expectedGateway: "eu",
+			expectedError:   internal.ErrGatewayDeclined,
+		},
+		{
+			name: "unresolved card does not fail over",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "eu").Return(nil)
+				gateways["eu"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).
+					Return(internal.GatewayResponse{}, fmt.Errorf("%w: %w", internal.ErrCardUnresolved, internal.ErrCardNotFound))
+			},
+			expectedGateway: "eu",
+			expectedError:   internal.ErrCardUnresolved,
+		},
+		{
+			name: "secondary unavailable too returns its error",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", mock.Anything).Return(nil)
+				gateways["eu"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).Return(internal.GatewayResponse{}, internal.ErrGatewayCircuitOpen)
+				gateways["eu_backup"].On("CreatePayment", mock.Anything, "payment-123", mock.Anything).Return(internal.GatewayResponse{}, internal.ErrGatewayUnavailable)
+			},
+			expectedGateway: "eu_backup",
+			expectedError:   internal.ErrGatewayUnavailable,
+		},
+		{
+			name: "gateway is not called if it cannot be recorded",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.CreatePayment(ctx, "payment-123", internal.PaymentRequest{Method: "card", Amount: usd(1000)})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				rs.On("SetTransactionGateway", mock.Anything, "payment-123", "cards").Return(errors.New("database error"))
+			},
+			expectedError: services.ErrRecordingGateway,
+		},
+		{
+			name: "refunds go to the gateway that charged the payment",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.Refund(ctx, "refund-123", internal.RefundRequest{TransactionID: "payment-123", Method: "card", Gateway: "eu_backup", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				gateways["eu_backup"].On("Refund", mock.Anything, "refund-123", mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "eu_backup",
+		},
+		{
+			name: "refunds of payments without a known gateway go to the gateway of their method",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.Refund(ctx, "refund-123", internal.RefundRequest{TransactionID: "payment-123", Method: "account", Amount: usd(1000)})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				gateways["banks"].On("Refund", mock.Anything, "refund-123", mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "banks",
+		},
+		{
+			name: "unavailable gateway on a refund does not fail over",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.Refund(ctx, "refund-123", internal.RefundRequest{TransactionID: "payment-123", Method: "card", Gateway: "eu", Amount: eur})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				gateways["eu"].On("Refund", mock.Anything, "refund-123", mock.Anything).Return(internal.GatewayResponse{}, internal.ErrGatewayUnavailable)
+			},
+			expectedGateway: "eu",
+			expectedError:   internal.ErrGatewayUnavailable,
+		},
+		{
+			name: "status queries go to the gateway recorded on the transaction",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.GetPaymentStatus(ctx, internal.Transaction{ID: "payment-123", Method: "card", GatewayName: "large"})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				gateways["large"].On("GetPaymentStatus", mock.Anything, mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "large",
+		},
+		{
+			name: "status queries of methods no longer enabled go to the default gateway",
+			call: func(ctx context.Context, g *services.RoutingGatewayClient) (internal.GatewayResponse, error) {
+				return g.GetPaymentStatus(ctx, internal.Transaction{ID: "payment-123", Method: "wire", GatewayName: "retired"})
+			},
+			setupMocks: func(rs *mockGatewayRoutingStorage, gateways map[string]*mockGatewayClient) {
+				gateways["default"].On("GetPaymentStatus", mock.Anything, mock.Anything).Return(approved, nil)
+			},
+			expectedGateway: "default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockGatewayRoutingStorage)
+			mockGateways := make(map[string]*mockGatewayClient)
+			gateways := make(map[string]services.GatewayClient)
+			for _, name := range []string{"cards", "banks", "eu", "eu_backup", "large", "default"} {
+				mockGateways[name] = new(mockGatewayClient)
+				gateways[name] = mockGateways[name]
+			}
+			tt.setupMocks(mockStorage, mockGateways)
+
+			client := services.NewRoutingGatewayClient(mockStorage, registry, gateways, routes, "default")
+			response, err := tt.call(context.Background(), client)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedGateway, response.Gateway)
+
+			mockStorage.AssertExpectations(t)
+			for _, gateway := range mockGateways {
+				gateway.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestRoutingGatewayClient_PrimaryTimesOutAfterCharging(t *testing.T) {
+	ctx := context.Background()
+	routes := []services.GatewayRoute{
+		{Gateways: []services.WeightedGateway{{Name: "primary", Weight: 1}}, Failover: "secondary"},
+	}
+	// The primary charges the payment but its answer never arrives
+	primary := services.NewRetryingGatewayClient(
+		repository.NewFaultyGatewayClient(config.GatewayFaultConfig{LostResponseRate: 1}, 10*time.Millisecond),
+		testRetryConfig,
+	)
+	secondary := new(mockGatewayClient)
+	mockStorage := new(mockGatewayRoutingStorage)
+	mockStorage.On("SetTransactionGateway", mock.Anything, "payment-123", "primary").Return(nil).Once()
+	gateways := map[string]services.GatewayClient{"primary": primary, "secondary": secondary}
+	client := services.NewRoutingGatewayClient(mockStorage, internal.NewPaymentMethodRegistry(), gateways, routes, "primary")
+
+	response, err := client.CreatePayment(ctx, "payment-123", internal.PaymentRequest{UserID: 1234, Method: "card", Amount: usd(1000)})
+
+	assert.ErrorIs(t, err, internal.ErrGatewayUnavailable)
+	assert.Equal(t, "primary", response.Gateway)
+	secondary.AssertNotCalled(t, "CreatePayment", mock.Anything, mock.Anything, mock.Anything)
+	mockStorage.AssertExpectations(t)
+
+	// The recorded gateway is the one that charged it
+	status, err := client.GetPaymentStatus(ctx, internal.Transaction{ID: "payment-123", Method: "card", GatewayName: "primary"})
+	require.NoError(t, err)
+	assert.Equal(t, "primary", status.Gateway)
+	assert.Equal(t, internal.PaymentStatusSuccess, status.Status)
+}
+
+func TestRoutingGatewayClient_WeightedSplit(t *testing.T) {
+	routes := []services.GatewayRoute{
+		{Gateways: []services.WeightedGateway{{Name: "primary", Weight: 3}, {Name: "secondary", Weight: 1}}},
+	}
+	mockStorage := new(mockGatewayRoutingStorage)
+	mockStorage.On("SetTransactionGateway", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	primary := new(mockGatewayClient)
+	primary.On("CreatePayment", mock.Anything, mock.Anything, mock.Anything).Return(internal.GatewayResponse{}, nil)
+	secondary := new(mockGatewayClient)
+	secondary.On("CreatePayment", mock.Anything, mock.Anything, mock.Anything).Return(internal.GatewayResponse{}, nil)
+	gateways := map[string]services.GatewayClient{"primary": primary, "secondary": secondary}
+	client := services.NewRoutingGatewayClient(mockStorage, internal.NewPaymentMethodRegistry(), gateways, routes, "primary")
+
+	counts := make(map[string]int)
+	for i := range 1000 {
+		transactionID := fmt.Sprintf("payment-%d", i)
+		response, err := client.CreatePayment(context.Background(), transactionID, internal.PaymentRequest{Method: "card", Amount: usd(1000)})
+		assert.NoError(t, err)
+		counts[response.Gateway]++
+
+		// The same transaction is always routed to the same gateway
+		again, _ := client.CreatePayment(context.Background(), transactionID, internal.PaymentRequest{Method: "card", Amount: usd(1000)})
+		assert.Equal(t, response.Gateway, again.Gateway)
+	}
+
+	assert.InDelta(t, 750, counts["primary"], 60)
+	assert.InDelta(t, 250, counts["secondary"], 60)
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+)
+
+var (
+	ErrWalletNotFound   = internal.ErrWalletNotFound
+	ErrCreatingTransfer = errors.New("error creating transfer")
+)
+
+type TransferStorage interface {
+	CreateTransfer(ctx context.Context, transferRequest internal.TransferRequest) (internal.Transfer, error)
+}
+
+type TransferService struct {
+	storage TransferStorage
+}
+
+func NewTransferService(storage TransferStorage) *TransferService {
+	return &TransferService{
+		storage: storage,
+	}
+}
+
+// CreateTransfer moves money between two wallets. Both sides are applied
+// atomically by the storage.
+func (s *TransferService) CreateTransfer(ctx context.Context, transferRequest internal.TransferRequest) (internal.Transfer, error) {
+	transfer, err := s.storage.CreateTransfer(ctx, transferRequest)
+	if errors.Is(err, ErrNotEnoughBalance) || errors.Is(err, ErrWalletNotFound) {
+		return internal.Transfer{}, err
+	}
+	if err != nil {
+		return internal.Transfer{}, fmt.Errorf("%w: %s", ErrCreatingTransfer, err.Error())
+	}
+
+	return transfer, nil
+}
@@ -0,0 +1,81 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockTransferStorage struct {
+	mock.Mock
+}
+
+func (m *mockTransferStorage) CreateTransfer(ctx context.Context, transferRequest internal.TransferRequest) (internal.Transfer, error) {
+	args := m.Called(ctx, transferRequest)
+	return args.Get(0).(internal.Transfer), args.Error(1)
+}
+
+func TestTransferService_CreateTransfer(t *testing.T) {
+	request := internal.TransferRequest{
+		FromUserID: 1234,
+		ToUserID:   5678,
+		Amount:     usd(2500),
+	}
+	transfer := internal.Transfer{
+		ID:                    "transfer-123",
+		OutgoingTransactionID: "transfer-out-123",
+		IncomingTransactionID: "transfer-in-123",
+	}
+
+	tests := []struct {
+		name             string
+		storageTransfer  internal.Transfer
+		storageError     error
+		expectedTransfer internal.Transfer
+		expectedError    error
+	}{
+		{
+			name:             "successful transfer",
+			storageTransfer:  transfer,
+			expectedTransfer: transfer,
+		},
+		{
+			name:          "insufficient balance",
+			storageError:  internal.ErrNotEnoughBalance,
+			expectedError: services.ErrNotEnoughBalance,
+		},
+		{
+			name:          "recipient wallet not found",
+			storageError:  internal.ErrWalletNotFound,
+			expectedError: services.ErrWalletNotFound,
+		},
+		{
+			name:          "storage error",
+			storageError:  errors.New("database error"),
+			expectedError: services.ErrCreatingTransfer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockTransferStorage)
+			mockStorage.On("CreateTransfer", mock.Anything, request).Return(tt.storageTransfer, tt.storageError)
+
+			service := services.NewTransferService(mockStorage)
+			result, err := service.CreateTransfer(context.Background(), request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedTransfer, result)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
@@ -6,9 +6,16 @@ import (
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
 )
 
+// DefaultTransactionsLimit is the page size of transaction listings that do
+// not set one
+const DefaultTransactionsLimit = 10
+
 type Storage interface {
-	GetBalance(ctx context.Context, userID uint64) (float64, error)
-	GetTransactions(ctx context.Context, userID uint64) ([]internal.Transaction, error)
+	GetBalance(ctx context.Context, userID uint64) ([]internal.Balance, error)
+	GetTransactions(ctx context.Context, userID uint64, filter internal.TransactionFilter) ([]internal.Transaction, error)
+	GetTransaction(ctx context.Context, userID uint64, transactionID string) (internal.Transaction, error)
+	GetTransactionStatusHistory(ctx context.Context, transactionID string) ([]internal.TransactionStatusChange, error)
+	GetLinkedTransactions(ctx context.Context, transaction internal.Transaction) ([]internal.Transaction, error)
 }
 
 type WalletService struct {
@@ -21,10 +28,55 @@ func NewWalletService(storage Storage) *WalletService {
 	}
 }
 
-func (s *WalletService) GetBalance(ctx context.Context, userID uint64) (float64, error) {
+func (s *WalletService) GetBalance(ctx context.Context, userID uint64) ([]internal.Balance, error) {
 	return s.storage.GetBalance(ctx, userID)
 }
 
-func (s *WalletService) GetTransactions(ctx context.Context, userID uint64) ([]internal.Transaction, error) {
-	return s.storage.GetTransactions(ctx, userID)
+// GetTransactions returns a page of the user's transactions matching filter,
+// of DefaultTransactionsLimit transactions unless filter sets a limit
+func (s *WalletService) GetTransactions(ctx context.Context, userID uint64, filter internal.TransactionFilter) (internal.TransactionPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultTransactionsLimit
+	}
+	limit := filter.Limit
+
+	// One more transaction than requested tells whether there is a next page
+	filter.Limit++
+	transactions, err := s.storage.GetTransactions(ctx, userID, filter)
+	if err != nil {
+		return internal.TransactionPage{}, err
+	}
+
+	page := internal.TransactionPage{Transactions: transactions}
+	if len(transactions) > limit {
+		page.Transactions = transactions[:limit]
+		page.NextCursor = internal.CursorAfter(page.Transactions[limit-1]).Encode()
+	}
+
+	return page, nil
+}
+
+// GetTransaction returns a transaction of the user with its status history
+// and linked transactions. Transactions of other users are not found.
+func (s *WalletService) GetTransaction(ctx context.Context, userID uint64, transactionID string) (internal.TransactionDetail, error) {
+	transaction, err := s.storage.GetTransaction(ctx, userID, transactionID)
+	if err != nil {
+		return internal.TransactionDetail{}, err
+	}
+
+	history, err := s.storage.GetTransactionStatusHistory(ctx, transactionID)
+	if err != nil {
+		return internal.TransactionDetail{}, err
+	}
+
+	linked, err := s.storage.GetLinkedTransactions(ctx, transaction)
+	if err != nil {
+		return internal.TransactionDetail{}, err
+	}
+
+	return internal.TransactionDetail{
+		Transaction:        transaction,
+		StatusHistory:      history,
+		LinkedTransactions: linked,
+	}, nil
 }
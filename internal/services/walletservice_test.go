@@ -3,7 +3,9 @@ package services_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
 	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
@@ -15,13 +17,37 @@ type mockWalletRepo struct {
 	mock.Mock
 }
 
-func (m *mockWalletRepo) GetBalance(ctx context.Context, userID uint64) (float64, error) {
+func (m *mockWalletRepo) GetBalance(ctx context.Context, userID uint64) ([]internal.Balance, error) {
 	args := m.Called(ctx, userID)
-	return args.Get(0).(float64), args.Error(1)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.Balance), args.Error(1)
 }
 
-func (m *mockWalletRepo) GetTransactions(ctx context.Context, userID uint64) ([]internal.Transaction, error) {
-	args := m.Called(ctx, userID)
+func (m *mockWalletRepo) GetTransactions(ctx context.Context, userID uint64, filter internal.TransactionFilter) ([]internal.Transaction, error) {
+	args := m.Called(ctx, userID, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.Transaction), args.Error(1)
+}
+
+func (m *mockWalletRepo) GetTransaction(ctx context.Context, userID uint64, transactionID string) (internal.Transaction, error) {
+	args := m.Called(ctx, userID, transactionID)
+	return args.Get(0).(internal.Transaction), args.Error(1)
+}
+
+func (m *mockWalletRepo) GetTransactionStatusHistory(ctx context.Context, transactionID string) ([]internal.TransactionStatusChange, error) {
+	args := m.Called(ctx, transactionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.TransactionStatusChange), args.Error(1)
+}
+
+func (m *mockWalletRepo) GetLinkedTransactions(ctx context.Context, transaction internal.Transaction) ([]internal.Transaction, error) {
+	args := m.Called(ctx, transaction)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -33,7 +59,7 @@ func TestWalletService_GetBalance(t *testing.T) {
 		name          string
 		userID        uint64
 		setupMock     func(*mockWalletRepo)
-		expected      float64
+		expected      []internal.Balance
 		expectedError error
 	}{
 		{
@@ -41,18 +67,33 @@ func TestWalletService_GetBalance(t *testing.T) {
 			userID: 1234,
 			setupMock: func(m *mockWalletRepo) {
 				m.On("GetBalance", mock.Anything, uint64(1234)).
-					Return(1000.50, nil)
+					Return([]internal.Balance{{Currency: "USD", Balance: usd(100050), Available: usd(90050), Held: usd(10000)}}, nil)
+			},
+			expected: []internal.Balance{{Currency: "USD", Balance: usd(100050), Available: usd(90050), Held: usd(10000)}},
+		},
+		{
+			name:   "one balance per currency",
+			userID: 1234,
+			setupMock: func(m *mockWalletRepo) {
+				eur := internal.NewMoney(2500, "EUR")
+				m.On("GetBalance", mock.Anything, uint64(1234)).
+					Return([]internal.Balance{
+						{Currency: "EUR", Balance: eur, Available: eur, Held: internal.NewMoney(0, "EUR")},
+						{Currency: "USD", Balance: usd(100050), Available: usd(100050), Held: usd(0)},
+					}, nil)
+			},
+			expected: []internal.Balance{
+				{Currency: "EUR", Balance: internal.NewMoney(2500, "EUR"), Available: internal.NewMoney(2500, "EUR"), Held: internal.NewMoney(0, "EUR")},
+				{Currency: "USD", Balance: usd(100050), Available: usd(100050), Held: usd(0)},
 			},
-			expected: 1000.50,
 		},
 		{
 			name:   "error getting balance",
 			userID: 1234,
 			setupMock: func(m *mockWalletRepo) {
 				m.On("GetBalance", mock.Anything, uint64(1234)).
-					Return(0.0, errors.New("database error"))
+					Return(nil, errors.New("database error"))
 			},
-			expected:      0.0,
 			expectedError: errors.New("database error"),
 		},
 	}
@@ -79,40 +120,53 @@ func TestWalletService_GetBalance(t *testing.T) {
 }
 
 func TestWalletService_GetTransactions(t *testing.T) {
+	createdAt := time.Date(2025, 11, 30, 14, 30, 0, 0, time.UTC)
+	transactions := []internal.Transaction{
+		{ID: "3", Amount: usd(300), Type: internal.TransactionTypePayment, CreatedAt: createdAt.Add(2 * time.Second)},
+		{ID: "2", Amount: usd(200), Type: internal.TransactionTypePayment, CreatedAt: createdAt.Add(time.Second)},
+		{ID: "1", Amount: usd(100), Type: internal.TransactionTypePayment, CreatedAt: createdAt},
+	}
+
 	tests := []struct {
 		name          string
-		userID        uint64
+		filter        internal.TransactionFilter
 		setupMock     func(*mockWalletRepo)
-		expected      []internal.Transaction
+		expected      internal.TransactionPage
 		expectedError error
 	}{
 		{
-			name:   "successful transactions retrieval",
-			userID: 1234,
+			name:   "last page has no cursor",
+			filter: internal.TransactionFilter{Type: internal.TransactionTypePayment, Limit: 5},
+			setupMock: func(m *mockWalletRepo) {
+				m.On("GetTransactions", mock.Anything, uint64(1234), internal.TransactionFilter{Type: internal.TransactionTypePayment, Limit: 6}).
+					Return(transactions, nil)
+			},
+			expected: internal.TransactionPage{Transactions: transactions},
+		},
+		{
+			name:   "full page returns the cursor of its last transaction",
+			filter: internal.TransactionFilter{Limit: 2},
 			setupMock: func(m *mockWalletRepo) {
-				transactions := []internal.Transaction{
-					{
-						ID:     "1",
-						Amount: 100.50,
-						Type:   "debit",
-					},
-				}
-				m.On("GetTransactions", mock.Anything, uint64(1234)).
+				m.On("GetTransactions", mock.Anything, uint64(1234), internal.TransactionFilter{Limit: 3}).
 					Return(transactions, nil)
 			},
-			expected: []internal.Transaction{
-				{
-					ID:     "1",
-					Amount: 100.50,
-					Type:   "debit",
-				},
+			expected: internal.TransactionPage{
+				Transactions: transactions[:2],
+				NextCursor:   internal.CursorAfter(transactions[1]).Encode(),
 			},
 		},
 		{
-			name:   "error getting transactions",
-			userID: 1234,
+			name: "default limit",
+			setupMock: func(m *mockWalletRepo) {
+				m.On("GetTransactions", mock.Anything, uint64(1234), internal.TransactionFilter{Limit: services.DefaultTransactionsLimit + 1}).
+					Return(transactions, nil)
+			},
+			expected: internal.TransactionPage{Transactions: transactions},
+		},
+		{
+			name: "error getting transactions",
 			setupMock: func(m *mockWalletRepo) {
-				m.On("GetTransactions", mock.Anything, uint64(1234)).
+				m.On("GetTransactions", mock.Anything, uint64(1234), mock.Anything).
 					Return(nil, errors.New("database error"))
 			},
 			expectedError: errors.New("database error"),
@@ -125,14 +179,104 @@ func TestWalletService_GetTransactions(t *testing.T) {
 			tt.setupMock(mockRepo)
 
 			service := services.NewWalletService(mockRepo)
-			transactions, err := service.GetTransactions(context.Background(), tt.userID)
+			page, err := service.GetTransactions(context.Background(), 1234, tt.filter)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, page)
+			}
+
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWalletService_GetTransaction(t *testing.T) {
+	transaction := internal.Transaction{
+		ID:     "payment-123",
+		UserID: 1234,
+		Amount: usd(10050),
+		Type:   internal.TransactionTypePayment,
+		Status: internal.PaymentStatusPartiallyRefunded,
+		Method: "card",
+	}
+	history := []internal.TransactionStatusChange{
+		{To: internal.PaymentStatusPending, Reason: internal.StatusReasonCreated},
+		{From: internal.PaymentStatusPending, To: internal.PaymentStatusSuccess, Reason: internal.StatusReasonGatewayResponse},
+		{From: internal.PaymentStatusSuccess, To: internal.PaymentStatusPartiallyRefunded, Reason: internal.StatusReasonRefund},
+	}
+	refund := internal.Transaction{
+		ID:                    "refund-123",
+		UserID:                1234,
+		Amount:                usd(2500),
+		Type:                  internal.TransactionTypeRefund,
+		Status:                internal.PaymentStatusSuccess,
+		OriginalTransactionID: "payment-123",
+	}
+
+	tests := []struct {
+		name          string
+		setupMock     func(*mockWalletRepo)
+		expected      internal.TransactionDetail
+		expectedError error
+	}{
+		{
+			name: "successful transaction retrieval",
+			setupMock: func(m *mockWalletRepo) {
+				m.On("GetTransaction", mock.Anything, uint64(1234), "payment-123").Return(transaction, nil)
+				m.On("GetTransactionStatusHistory", mock.Anything, "payment-123").Return(history, nil)
+				m.On("GetLinkedTransactions", mock.Anything, transaction).Return([]internal.Transaction{refund}, nil)
+			},
+			expected: internal.TransactionDetail{
+				Transaction:        transaction,
+				StatusHistory:      history,
+				LinkedTransactions: []internal.Transaction{refund},
+			},
+		},
+		{
+			name: "transaction not found",
+			setupMock: func(m *mockWalletRepo) {
+				m.On("GetTransaction", mock.Anything, uint64(1234), "payment-123").
+					Return(internal.Transaction{}, fmt.Errorf("%w: payment-123", internal.ErrTransactionNotFound))
+			},
+			expectedError: internal.ErrTransactionNotFound,
+		},
+		{
+			name: "error getting status history",
+			setupMock: func(m *mockWalletRepo) {
+				m.On("GetTransaction", mock.Anything, uint64(1234), "payment-123").Return(transaction, nil)
+				m.On("GetTransactionStatusHistory", mock.Anything, "payment-123").Return(nil, errors.New("database error"))
+			},
+			expectedError: errors.New("database error"),
+		},
+		{
+			name: "error getting linked transactions",
+			setupMock: func(m *mockWalletRepo) {
+				m.On("GetTransaction", mock.Anything, uint64(1234), "payment-123").Return(transaction, nil)
+				m.On("GetTransactionStatusHistory", mock.Anything, "payment-123").Return(history, nil)
+				m.On("GetLinkedTransactions", mock.Anything, transaction).Return(nil, errors.New("database error"))
+			},
+			expectedError: errors.New("database error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(mockWalletRepo)
+			tt.setupMock(mockRepo)
+
+			service := services.NewWalletService(mockRepo)
+			transaction, err := service.GetTransaction(context.Background(), 1234, "payment-123")
 
 			if tt.expectedError != nil {
 				assert.Error(t, err)
 				assert.Contains(t, err.Error(), tt.expectedError.Error())
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tt.expected, transactions)
+				assert.Equal(t, tt.expected, transaction)
 			}
 
 			mockRepo.AssertExpectations(t)
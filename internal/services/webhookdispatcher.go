@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+)
+
+var (
+	ErrGettingWebhookDeliveries = errors.New("error getting webhook deliveries")
+)
+
+// deadDeliveriesLimit caps how many dead-lettered deliveries are listed
+const deadDeliveriesLimit = 100
+
+type WebhookDispatcherStorage interface {
+	FanOutOutboxEvents(ctx context.Context, limit int) (int, error)
+	ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]internal.WebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, deliveryID int64) error
+	RescheduleWebhookDelivery(ctx context.Context, deliveryID int64, nextAttemptAt time.Time, lastError string) error
+	DeadLetterWebhookDelivery(ctx context.Context, deliveryID int64, lastError string) error
+	GetDeadWebhookDeliveries(ctx context.Context, limit int) ([]internal.WebhookDelivery, error)
+}
+
+type WebhookSender interface {
+	Send(ctx context.Context, delivery internal.WebhookDelivery) error
+}
+
+// WebhookDispatcher delivers the events written to the outbox to every
+// active webhook subscription. Failed deliveries are retried with exponential
+// backoff until MaxAttempts, when they are dead-lettered.
+type WebhookDispatcher struct {
+	storage WebhookDispatcherStorage
+	sender  WebhookSender
+	cfg     config.WebhookConfig
+}
+
+func NewWebhookDispatcher(storage WebhookDispatcherStorage, sender WebhookSender, cfg config.WebhookConfig) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		storage: storage,
+		sender:  sender,
+		cfg:     cfg,
+	}
+}
+
+// Run dispatches due deliveries every poll interval until ctx is cancelled
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.DispatchDue(ctx)
+		}
+	}
+}
+
+// DispatchDue turns new outbox events into deliveries and sends one batch of
+// due deliveries concurrently
+func (d *WebhookDispatcher) DispatchDue(ctx context.Context) {
+	if _, err := d.storage.FanOutOutboxEvents(ctx, d.cfg.BatchSize); err != nil {
+		slog.ErrorContext(ctx, "Could not fan out outbox events", "error", err.Error())
+	}
+
+	// Claimed deliveries stay hidden until every send of the batch timed out
+	deliveries, err := d.storage.ClaimDueWebhookDeliveries(ctx, d.cfg.BatchSize, 2*d.cfg.Timeout)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not claim webhook deliveries", "error", err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Go(func() {
+			d.deliver(ctx, delivery)
+		})
+	}
+	wg.Wait()
+}
+
+// GetDeadDeliveries lists the deliveries that ran out of attempts
+func (d *WebhookDispatcher) GetDeadDeliveries(ctx context.Context) ([]internal.WebhookDelivery, error) {
+	deliveries, err := d.storage.GetDeadWebhookDeliveries(ctx, deadDeliveriesLimit)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrGettingWebhookDeliveries, err.Error())
+	}
+
+	return deliveries, nil
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery internal.WebhookDelivery) {
+	logger := slog.With(
+		"delivery_id", delivery.ID,
+		"subscription_id", delivery.SubscriptionID,
+		"event_id", delivery.Event.ID,
+		"event_type", delivery.Event.Type,
+	)
+
+	sendCtx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	err := d.sender.Send(sendCtx, delivery)
+	cancel()
+	if err == nil {
+		if err := d.storage.MarkWebhookDelivered(ctx, delivery.ID); err != nil {
+			logger.ErrorContext(ctx, "Could not mark webhook delivered", "error", err.Error())
+		}
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	if attempts >= d.cfg.MaxAttempts {
+		logger.ErrorContext(ctx, "Webhook delivery dead-lettered", "attempts", attempts, "error", err.Error())
+		if err := d.storage.DeadLetterWebhookDelivery(ctx, delivery.ID, err.Error()); err != nil {
+			logger.ErrorContext(ctx, "Could not dead-letter webhook delivery", "error", err.Error())
+		}
+		return
+	}
+
+	nextAttemptAt := time.Now().Add(d.backoff(delivery.Attempts))
+	logger.WarnContext(ctx, "Webhook delivery failed",
+		"attempts", attempts,
+		"next_attempt_at", nextAttemptAt,
+		"error", err.Error(),
+	)
+	if err := d.storage.RescheduleWebhookDelivery(ctx, delivery.ID, nextAttemptAt, err.Error()); err != nil {
+		logger.ErrorContext(ctx, "Could not reschedule webhook delivery", "error", err.Error())
+	}
+}
+
+// backoff doubles the wait after each failed attempt, capped at MaxBackoff
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.BaseBackoff
+	for range attempts {
+		delay *= 2
+		if delay >= d.cfg.MaxBackoff {
+			return d.cfg.MaxBackoff
+		}
+	}
+
+	return min(delay, d.cfg.MaxBackoff)
+}
@@ -0,0 +1,185 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockWebhookDispatcherStorage struct {
+	mock.Mock
+}
+
+func (m *mockWebhookDispatcherStorage) FanOutOutboxEvents(ctx context.Context, limit int) (int, error) {
+	args := m.Called(ctx, limit)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *mockWebhookDispatcherStorage) ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]internal.WebhookDelivery, error) {
+	args := m.Called(ctx, limit, lease)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.WebhookDelivery), args.Error(1)
+}
+
+func (m *mockWebhookDispatcherStorage) MarkWebhookDelivered(ctx context.Context, deliveryID int64) error {
+	args := m.Called(ctx, deliveryID)
+	return args.Error(0)
+}
+
+func (m *mockWebhookDispatcherStorage) RescheduleWebhookDelivery(ctx context.Context, deliveryID int64, nextAttemptAt time.Time, lastError string) error {
+	args := m.Called(ctx, deliveryID, nextAttemptAt, lastError)
+	return args.Error(0)
+}
+
+func (m *mockWebhookDispatcherStorage) DeadLetterWebhookDelivery(ctx context.Context, deliveryID int64, lastError string) error {
+	args := m.Called(ctx, deliveryID, lastError)
+	return args.Error(0)
+}
+
+func (m *mockWebhookDispatcherStorage) GetDeadWebhookDeliveries(ctx context.Context, limit int) ([]internal.WebhookDelivery, error) {
+	args := m.Called(ctx, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.WebhookDelivery), args.Error(1)
+}
+
+type mockWebhookSender struct {
+	mock.Mock
+}
+
+func (m *mockWebhookSender) Send(ctx context.Context, delivery internal.WebhookDelivery) error {
+	args := m.Called(ctx, delivery)
+	return args.Error(0)
+}
+
+var testWebhookDispatcherConfig = config.WebhookConfig{
+	PollInterval: time.Second,
+	BatchSize:    10,
+	Timeout:      time.Second,
+	MaxAttempts:  4,
+	BaseBackoff:  time.Second,
+	MaxBackoff:   time.Minute,
+}
+
+func TestWebhookDispatcher_DispatchDue(t *testing.T) {
+	delivery := internal.WebhookDelivery{
+		ID:             7,
+		SubscriptionID: "subscription-123",
+		URL:            "https://merchant.example.com/webhooks",
+		Secret:         "whsec_test",
+		Event:          internal.WebhookEvent{ID: 42, Type: internal.WebhookEventTransactionUpdated},
+		Status:         internal.WebhookDeliveryPending,
+		Attempts:       2,
+	}
+
+	tests := []struct {
+		name      string
+		setupMock func(*mockWebhookDispatcherStorage, *mockWebhookSender)
+	}{
+		{
+			name: "successful delivery is marked delivered",
+			setupMock: func(m *mockWebhookDispatcherStorage, s *mockWebhookSender) {
+				m.On("FanOutOutboxEvents", mock.Anything, 10).Return(1, nil)
+				m.On("ClaimDueWebhookDeliveries", mock.Anything, 10, 2*time.Second).
+					Return([]internal.WebhookDelivery{delivery}, nil)
+				s.On("Send", mock.Anything, delivery).Return(nil)
+				m.On("MarkWebhookDelivered", mock.Anything, int64(7)).Return(nil)
+			},
+		},
+		{
+			name: "failed delivery is rescheduled with backoff",
+			setupMock: func(m *mockWebhookDispatcherStorage, s *mockWebhookSender) {
+				m.On("FanOutOutboxEvents", mock.Anything, 10).Return(0, nil)
+				m.On("ClaimDueWebhookDeliveries", mock.Anything, 10, 2*time.Second).
+					Return([]internal.WebhookDelivery{delivery}, nil)
+				s.On("Send", mock.Anything, delivery).Return(errors.New("webhook answered with status 500"))
+				m.On("RescheduleWebhookDelivery", mock.Anything, int64(7), mock.MatchedBy(func(nextAttemptAt time.Time) bool {
+					// Two previous attempts: 1s doubled twice
+					delay := time.Until(nextAttemptAt)
+					return delay > 3*time.Second && delay <= 4*time.Second
+				}), "webhook answered with status 500").Return(nil)
+			},
+		},
+		{
+			name: "delivery out of attempts is dead-lettered",
+			setupMock: func(m *mockWebhookDispatcherStorage, s *mockWebhookSender) {
+				last := delivery
+				last.Attempts = 3
+				m.On("FanOutOutboxEvents", mock.Anything, 10).Return(0, nil)
+				m.On("ClaimDueWebhookDeliveries", mock.Anything, 10, 2*time.Second).
+					Return([]internal.WebhookDelivery{last}, nil)
+				s.On("Send", mock.Anything, last).Return(errors.New("connection refused"))
+				m.On("DeadLetterWebhookDelivery", mock.Anything, int64(7), "connection refused").Return(nil)
+			},
+		},
+		{
+			name: "fan out error still sends due deliveries",
+			setupMock: func(m *mockWebhookDispatcherStorage, s *mockWebhookSender) {
+				m.On("FanOutOutboxEvents", mock.Anything, 10).Return(0, errors.New("database error"))
+				m.On("ClaimDueWebhookDeliveries", mock.Anything, 10, 2*time.Second).
+					Return([]internal.WebhookDelivery{delivery}, nil)
+				s.On("Send", mock.Anything, delivery).Return(nil)
+				m.On("MarkWebhookDelivered", mock.Anything, int64(7)).Return(nil)
+			},
+		},
+		{
+			name: "claim error skips the batch",
+			setupMock: func(m *mockWebhookDispatcherStorage, s *mockWebhookSender) {
+				m.On("FanOutOutboxEvents", mock.Anything, 10).Return(0, nil)
+				m.On("ClaimDueWebhookDeliveries", mock.Anything, 10, 2*time.Second).
+					Return(nil, errors.New("database error"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockWebhookDispatcherStorage)
+			mockSender := new(mockWebhookSender)
+			tt.setupMock(mockStorage, mockSender)
+
+			dispatcher := services.NewWebhookDispatcher(mockStorage, mockSender, testWebhookDispatcherConfig)
+			dispatcher.DispatchDue(context.Background())
+
+			mockStorage.AssertExpectations(t)
+			mockSender.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWebhookDispatcher_GetDeadDeliveries(t *testing.T) {
+	t.Run("lists dead-lettered deliveries", func(t *testing.T) {
+		mockStorage := new(mockWebhookDispatcherStorage)
+		dead := []internal.WebhookDelivery{{ID: 7, Status: internal.WebhookDeliveryDead, Attempts: 4}}
+		mockStorage.On("GetDeadWebhookDeliveries", mock.Anything, 100).Return(dead, nil)
+
+		dispatcher := services.NewWebhookDispatcher(mockStorage, new(mockWebhookSender), testWebhookDispatcherConfig)
+		deliveries, err := dispatcher.GetDeadDeliveries(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, dead, deliveries)
+		mockStorage.AssertExpectations(t)
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		mockStorage := new(mockWebhookDispatcherStorage)
+		mockStorage.On("GetDeadWebhookDeliveries", mock.Anything, 100).Return(nil, errors.New("database error"))
+
+		dispatcher := services.NewWebhookDispatcher(mockStorage, new(mockWebhookSender), testWebhookDispatcherConfig)
+		deliveries, err := dispatcher.GetDeadDeliveries(context.Background())
+
+		assert.ErrorIs(t, err, services.ErrGettingWebhookDeliveries)
+		assert.Nil(t, deliveries)
+		mockStorage.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidWebhookSignature = internal.ErrInvalidWebhookSignature
+	ErrInvalidWebhookEvent     = internal.ErrInvalidWebhookEvent
+	ErrProcessingWebhook       = errors.New("error processing webhook")
+)
+
+const (
+	webhookStatusApproved = "approved"
+	webhookStatusDeclined = "declined"
+	webhookStatusPending  = "pending"
+)
+
+type WebhookStorage interface {
+	TransactionUpdater
+	GetTransactionByID(ctx context.Context, transactionID string) (internal.Transaction, error)
+}
+
+// WebhookService settles pending transactions with the outcome the gateway
+// notifies through webhooks. Events are applied only to pending transactions,
+// so late or out of order events never change a final status.
+type WebhookService struct {
+	storage WebhookStorage
+	cfg     config.GatewayConfig
+}
+
+func NewWebhookService(storage WebhookStorage, cfg config.GatewayConfig) *WebhookService {
+	return &WebhookService{
+		storage: storage,
+		cfg:     cfg,
+	}
+}
+
+// ProcessWebhook verifies the signature of payload, an HMAC-SHA256 in hex of
+// "<timestamp>.<payload>" keyed with the webhook secret, and applies the event
+// it carries. The event is recorded in the same database transaction that
+// settles the payment, so events already processed are ignored and events
+// that failed to apply are processed again when redelivered.
+func (s *WebhookService) ProcessWebhook(ctx context.Context, payload []byte, timestamp string, signature string) error {
+	if err := s.verifySignature(payload, timestamp, signature); err != nil {
+		return err
+	}
+
+	var event internal.GatewayWebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidWebhookEvent, err.Error())
+	}
+	if event.ID == "" {
+		return fmt.Errorf("%w: missing event id", ErrInvalidWebhookEvent)
+	}
+	if _, err := uuid.Parse(event.TransactionID); err != nil {
+		return fmt.Errorf("%w: invalid transaction_id %s", ErrInvalidWebhookEvent, event.TransactionID)
+	}
+
+	var status string
+	switch event.Status {
+	case webhookStatusApproved:
+		status = internal.PaymentStatusSuccess
+	case webhookStatusDeclined:
+		status = internal.PaymentStatusFailed
+	case webhookStatusPending:
+		status = internal.PaymentStatusPending
+	default:
+		return fmt.Errorf("%w: unknown status %s", ErrInvalidWebhookEvent, event.Status)
+	}
+
+	return s.apply(internal.WithWebhookEvent(ctx, event), event, status)
+}
+
+// apply records the final status of the transaction of event
+func (s *WebhookService) apply(ctx context.Context, event internal.GatewayWebhookEvent, status string) error {
+	logger := slog.With("event_id", event.ID, "transaction_id", event.TransactionID, "status", status)
+
+	if status == internal.PaymentStatusPending {
+		logger.InfoContext(ctx, "Webhook event without final status ignored")
+		return nil
+	}
+
+	transaction, err := s.storage.GetTransactionByID(ctx, event.TransactionID)
+	if errors.Is(err, internal.ErrTransactionNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProcessingWebhook, err.Error())
+	}
+	if transaction.Status != internal.PaymentStatusPending {
+		logger.InfoContext(ctx, "Webhook event for finalised transaction ignored", "current_status", transaction.Status)
+		return nil
+	}
+
+	// Webhooks only come from the main gateway, but the transaction may have
+	// been routed to another one, so the recorded gateway is kept
+	gatewayResponse := internal.GatewayResponse{
+		Gateway:      transaction.GatewayName,
+		Reference:    event.Reference,
+		ResponseCode: event.ResponseCode,
+		Status:       status,
+	}
+	paymentRequest := internal.PaymentRequest{
+		UserID: transaction.UserID,
+		Amount: transaction.Amount,
+	}
+	err = updateTransaction(ctx, s.storage, transaction.Type, paymentRequest, transaction.ID, status, gatewayResponse)
+	if errors.Is(err, internal.ErrTransactionNotPending) {
+		logger.InfoContext(ctx, "Webhook event for finalised transaction ignored")
+		return nil
+	}
+	if errors.Is(err, internal.ErrWebhookEventDuplicate) {
+		logger.InfoContext(ctx, "Duplicate webhook event ignored")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProcessingWebhook, err.Error())
+	}
+
+	logger.InfoContext(ctx, "Webhook event finalised transaction", "transaction_type", transaction.Type)
+	return nil
+}
+
+func (s *WebhookService) verifySignature(payload []byte, timestamp string, signature string) error {
+	if s.cfg.WebhookSecret == "" {
+		return fmt.Errorf("%w: webhook secret not configured", ErrInvalidWebhookSignature)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrInvalidWebhookSignature, timestamp)
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > s.cfg.WebhookTolerance || age < -s.cfg.WebhookTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidWebhookSignature)
+	}
+
+	received, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(received, internal.SignWebhook(s.cfg.WebhookSecret, timestamp, payload)) {
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidWebhookSignature)
+	}
+
+	return nil
+}
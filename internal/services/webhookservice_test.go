@@ -0,0 +1,205 @@
+package services_test
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/config"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockWebhookStorage struct {
+	mock.Mock
+}
+
+func (m *mockWebhookStorage) UpdatePaymentRequest(ctx context.Context, paymentRequest internal.PaymentRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, paymentRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockWebhookStorage) UpdateDepositRequest(ctx context.Context, depositRequest internal.DepositRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, depositRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockWebhookStorage) UpdateRefundRequest(ctx context.Context, refundRequest internal.RefundRequest, transactionID string, status string, gatewayResponse internal.GatewayResponse) error {
+	args := m.Called(ctx, refundRequest, transactionID, status, gatewayResponse)
+	return args.Error(0)
+}
+
+func (m *mockWebhookStorage) GetTransactionByID(ctx context.Context, transactionID string) (internal.Transaction, error) {
+	args := m.Called(ctx, transactionID)
+	return args.Get(0).(internal.Transaction), args.Error(1)
+}
+
+const (
+	testWebhookSecret = "whsec_test"
+	testTransactionID = "550e8400-e29b-41d4-a716-446655440000"
+)
+
+var testWebhookConfig = config.GatewayConfig{
+	Name:             "stub",
+	WebhookSecret:    testWebhookSecret,
+	WebhookTolerance: 5 * time.Minute,
+}
+
+func signedWebhook(payload string, at time.Time) (string, string) {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return timestamp, hex.EncodeToString(internal.SignWebhook(testWebhookSecret, timestamp, []byte(payload)))
+}
+
+func TestWebhookService_ProcessWebhook(t *testing.T) {
+	approved := fmt.Sprintf(`{"id": "evt-1", "transaction_id": %q, "reference": "gateway-tx-123", "status": "approved", "response_code": "approved"}`, testTransactionID)
+	event := internal.GatewayWebhookEvent{ID: "evt-1", TransactionID: testTransactionID, Reference: "gateway-tx-123", Status: "approved", ResponseCode: "approved"}
+	pendingPayment := internal.Transaction{ID: testTransactionID, UserID: 1234, Amount: usd(10050), Type: internal.TransactionTypePayment, Status: internal.PaymentStatusPending, GatewayName: "acquirer_b"}
+	gatewayResponse := internal.GatewayResponse{Gateway: "acquirer_b", Reference: "gateway-tx-123", ResponseCode: "approved", Status: internal.PaymentStatusSuccess}
+	withEvent := mock.MatchedBy(func(ctx context.Context) bool {
+		recorded, ok := internal.WebhookEventFromContext(ctx)
+		return ok && recorded == event
+	})
+
+	tests := []struct {
+		name          string
+		payload       string
+		sign          func(payload string) (string, string)
+		setupMocks    func(*mockWebhookStorage)
+		expectedError error
+	}{
+		{
+			name:    "approved event settles pending payment, keeping its gateway",
+			payload: approved,
+			setupMocks: func(ws *mockWebhookStorage) {
+				ws.On("GetTransactionByID", mock.Anything, testTransactionID).Return(pendingPayment, nil)
+				ws.On("UpdatePaymentRequest", withEvent, internal.PaymentRequest{UserID: 1234, Amount: usd(10050)}, testTransactionID, internal.PaymentStatusSuccess, gatewayResponse).Return(nil)
+			},
+		},
+		{
+			name:    "declined event fails pending deposit",
+			payload: fmt.Sprintf(`{"id": "evt-2", "transaction_id": %q, "status": "declined", "response_code": "do_not_honor"}`, testTransactionID),
+			setupMocks: func(ws *mockWebhookStorage) {
+				deposit := pendingPayment
+				deposit.Type = internal.TransactionTypeDeposit
+				ws.On("GetTransactionByID", mock.Anything, testTransactionID).Return(deposit, nil)
+				ws.On("UpdateDepositRequest", mock.Anything, internal.DepositRequest{UserID: 1234, Amount: usd(10050)}, testTransactionID, internal.PaymentStatusFailed,
+					internal.GatewayResponse{Gateway: "acquirer_b", ResponseCode: "do_not_honor", Status: internal.PaymentStatusFailed}).Return(nil)
+			},
+		},
+		{
+			name:    "duplicate event is ignored",
+			payload: approved,
+			setupMocks: func(ws *mockWebhookStorage) {
+				ws.On("GetTransactionByID", mock.Anything, testTransactionID).Return(pendingPayment, nil)
+				ws.On("UpdatePaymentRequest", withEvent, mock.Anything, testTransactionID, internal.PaymentStatusSuccess, mock.Anything).Return(fmt.Errorf("%w: evt-1", internal.ErrWebhookEventDuplicate))
+			},
+		},
+		{
+			name:       "pending event never moves a final status back",
+			payload:    fmt.Sprintf(`{"id": "evt-3", "transaction_id": %q, "status": "pending"}`, testTransactionID),
+			setupMocks: func(ws *mockWebhookStorage) {},
+		},
+		{
+			name:    "late event for finalised transaction is ignored",
+			payload: approved,
+			setupMocks: func(ws *mockWebhookStorage) {
+				failed := pendingPayment
+				failed.Status = internal.PaymentStatusFailed
+				ws.On("GetTransactionByID", mock.Anything, testTransactionID).Return(failed, nil)
+			},
+		},
+		{
+			name:    "transaction finalised concurrently",
+			payload: approved,
+			setupMocks: func(ws *mockWebhookStorage) {
+				ws.On("GetTransactionByID", mock.Anything, testTransactionID).Return(pendingPayment, nil)
+				ws.On("UpdatePaymentRequest", mock.Anything, mock.Anything, testTransactionID, internal.PaymentStatusSuccess, mock.Anything).Return(internal.ErrTransactionNotPending)
+			},
+		},
+		{
+			name:    "failed update is reported so the redelivery is processed",
+			payload: approved,
+			setupMocks: func(ws *mockWebhookStorage) {
+				ws.On("GetTransactionByID", mock.Anything, testTransactionID).Return(pendingPayment, nil)
+				ws.On("UpdatePaymentRequest", mock.Anything, mock.Anything, testTransactionID, internal.PaymentStatusSuccess, mock.Anything).Return(errors.New("database error"))
+			},
+			expectedError: services.ErrProcessingWebhook,
+		},
+		{
+			name:    "unknown transaction",
+			payload: approved,
+			setupMocks: func(ws *mockWebhookStorage) {
+				ws.On("GetTransactionByID", mock.Anything, testTransactionID).Return(internal.Transaction{}, internal.ErrTransactionNotFound)
+			},
+			expectedError: internal.ErrTransactionNotFound,
+		},
+		{
+			name:    "tampered payload",
+			payload: approved,
+			sign: func(payload string) (string, string) {
+				return signedWebhook(payload+" ", time.Now())
+			},
+			setupMocks:    func(ws *mockWebhookStorage) {},
+			expectedError: services.ErrInvalidWebhookSignature,
+		},
+		{
+			name:    "timestamp outside tolerance",
+			payload: approved,
+			sign: func(payload string) (string, string) {
+				return signedWebhook(payload, time.Now().Add(-10*time.Minute))
+			},
+			setupMocks:    func(ws *mockWebhookStorage) {},
+			expectedError: services.ErrInvalidWebhookSignature,
+		},
+		{
+			name:          "unknown status",
+			payload:       fmt.Sprintf(`{"id": "evt-4", "transaction_id": %q, "status": "maybe"}`, testTransactionID),
+			setupMocks:    func(ws *mockWebhookStorage) {},
+			expectedError: services.ErrInvalidWebhookEvent,
+		},
+		{
+			name:          "malformed event",
+			payload:       `{"id": `,
+			setupMocks:    func(ws *mockWebhookStorage) {},
+			expectedError: services.ErrInvalidWebhookEvent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := new(mockWebhookStorage)
+			tt.setupMocks(storage)
+			sign := tt.sign
+			if sign == nil {
+				sign = func(payload string) (string, string) { return signedWebhook(payload, time.Now()) }
+			}
+			timestamp, signature := sign(tt.payload)
+
+			err := services.NewWebhookService(storage, testWebhookConfig).ProcessWebhook(context.Background(), []byte(tt.payload), timestamp, signature)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			storage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWebhookService_RejectsAllWithoutSecret(t *testing.T) {
+	payload := fmt.Sprintf(`{"id": "evt-1", "transaction_id": %q, "status": "approved"}`, testTransactionID)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	cfg := testWebhookConfig
+	cfg.WebhookSecret = ""
+
+	err := services.NewWebhookService(new(mockWebhookStorage), cfg).ProcessWebhook(context.Background(), []byte(payload), timestamp, hex.EncodeToString(internal.SignWebhook("", timestamp, []byte(payload))))
+
+	assert.ErrorIs(t, err, services.ErrInvalidWebhookSignature)
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidWebhookURL           = internal.ErrInvalidWebhookURL
+	ErrSubscriptionNotFound        = internal.ErrSubscriptionNotFound
+	ErrManagingWebhookSubscription = errors.New("error managing webhook subscription")
+)
+
+// webhookSecretPrefix marks the secrets we hand out to subscribers
+const webhookSecretPrefix = "whsec_"
+
+type WebhookSubscriptionStorage interface {
+	CreateWebhookSubscription(ctx context.Context, subscription internal.WebhookSubscription) (internal.WebhookSubscription, error)
+	GetWebhookSubscriptions(ctx context.Context) ([]internal.WebhookSubscription, error)
+	GetWebhookSubscription(ctx context.Context, subscriptionID string) (internal.WebhookSubscription, error)
+	UpdateWebhookSubscription(ctx context.Context, subscription internal.WebhookSubscription) (internal.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error
+}
+
+// WebhookSubscriptionService manages the endpoints that receive our webhooks.
+// Each subscription gets its own signing secret, shown only once when it is
+// created.
+type WebhookSubscriptionService struct {
+	storage WebhookSubscriptionStorage
+}
+
+func NewWebhookSubscriptionService(storage WebhookSubscriptionStorage) *WebhookSubscriptionService {
+	return &WebhookSubscriptionService{storage: storage}
+}
+
+// CreateSubscription registers a new endpoint and returns it with its secret
+func (s *WebhookSubscriptionService) CreateSubscription(ctx context.Context, request internal.WebhookSubscriptionRequest) (internal.WebhookSubscription, error) {
+	if err := validateWebhookURL(request.URL); err != nil {
+		return internal.WebhookSubscription{}, err
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return internal.WebhookSubscription{}, fmt.Errorf("%w: %s", ErrManagingWebhookSubscription, err.Error())
+	}
+
+	subscription := internal.WebhookSubscription{
+		ID:     uuid.New().String(),
+		URL:    request.URL,
+		Secret: secret,
+		Active: request.Active == nil || *request.Active,
+	}
+	subscription, err = s.storage.CreateWebhookSubscription(ctx, subscription)
+	if err != nil {
+		return internal.WebhookSubscription{}, fmt.Errorf("%w: %s", ErrManagingWebhookSubscription, err.Error())
+	}
+
+	return subscription, nil
+}
+
+// GetSubscriptions lists every subscription, without their secrets
+func (s *WebhookSubscriptionService) GetSubscriptions(ctx context.Context) ([]internal.WebhookSubscription, error) {
+	subscriptions, err := s.storage.GetWebhookSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrManagingWebhookSubscription, err.Error())
+	}
+
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+
+	return subscriptions, nil
+}
+
+// GetSubscription returns a subscription without its secret
+func (s *WebhookSubscriptionService) GetSubscription(ctx context.Context, subscriptionID string) (internal.WebhookSubscription, error) {
+	subscription, err := s.storage.GetWebhookSubscription(ctx, subscriptionID)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return internal.WebhookSubscription{}, err
+	}
+	if err != nil {
+		return internal.WebhookSubscription{}, fmt.Errorf("%w: %s", ErrManagingWebhookSubscription, err.Error())
+	}
+
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+// UpdateSubscription changes the URL of a subscription and, when given,
+// whether it is active
+func (s *WebhookSubscriptionService) UpdateSubscription(ctx context.Context, subscriptionID string, request internal.WebhookSubscriptionRequest) (internal.WebhookSubscription, error) {
+	if err := validateWebhookURL(request.URL); err != nil {
+		return internal.WebhookSubscription{}, err
+	}
+
+	subscription, err := s.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return internal.WebhookSubscription{}, err
+	}
+
+	subscription.URL = request.URL
+	if request.Active != nil {
+		subscription.Active = *request.Active
+	}
+	subscription, err = s.storage.UpdateWebhookSubscription(ctx, subscription)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return internal.WebhookSubscription{}, err
+	}
+	if err != nil {
+		return internal.WebhookSubscription{}, fmt.Errorf("%w: %s", ErrManagingWebhookSubscription, err.Error())
+	}
+
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+// DeleteSubscription removes a subscription and its pending deliveries
+func (s *WebhookSubscriptionService) DeleteSubscription(ctx context.Context, subscriptionID string) error {
+	err := s.storage.DeleteWebhookSubscription(ctx, subscriptionID)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrManagingWebhookSubscription, err.Error())
+	}
+
+	return nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%w: %s", ErrInvalidWebhookURL, rawURL)
+	}
+
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return webhookSecretPrefix + hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,200 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal"
+	"github.com/2000fer/backend-challenge-payments-and-wallet/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockWebhookSubscriptionStorage struct {
+	mock.Mock
+}
+
+func (m *mockWebhookSubscriptionStorage) CreateWebhookSubscription(ctx context.Context, subscription internal.WebhookSubscription) (internal.WebhookSubscription, error) {
+	args := m.Called(ctx, subscription)
+	return args.Get(0).(internal.WebhookSubscription), args.Error(1)
+}
+
+func (m *mockWebhookSubscriptionStorage) GetWebhookSubscriptions(ctx context.Context) ([]internal.WebhookSubscription, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]internal.WebhookSubscription), args.Error(1)
+}
+
+func (m *mockWebhookSubscriptionStorage) GetWebhookSubscription(ctx context.Context, subscriptionID string) (internal.WebhookSubscription, error) {
+	args := m.Called(ctx, subscriptionID)
+	return args.Get(0).(internal.WebhookSubscription), args.Error(1)
+}
+
+func (m *mockWebhookSubscriptionStorage) UpdateWebhookSubscription(ctx context.Context, subscription internal.WebhookSubscription) (internal.WebhookSubscription, error) {
+	args := m.Called(ctx, subscription)
+	return args.Get(0).(internal.WebhookSubscription), args.Error(1)
+}
+
+func (m *mockWebhookSubscriptionStorage) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) error {
+	args := m.Called(ctx, subscriptionID)
+	return args.Error(0)
+}
+
+func TestWebhookSubscriptionService_CreateSubscription(t *testing.T) {
+	created := internal.WebhookSubscription{ID: "subscription-123", URL: "https://merchant.example.com/webhooks", Secret: "whsec_test", Active: true}
+	inactive := false
+
+	tests := []struct {
+		name          string
+		request       internal.WebhookSubscriptionRequest
+		setupMock     func(*mockWebhookSubscriptionStorage)
+		expectedError error
+	}{
+		{
+			name:    "creates an active subscription with a secret",
+			request: internal.WebhookSubscriptionRequest{URL: "https://merchant.example.com/webhooks"},
+			setupMock: func(m *mockWebhookSubscriptionStorage) {
+				m.On("CreateWebhookSubscription", mock.Anything, mock.MatchedBy(func(s internal.WebhookSubscription) bool {
+					return s.URL == "https://merchant.example.com/webhooks" && s.Active && strings.HasPrefix(s.Secret, "whsec_")
+				})).Return(created, nil)
+			},
+		},
+		{
+			name:    "creates an inactive subscription",
+			request: internal.WebhookSubscriptionRequest{URL: "http://localhost:3000/hooks", Active: &inactive},
+			setupMock: func(m *mockWebhookSubscriptionStorage) {
+				m.On("CreateWebhookSubscription", mock.Anything, mock.MatchedBy(func(s internal.WebhookSubscription) bool {
+					return !s.Active
+				})).Return(created, nil)
+			},
+		},
+		{
+			name:          "relative url",
+			request:       internal.WebhookSubscriptionRequest{URL: "/webhooks"},
+			setupMock:     func(m *mockWebhookSubscriptionStorage) {},
+			expectedError: services.ErrInvalidWebhookURL,
+		},
+		{
+			name:          "unsupported scheme",
+			request:       internal.WebhookSubscriptionRequest{URL: "ftp://merchant.example.com/webhooks"},
+			setupMock:     func(m *mockWebhookSubscriptionStorage) {},
+			expectedError: services.ErrInvalidWebhookURL,
+		},
+		{
+			name:    "storage error",
+			request: internal.WebhookSubscriptionRequest{URL: "https://merchant.example.com/webhooks"},
+			setupMock: func(m *mockWebhookSubscriptionStorage) {
+				m.On("CreateWebhookSubscription", mock.Anything, mock.Anything).
+					Return(internal.WebhookSubscription{}, errors.New("database error"))
+			},
+			expectedError: services.ErrManagingWebhookSubscription,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockWebhookSubscriptionStorage)
+			tt.setupMock(mockStorage)
+
+			service := services.NewWebhookSubscriptionService(mockStorage)
+			subscription, err := service.CreateSubscription(context.Background(), tt.request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				// The secret is only returned on creation
+				assert.Equal(t, created, subscription)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWebhookSubscriptionService_HidesSecrets(t *testing.T) {
+	stored := internal.WebhookSubscription{ID: "subscription-123", URL: "https://merchant.example.com/webhooks", Secret: "whsec_test", Active: true}
+	mockStorage := new(mockWebhookSubscriptionStorage)
+	mockStorage.On("GetWebhookSubscriptions", mock.Anything).Return([]internal.WebhookSubscription{stored}, nil)
+	mockStorage.On("GetWebhookSubscription", mock.Anything, "subscription-123").Return(stored, nil)
+	service := services.NewWebhookSubscriptionService(mockStorage)
+
+	subscriptions, err := service.GetSubscriptions(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, subscriptions, 1)
+	assert.Empty(t, subscriptions[0].Secret)
+
+	subscription, err := service.GetSubscription(context.Background(), "subscription-123")
+	assert.NoError(t, err)
+	assert.Empty(t, subscription.Secret)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestWebhookSubscriptionService_UpdateSubscription(t *testing.T) {
+	stored := internal.WebhookSubscription{ID: "subscription-123", URL: "https://merchant.example.com/webhooks", Secret: "whsec_test", Active: true}
+	inactive := false
+
+	tests := []struct {
+		name          string
+		request       internal.WebhookSubscriptionRequest
+		setupMock     func(*mockWebhookSubscriptionStorage)
+		expectedError error
+	}{
+		{
+			name:    "changes url and keeps active flag",
+			request: internal.WebhookSubscriptionRequest{URL: "https://merchant.example.com/v2/webhooks"},
+			setupMock: func(m *mockWebhookSubscriptionStorage) {
+				m.On("GetWebhookSubscription", mock.Anything, "subscription-123").Return(stored, nil)
+				m.On("UpdateWebhookSubscription", mock.Anything, mock.MatchedBy(func(s internal.WebhookSubscription) bool {
+					return s.URL == "https://merchant.example.com/v2/webhooks" && s.Active
+				})).Return(stored, nil)
+			},
+		},
+		{
+			name:    "deactivates the subscription",
+			request: internal.WebhookSubscriptionRequest{URL: stored.URL, Active: &inactive},
+			setupMock: func(m *mockWebhookSubscriptionStorage) {
+				m.On("GetWebhookSubscription", mock.Anything, "subscription-123").Return(stored, nil)
+				m.On("UpdateWebhookSubscription", mock.Anything, mock.MatchedBy(func(s internal.WebhookSubscription) bool {
+					return !s.Active
+				})).Return(stored, nil)
+			},
+		},
+		{
+			name:    "subscription not found",
+			request: internal.WebhookSubscriptionRequest{URL: stored.URL},
+			setupMock: func(m *mockWebhookSubscriptionStorage) {
+				m.On("GetWebhookSubscription", mock.Anything, "subscription-123").
+					Return(internal.WebhookSubscription{}, internal.ErrSubscriptionNotFound)
+			},
+			expectedError: services.ErrSubscriptionNotFound,
+		},
+		{
+			name:          "invalid url",
+			request:       internal.WebhookSubscriptionRequest{URL: "merchant.example.com"},
+			setupMock:     func(m *mockWebhookSubscriptionStorage) {},
+			expectedError: services.ErrInvalidWebhookURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mockWebhookSubscriptionStorage)
+			tt.setupMock(mockStorage)
+
+			service := services.NewWebhookSubscriptionService(mockStorage)
+			subscription, err := service.UpdateSubscription(context.Background(), "subscription-123", tt.request)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Empty(t, subscription.Secret)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Headers of signed webhooks, both received from the gateway and sent to
+// webhook subscriptions
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// SignWebhook returns the HMAC-SHA256 of "<timestamp>.<payload>" keyed with
+// secret
+func SignWebhook(secret string, timestamp string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+type webhookEventContextKey struct{}
+
+// WithWebhookEvent returns a copy of ctx carrying the gateway webhook event
+// being applied, so storage records it with the status change it causes
+func WithWebhookEvent(ctx context.Context, event GatewayWebhookEvent) context.Context {
+	return context.WithValue(ctx, webhookEventContextKey{}, event)
+}
+
+// WebhookEventFromContext returns the gateway webhook event carried by ctx, if
+// any
+func WebhookEventFromContext(ctx context.Context) (GatewayWebhookEvent, bool) {
+	event, ok := ctx.Value(webhookEventContextKey{}).(GatewayWebhookEvent)
+	return event, ok
+}
@@ -1,22 +1,364 @@
--- User balances table
+-- User balances table, one row per currency held by the wallet
 CREATE TABLE IF NOT EXISTS user_balances (
-    user_id BIGINT PRIMARY KEY,
-    balance DECIMAL(15, 2) NOT NULL DEFAULT 0,
-    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+    user_id BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    balance DECIMAL(19, 4) NOT NULL DEFAULT 0,
+    held_balance DECIMAL(19, 4) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, currency)
 );
 
+-- Columns added after the table was first created, so existing databases get
+-- them too
+ALTER TABLE user_balances ADD COLUMN IF NOT EXISTS held_balance DECIMAL(19, 4) NOT NULL DEFAULT 0;
+ALTER TABLE user_balances ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE user_balances ALTER COLUMN balance TYPE DECIMAL(19, 4);
+ALTER TABLE user_balances ALTER COLUMN held_balance TYPE DECIMAL(19, 4);
+
 -- Transactions table
 CREATE TABLE IF NOT EXISTS transactions (
     id UUID PRIMARY KEY,
     user_id BIGINT NOT NULL,
-    amount DECIMAL(15, 2) NOT NULL,
+    amount DECIMAL(19, 4) NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
     transaction_type VARCHAR(20) NOT NULL,
     status VARCHAR(20) NOT NULL,
+    method VARCHAR(20),
+    gateway_reference VARCHAR(255),
+    gateway_name VARCHAR(50),
+    gateway_response_code VARCHAR(50),
+    transfer_id UUID,
+    original_transaction_id UUID REFERENCES transactions(id),
+    exchange_rate DECIMAL(20, 8),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES user_balances(user_id) ON DELETE CASCADE
+    FOREIGN KEY (user_id, currency) REFERENCES user_balances(user_id, currency) ON DELETE CASCADE
 );
 
+-- Columns added after the table was first created, so existing databases get
+-- them too
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS method VARCHAR(20);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS gateway_reference VARCHAR(255);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS gateway_name VARCHAR(50);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS gateway_response_code VARCHAR(50);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS transfer_id UUID;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS original_transaction_id UUID REFERENCES transactions(id);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE transactions ALTER COLUMN amount TYPE DECIMAL(19, 4);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS exchange_rate DECIMAL(20, 8);
+
+-- Balances were keyed by user alone before wallets held several currencies.
+-- Existing balances, transactions and holds are in USD, the column default.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM pg_index i
+        JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY (i.indkey)
+        WHERE i.indrelid = 'user_balances'::regclass AND i.indisprimary AND a.attname = 'currency'
+    ) THEN
+        ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_user_id_fkey;
+        ALTER TABLE IF EXISTS payment_holds DROP CONSTRAINT IF EXISTS payment_holds_user_id_fkey;
+        ALTER TABLE user_balances DROP CONSTRAINT user_balances_pkey;
+        ALTER TABLE user_balances ADD PRIMARY KEY (user_id, currency);
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'transactions_user_id_currency_fkey') THEN
+        ALTER TABLE transactions ADD CONSTRAINT transactions_user_id_currency_fkey
+            FOREIGN KEY (user_id, currency) REFERENCES user_balances(user_id, currency) ON DELETE CASCADE;
+    END IF;
+END $$;
+
 -- Index for faster lookups
 CREATE INDEX IF NOT EXISTS idx_transactions_user_id ON transactions(user_id);
-CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions(created_at);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions(created_at);
+CREATE INDEX IF NOT EXISTS idx_transactions_original_transaction_id ON transactions(original_transaction_id) WHERE original_transaction_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_transactions_transfer_id ON transactions(transfer_id) WHERE transfer_id IS NOT NULL;
+
+-- Idempotency keys for retried requests, scoped per user
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id BIGINT NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INTEGER,
+    response_body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    -- Requests in progress hold the key until locked_at plus a lease
+    locked_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    -- First transaction or hold created by the request, which is then never
+    -- run again
+    resource_id UUID,
+    PRIMARY KEY (user_id, idempotency_key)
+);
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS resource_id UUID;
+
+
+-- Payments, deposits and refunds whose final status could not be recorded, retried in background
+CREATE TABLE IF NOT EXISTS payment_contingencies (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    transaction_type VARCHAR(20) NOT NULL DEFAULT 'payment',
+    user_id BIGINT NOT NULL,
+    method VARCHAR(20) NOT NULL,
+    amount DECIMAL(19, 4) NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    intended_status VARCHAR(20) NOT NULL,
+    gateway_reference VARCHAR(255),
+    gateway_name VARCHAR(50),
+    gateway_response_code VARCHAR(50),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE payment_contingencies ADD COLUMN IF NOT EXISTS transaction_type VARCHAR(20) NOT NULL DEFAULT 'payment';
+ALTER TABLE payment_contingencies ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE payment_contingencies ALTER COLUMN amount TYPE DECIMAL(19, 4);
+
+CREATE INDEX IF NOT EXISTS idx_payment_contingencies_due ON payment_contingencies(next_attempt_at) WHERE resolved_at IS NULL;
+
+-- Double-entry ledger. Postings are signed (credits positive, debits negative)
+-- and the postings of every entry must sum to zero
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(100) NOT NULL UNIQUE,
+    account_type VARCHAR(20) NOT NULL,
+    user_id BIGINT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id UUID PRIMARY KEY,
+    transaction_id UUID,
+    description VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id UUID NOT NULL REFERENCES ledger_entries(id),
+    account_id BIGINT NOT NULL REFERENCES ledger_accounts(id),
+    amount DECIMAL(19, 4) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE ledger_postings ALTER COLUMN amount TYPE DECIMAL(19, 4);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_transaction_id ON ledger_entries(transaction_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_entry_id ON ledger_postings(entry_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_account_id ON ledger_postings(account_id);
+
+-- Reject, at commit time, any entry whose postings do not sum to zero
+CREATE OR REPLACE FUNCTION check_ledger_entry_balanced() RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM ledger_postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_postings_balanced ON ledger_postings;
+CREATE CONSTRAINT TRIGGER ledger_postings_balanced
+    AFTER INSERT ON ledger_postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry_balanced();
+
+-- Ledger accounts created before wallets held several currencies were in USD
+UPDATE ledger_accounts SET code = code || ':USD'
+WHERE code ~ '^(wallet:[0-9]+|system:[a-z_]+)$';
+
+-- Opening balance entries for wallets funded before the ledger existed
+INSERT INTO ledger_accounts (code, account_type)
+SELECT DISTINCT 'system:opening_balances:' || currency, 'system'
+FROM user_balances
+WHERE balance <> 0
+ON CONFLICT (code) DO NOTHING;
+
+WITH missing AS (
+    SELECT b.user_id, b.currency, b.balance, gen_random_uuid() AS entry_id
+    FROM user_balances b
+    WHERE b.balance <> 0
+      AND NOT EXISTS (SELECT 1 FROM ledger_accounts a WHERE a.code = 'wallet:' || b.user_id || ':' || b.currency)
+), wallet_accounts AS (
+    INSERT INTO ledger_accounts (code, account_type, user_id)
+    SELECT 'wallet:' || user_id || ':' || currency, 'wallet', user_id FROM missing
+    RETURNING id, code
+), entries AS (
+    INSERT INTO ledger_entries (id, description)
+    SELECT entry_id, 'opening balance' FROM missing
+)
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT m.entry_id, w.id, m.balance
+FROM missing m JOIN wallet_accounts w ON w.code = 'wallet:' || m.user_id || ':' || m.currency
+UNION ALL
+SELECT m.entry_id, o.id, -m.balance
+FROM missing m JOIN ledger_accounts o ON o.code = 'system:opening_balances:' || m.currency;
+
+-- Funds reserved by authorizations, captured or released later. The amount of
+-- active holds is kept in user_balances.held_balance
+CREATE TABLE IF NOT EXISTS payment_holds (
+    id UUID PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    method VARCHAR(20) NOT NULL,
+    amount DECIMAL(19, 4) NOT NULL,
+    -- Payment method fields, e.g. the card token, sent to the gateway on capture
+    details JSONB,
+    captured_amount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    transaction_id UUID REFERENCES transactions(id),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id, currency) REFERENCES user_balances(user_id, currency) ON DELETE CASCADE
+);
+
+ALTER TABLE payment_holds ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE payment_holds ALTER COLUMN amount TYPE DECIMAL(19, 4);
+ALTER TABLE payment_holds ALTER COLUMN captured_amount TYPE DECIMAL(19, 4);
+ALTER TABLE payment_holds ADD COLUMN IF NOT EXISTS details JSONB;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'payment_holds_user_id_currency_fkey') THEN
+        ALTER TABLE payment_holds ADD CONSTRAINT payment_holds_user_id_currency_fkey
+            FOREIGN KEY (user_id, currency) REFERENCES user_balances(user_id, currency) ON DELETE CASCADE;
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_payment_holds_user_id ON payment_holds(user_id);
+CREATE INDEX IF NOT EXISTS idx_payment_holds_expiring ON payment_holds(expires_at) WHERE status = 'active';
+
+-- Webhook events already processed, so redeliveries from the gateway are ignored
+CREATE TABLE IF NOT EXISTS gateway_webhook_events (
+    event_id VARCHAR(255) PRIMARY KEY,
+    transaction_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    received_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Transactional outbox: events are written in the same transaction as the
+-- change they describe and fanned out to webhook_deliveries afterwards
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    aggregate_id UUID NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    dispatched_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_undispatched ON outbox_events(id) WHERE dispatched_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id UUID PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT NOT NULL REFERENCES outbox_events(id),
+    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (event_id, subscription_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_dead ON webhook_deliveries(created_at) WHERE status = 'dead';
+
+-- Every status a transaction went through. from_status is NULL for the
+-- status it was created with.
+CREATE TABLE IF NOT EXISTS transaction_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20) NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_status_history_transaction_id ON transaction_status_history(transaction_id, id);
+
+-- Every status a hold went through, checked against the same state machine
+-- as transactions. from_status is NULL for the status it was created with.
+CREATE TABLE IF NOT EXISTS hold_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    hold_id UUID NOT NULL REFERENCES payment_holds(id) ON DELETE CASCADE,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20) NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_hold_status_history_hold_id ON hold_status_history(hold_id, id);
+
+-- Keyset pagination of a user's transactions on (created_at, id)
+CREATE INDEX IF NOT EXISTS idx_transactions_user_id_created_at_id ON transactions(user_id, created_at DESC, id DESC);
+
+-- Exchange rates between currencies, read by the FX quotes. A pair is also
+-- used in the opposite direction at the inverse rate when only one is listed.
+CREATE TABLE IF NOT EXISTS fx_rates (
+    base_currency CHAR(3) NOT NULL,
+    quote_currency CHAR(3) NOT NULL,
+    rate DECIMAL(20, 8) NOT NULL CHECK (rate > 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (base_currency, quote_currency)
+);
+
+INSERT INTO fx_rates (base_currency, quote_currency, rate) VALUES
+    ('USD', 'EUR', 0.92000000),
+    ('USD', 'GBP', 0.79000000),
+    ('USD', 'JPY', 150.25000000),
+    ('USD', 'ARS', 1050.00000000),
+    ('USD', 'BRL', 5.45000000),
+    ('USD', 'MXN', 17.10000000)
+ON CONFLICT (base_currency, quote_currency) DO NOTHING;
+
+-- Quotes lock an exchange rate until they expire. Executing one records the
+-- fx_out and fx_in transactions it created, which keep the applied rate.
+CREATE TABLE IF NOT EXISTS fx_quotes (
+    id UUID PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    sell_currency CHAR(3) NOT NULL,
+    sell_amount DECIMAL(19, 4) NOT NULL,
+    buy_currency CHAR(3) NOT NULL,
+    buy_amount DECIMAL(19, 4) NOT NULL,
+    rate DECIMAL(20, 8) NOT NULL,
+    outgoing_transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+    incoming_transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    executed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_fx_quotes_user_id ON fx_quotes(user_id);
+
+-- Cards tokenized by the vault. The number and holder name are only stored
+-- encrypted with AES-GCM; the last four digits and the expiry stay in clear so
+-- the card can be shown.
+CREATE TABLE IF NOT EXISTS card_vault (
+    token VARCHAR(64) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    last4 CHAR(4) NOT NULL,
+    expiry_month SMALLINT NOT NULL,
+    expiry_year SMALLINT NOT NULL,
+    encrypted_card BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_card_vault_user_id ON card_vault(user_id);